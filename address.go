@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"github.com/mutalisk999/bitcoin-lib/src/base58"
+	"github.com/mutalisk999/bitcoin-lib/src/keyid"
+	"github.com/mutalisk999/bitcoin-lib/src/script"
+	"github.com/mutalisk999/bitcoin-lib/src/utility"
+	"strings"
+)
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+func bech32ConvertBits(data []byte, fromBits uint, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<toBits - 1
+	converted := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			converted = append(converted, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			converted = append(converted, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return converted, nil
+}
+
+func bech32Encode(hrp string, data []byte, checksumConst uint32) string {
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	polymod := bech32Polymod(values) ^ checksumConst
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range data {
+		sb.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+func bech32Decode(bech string) (string, []byte, uint32, error) {
+	if len(bech) > 90 {
+		return "", nil, 0, errors.New("bech32 string too long")
+	}
+	if strings.ToLower(bech) != bech && strings.ToUpper(bech) != bech {
+		return "", nil, 0, errors.New("bech32 string with mixed case")
+	}
+	bech = strings.ToLower(bech)
+	pos := strings.LastIndexByte(bech, '1')
+	if pos < 1 || pos+7 > len(bech) {
+		return "", nil, 0, errors.New("invalid bech32 separator position")
+	}
+	hrp := bech[:pos]
+	data := make([]byte, 0, len(bech)-pos-1)
+	for i := pos + 1; i < len(bech); i++ {
+		idx := strings.IndexByte(bech32Charset, bech[i])
+		if idx < 0 {
+			return "", nil, 0, errors.New("invalid bech32 character")
+		}
+		data = append(data, byte(idx))
+	}
+	checksumConst := bech32Polymod(append(bech32HrpExpand(hrp), data...))
+	if checksumConst != bech32Const && checksumConst != bech32mConst {
+		return "", nil, 0, errors.New("invalid bech32 checksum")
+	}
+	return hrp, data[:len(data)-6], checksumConst, nil
+}
+
+// BTCEncodeSegwitAddress encodes a witness program as a BIP173 (v0) or
+// BIP350 (v1+) address.
+func BTCEncodeSegwitAddress(hrp string, witnessVersion byte, witnessProgram []byte) (string, error) {
+	if witnessVersion > 16 {
+		return "", errors.New("invalid witness version")
+	}
+	if len(witnessProgram) < 2 || len(witnessProgram) > 40 {
+		return "", errors.New("invalid witness program size")
+	}
+	if witnessVersion == 0 && len(witnessProgram) != 20 && len(witnessProgram) != 32 {
+		return "", errors.New("invalid witness v0 program size")
+	}
+	converted, err := bech32ConvertBits(witnessProgram, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	checksumConst := uint32(bech32Const)
+	if witnessVersion != 0 {
+		checksumConst = bech32mConst
+	}
+	return bech32Encode(hrp, append([]byte{witnessVersion}, converted...), checksumConst), nil
+}
+
+func BTCDecodeSegwitAddress(hrp string, addr string) (byte, []byte, error) {
+	addrHrp, data, checksumConst, err := bech32Decode(addr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if addrHrp != hrp {
+		return 0, nil, errors.New("invalid segwit address hrp")
+	}
+	if len(data) == 0 || data[0] > 16 {
+		return 0, nil, errors.New("invalid witness version")
+	}
+	witnessProgram, err := bech32ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(witnessProgram) < 2 || len(witnessProgram) > 40 {
+		return 0, nil, errors.New("invalid witness program size")
+	}
+	if data[0] == 0 && len(witnessProgram) != 20 && len(witnessProgram) != 32 {
+		return 0, nil, errors.New("invalid witness v0 program size")
+	}
+	if (data[0] == 0 && checksumConst != bech32Const) || (data[0] != 0 && checksumConst != bech32mConst) {
+		return 0, nil, errors.New("invalid checksum variant for witness version")
+	}
+	return data[0], witnessProgram, nil
+}
+
+func BTCGetP2SHScriptPubKey(redeemScript []byte) []byte {
+	bufBytes := make([]byte, 0, 23)
+	bufBytes = append(bufBytes, script.OP_HASH160, byte(keyid.KEY_ID_SIZE))
+	bufBytes = append(bufBytes, utility.Hash160(redeemScript)...)
+	bufBytes = append(bufBytes, script.OP_EQUAL)
+	return bufBytes
+}
+
+func BTCGetWitnessScriptPubKey(witnessVersion byte, witnessProgram []byte) []byte {
+	bufBytes := make([]byte, 0, 2+len(witnessProgram))
+	if witnessVersion == 0 {
+		bufBytes = append(bufBytes, script.OP_0)
+	} else {
+		bufBytes = append(bufBytes, script.OP_1+witnessVersion-1)
+	}
+	bufBytes = append(bufBytes, byte(len(witnessProgram)))
+	bufBytes = append(bufBytes, witnessProgram...)
+	return bufBytes
+}
+
+// BTCAddressFromScriptPubKey returns the address of the configured network
+// paying to scriptPubKey. Scripts without an address form return an error.
+func BTCAddressFromScriptPubKey(scriptPubKey []byte) (string, error) {
+	netParams, err := GetNetParams()
+	if err != nil {
+		return "", err
+	}
+	s := new(script.Script)
+	s.SetScriptBytes(scriptPubKey)
+
+	if s.IsPayToPubKeyHash() {
+		return base58CheckEncode(netParams.PubKeyHashAddrID, scriptPubKey[3:23]), nil
+	}
+	if s.IsPayToScriptHash() {
+		return base58CheckEncode(netParams.ScriptHashAddrID, scriptPubKey[2:22]), nil
+	}
+	isWitness, witnessVersion, witnessProgram := s.IsWitnessProgram()
+	if isWitness {
+		return BTCEncodeSegwitAddress(netParams.Bech32HRPSegwit, byte(witnessVersion), witnessProgram)
+	}
+	return "", errors.New("scriptPubKey has no address form")
+}
+
+// BTCScriptPubKeyFromAddress decodes an address of the configured network
+// into the scriptPubKey it pays to.
+func BTCScriptPubKeyFromAddress(addr string) ([]byte, error) {
+	netParams, err := GetNetParams()
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(strings.ToLower(addr), netParams.Bech32HRPSegwit+"1") {
+		witnessVersion, witnessProgram, err := BTCDecodeSegwitAddress(netParams.Bech32HRPSegwit, addr)
+		if err != nil {
+			return nil, err
+		}
+		return BTCGetWitnessScriptPubKey(witnessVersion, witnessProgram), nil
+	}
+
+	decoded, err := base58.Decode(addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) != 25 {
+		return nil, errors.New("invalid address length")
+	}
+	checksum := utility.Sha256(utility.Sha256(decoded[0:21]))
+	if string(checksum[0:4]) != string(decoded[21:25]) {
+		return nil, errors.New("invalid address checksum")
+	}
+	switch decoded[0] {
+	case netParams.PubKeyHashAddrID:
+		bufBytes := make([]byte, 0, 25)
+		bufBytes = append(bufBytes, script.OP_DUP, script.OP_HASH160, byte(keyid.KEY_ID_SIZE))
+		bufBytes = append(bufBytes, decoded[1:21]...)
+		bufBytes = append(bufBytes, script.OP_EQUALVERIFY, script.OP_CHECKSIG)
+		return bufBytes, nil
+	case netParams.ScriptHashAddrID:
+		bufBytes := make([]byte, 0, 23)
+		bufBytes = append(bufBytes, script.OP_HASH160, byte(keyid.KEY_ID_SIZE))
+		bufBytes = append(bufBytes, decoded[1:21]...)
+		bufBytes = append(bufBytes, script.OP_EQUAL)
+		return bufBytes, nil
+	}
+	return nil, errors.New("address is not for the configured network")
+}
+
+func base58CheckEncode(version byte, payload []byte) string {
+	data := make([]byte, 0, 1+len(payload)+4)
+	data = append(data, version)
+	data = append(data, payload...)
+	checksum := utility.Sha256(utility.Sha256(data))
+	data = append(data, checksum[0:4]...)
+	return base58.Encode(data)
+}
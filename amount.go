@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const SatoshiPerBTC = 100000000
+
+// Satoshi is an amount in satoshis. It is written to JSON as an integer and
+// read from either an integer of satoshis or a decimal BTC string.
+type Satoshi int64
+
+const MaxSatoshi Satoshi = 21000000 * SatoshiPerBTC
+
+// BTCString formats s as a decimal BTC amount with 8 decimals.
+func (s Satoshi) BTCString() string {
+	return fmt.Sprintf("%d.%08d", int64(s)/SatoshiPerBTC, int64(s)%SatoshiPerBTC)
+}
+
+func (s *Satoshi) UnmarshalJSON(data []byte) error {
+	var amount interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	err := decoder.Decode(&amount)
+	if err != nil {
+		return err
+	}
+	*s, err = ParseSatoshi(amount)
+	return err
+}
+
+// ParseSatoshi reads an amount given as a decimal BTC string or as an
+// integer of satoshis, as a float64 or json.Number from decoded JSON.
+func ParseSatoshi(amount interface{}) (Satoshi, error) {
+	switch v := amount.(type) {
+	case string:
+		satoshi, err := ParseBTCAmount(v)
+		return Satoshi(satoshi), err
+	case json.Number:
+		satoshi, err := strconv.ParseInt(v.String(), 10, 64)
+		if err != nil || satoshi < 0 || Satoshi(satoshi) > MaxSatoshi {
+			return 0, errors.New("invalid satoshi amount: " + v.String())
+		}
+		return Satoshi(satoshi), nil
+	case float64:
+		if v < 0 || v != float64(int64(v)) || Satoshi(v) > MaxSatoshi {
+			return 0, fmt.Errorf("invalid satoshi amount: %v", v)
+		}
+		return Satoshi(v), nil
+	}
+	return 0, fmt.Errorf("amount must be a BTC string or an integer of satoshis, not %T", amount)
+}
+
+// ParseBTCAmount converts a decimal BTC amount to satoshis. Only digits with
+// at most 8 decimals are accepted.
+func ParseBTCAmount(amount string) (int64, error) {
+	parts := strings.SplitN(amount, ".", 2)
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+		if fraction == "" {
+			return 0, errors.New("invalid amount: " + amount)
+		}
+	}
+	if !isDigits(parts[0]) || !isDigits(fraction) || len(fraction) > 8 || len(parts[0]) > 8 {
+		return 0, errors.New("invalid amount: " + amount)
+	}
+	fraction += strings.Repeat("0", 8-len(fraction))
+	whole, _ := strconv.ParseInt(parts[0], 10, 64)
+	frac, _ := strconv.ParseInt(fraction, 10, 64)
+	satoshi := whole*SatoshiPerBTC + frac
+	if parts[0] == "" || Satoshi(satoshi) > MaxSatoshi {
+		return 0, errors.New("invalid amount: " + amount)
+	}
+	return satoshi, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseBTCAmount(t *testing.T) {
+	for amount, expected := range map[string]int64{
+		"0":           0,
+		"1":           100000000,
+		"0.08":        8000000,
+		"0.00000546":  546,
+		"20999999.99": 2099999999000000,
+		"6.25000000":  625000000,
+		"21000000":    2100000000000000,
+	} {
+		satoshi, err := ParseBTCAmount(amount)
+		if err != nil || satoshi != expected {
+			t.Error("unexpected amount of", amount, satoshi, err)
+		}
+	}
+	for _, invalid := range []string{"", ".5", "-1", "1.123456789", "1e8", "abc", "21000001", "21000000.00000001",
+		"1.", "1.-5", "1.+5", " 1", "1 ", "0x10", "1,5"} {
+		if _, err := ParseBTCAmount(invalid); err == nil {
+			t.Error("invalid amount accepted:", invalid)
+		}
+	}
+}
+
+func TestSatoshiJSON(t *testing.T) {
+	var detail UTXODetail
+	for input, expected := range map[string]Satoshi{
+		`{"amount":"0.5"}`:        50000000,
+		`{"amount":50000000}`:     50000000,
+		`{"amount":"0.00000001"}`: 1,
+		`{"amount":0}`:            0,
+	} {
+		err := json.Unmarshal([]byte(input), &detail)
+		if err != nil || detail.Amount != expected {
+			t.Error("unexpected amount of", input, detail.Amount, err)
+		}
+	}
+	for _, invalid := range []string{`{"amount":0.5}`, `{"amount":1e8}`, `{"amount":-1}`, `{"amount":"0.000000001"}`,
+		`{"amount":2100000000000001}`, `{"amount":true}`, `{"amount":null}`} {
+		if err := json.Unmarshal([]byte(invalid), &detail); err == nil {
+			t.Error("invalid amount accepted:", invalid)
+		}
+	}
+
+	out, _ := json.Marshal(UTXODetail{Amount: 12345})
+	var decoded map[string]interface{}
+	_ = json.Unmarshal(out, &decoded)
+	if decoded["amount"] != float64(12345) {
+		t.Error("amount not written as satoshis", string(out))
+	}
+	if Satoshi(625000001).BTCString() != "6.25000001" || Satoshi(0).BTCString() != "0.00000000" {
+		t.Error("unexpected BTC string")
+	}
+}
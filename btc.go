@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/mutalisk999/bitcoin-lib/src/base58"
 	"github.com/mutalisk999/bitcoin-lib/src/blob"
 	"github.com/mutalisk999/bitcoin-lib/src/keyid"
@@ -18,16 +20,16 @@ import (
 )
 
 type UTXODetail struct {
-	TxId          string `json:"txid"`
-	Vout          int    `json:"vout"`
-	Address       string `json:"address"`
-	Account       string `json:"account"`
-	ScriptPubKey  string `json:"scriptPubKey"`
-	RedeemScript  string `json:"redeemScript"`
-	Amount        int64  `json:"amount"`
-	Confirmations int    `json:"confirmations"`
-	Spendable     bool   `json:"spendable"`
-	Solvable      bool   `json:"solvable"`
+	TxId          string  `json:"txid"`
+	Vout          int     `json:"vout"`
+	Address       string  `json:"address"`
+	Account       string  `json:"account"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	RedeemScript  string  `json:"redeemScript"`
+	Amount        Satoshi `json:"amount"`
+	Confirmations int     `json:"confirmations"`
+	Spendable     bool    `json:"spendable"`
+	Solvable      bool    `json:"solvable"`
 }
 type UTXOsDetail []UTXODetail
 
@@ -133,21 +135,45 @@ func BTCGenerateNewAddress() (string, string, string, string, error) {
 }
 
 func BTCUnPackRawTransaction(rawTrx string) (*transaction.Transaction, error) {
-	Blob := new(blob.Byteblob)
-	err := Blob.SetHex(rawTrx)
+	trxBytes, err := hex.DecodeString(rawTrx)
 	if err != nil {
 		return nil, err
 	}
-	bytesBuf := bytes.NewBuffer(Blob.GetData())
-	bufReader := io.Reader(bytesBuf)
+	return unpackTransaction(trxBytes)
+}
+
+// unpackTransaction decodes a serialized transaction. bitcoin-lib allocates
+// whatever a malformed length claims and accepts short reads, so the
+// encoding is checked first by the btcd decoder, which bounds lengths by
+// the message size and must consume all of data.
+func unpackTransaction(data []byte) (*transaction.Transaction, error) {
+	reader := bytes.NewReader(data)
+	err := wire.NewMsgTx(0).Deserialize(reader)
+	if err != nil || reader.Len() != 0 {
+		return nil, errors.New("invalid transaction encoding")
+	}
 	trx := new(transaction.Transaction)
-	err = trx.UnPack(bufReader)
+	_, err = safeUnPack(data, trx.UnPack)
 	if err != nil {
 		return nil, err
 	}
 	return trx, nil
 }
 
+// safeUnPack runs unpack over data and returns the count of bytes left.
+// The decoders of bitcoin-lib panic on malformed lengths, which is
+// reported as an error instead.
+func safeUnPack(data []byte, unpack func(io.Reader) error) (rest int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid encoding: %v", r)
+		}
+	}()
+	reader := bytes.NewReader(data)
+	err = unpack(reader)
+	return reader.Len(), err
+}
+
 func BTCPackRawTransaction(trxSig transaction.Transaction) (string, error) {
 	bytesBuf := bytes.NewBuffer([]byte{})
 	bufWriter := io.Writer(bytesBuf)
@@ -158,6 +184,22 @@ func BTCPackRawTransaction(trxSig transaction.Transaction) (string, error) {
 	return hex.EncodeToString(bytesBuf.Bytes()), nil
 }
 
+// BTCPushData returns the minimal script push of data.
+func BTCPushData(data []byte) []byte {
+	pushed := make([]byte, 0, len(data)+5)
+	switch {
+	case len(data) < int(script.OP_PUSHDATA1):
+		pushed = append(pushed, byte(len(data)))
+	case len(data) <= 0xff:
+		pushed = append(pushed, script.OP_PUSHDATA1, byte(len(data)))
+	case len(data) <= 0xffff:
+		pushed = append(pushed, script.OP_PUSHDATA2, byte(len(data)), byte(len(data)>>8))
+	default:
+		pushed = append(pushed, script.OP_PUSHDATA4, byte(len(data)), byte(len(data)>>8), byte(len(data)>>16), byte(len(data)>>24))
+	}
+	return append(pushed, data...)
+}
+
 func BTCCombineSignatureAndPubKey(signature []byte, pubKey []byte) []byte {
 	scriptSig := make([]byte, 0, 1+len(signature)+1+len(pubKey))
 	scriptSig = append(scriptSig, byte(len(signature)))
@@ -265,94 +307,323 @@ func BTCGetP2PKHScriptPubKey(pubKeyStr string) ([]byte, error) {
 	return bufBytes, nil
 }
 
-func BTCSignRawTransaction(rawTrx string, privKeyStr string, utxos []UTXODetail) (string, error) {
-	privKeyBytes, err := hex.DecodeString(privKeyStr)
-	if err != nil {
-		return "", err
+func findUtxoDetail(utxos []UTXODetail, txId string, vout uint32) *UTXODetail {
+	for i := range utxos {
+		if utxos[i].TxId == txId && utxos[i].Vout == int(vout) {
+			return &utxos[i]
+		}
 	}
+	return nil
+}
 
-	_, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), privKeyBytes)
-	pubKeyBytes := pubKey.SerializeUncompressed()[1:]
+// inputHashType picks the sighash type of input idx: a single entry applies
+// to every input, otherwise there must be one entry per input.
+func inputHashType(hashTypes []uint32, idx int, inputCount int, defaultHashType uint32) (uint32, error) {
+	if len(hashTypes) == 0 {
+		return defaultHashType, nil
+	}
+	if len(hashTypes) == 1 {
+		return hashTypes[0], nil
+	}
+	if len(hashTypes) != inputCount {
+		return 0, errors.New("sighash types count does not match inputs count")
+	}
+	return hashTypes[idx], nil
+}
 
-	pubkeyCompress, err := BTCGetCompressPubKey(pubKeyBytes)
-	if err != nil {
-		return "", err
+// utxoScriptPubKey returns the scriptPubKey of utxo, derived from its
+// address when not given, or nil when utxo is unknown.
+func utxoScriptPubKey(utxo *UTXODetail) ([]byte, error) {
+	if utxo == nil {
+		return nil, nil
 	}
+	if utxo.ScriptPubKey != "" {
+		return hex.DecodeString(utxo.ScriptPubKey)
+	}
+	if utxo.Address != "" {
+		return BTCScriptPubKeyFromAddress(utxo.Address)
+	}
+	return nil, nil
+}
 
-	Info.Println("rawTrxStr:", rawTrx)
+// prevOutsFromUtxos returns the spent outputs of all inputs, or nil when
+// utxos do not describe every input.
+func prevOutsFromUtxos(trx *transaction.Transaction, utxos []UTXODetail) []transaction.TxOut {
+	prevOuts := make([]transaction.TxOut, len(trx.Vin))
+	for i, vin := range trx.Vin {
+		utxoDetail := findUtxoDetail(utxos, vin.PrevOut.Hash.GetHex(), vin.PrevOut.N)
+		scriptPubKey, err := utxoScriptPubKey(utxoDetail)
+		if err != nil || scriptPubKey == nil {
+			return nil
+		}
+		prevOuts[i].Value = int64(utxoDetail.Amount)
+		prevOuts[i].ScriptPubKey.SetScriptBytes(scriptPubKey)
+	}
+	return prevOuts
+}
 
-	trx, err := BTCUnPackRawTransaction(rawTrx)
+func btcSignECDSA(privKeyBytes []byte, pubkeyCompress []byte, hashBytes []byte, hashType uint32) ([]byte, error) {
+	signedData, err := BTCCoinSignTrx(privKeyBytes, hashBytes)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	verifyOk, err := BTCCoinVerifyTrx(pubkeyCompress, hashBytes, signedData)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyOk {
+		return nil, errors.New("verify signature error")
 	}
 
-	signedDataList := make([][]byte, len(trx.Vin))
+	Info.Println("signedDataStr:", hex.EncodeToString(signedData))
 
-	// add scriptPubKey
-	for i := 0; i < len(trx.Vin); i++ {
-		trxTemp, err := BTCUnPackRawTransaction(rawTrx)
+	// append sighash type
+	signedData = append(signedData, byte(hashType))
+	return signedData, nil
+}
+
+// BTCSignInput signs input idx of trx in place with privKeyBytes. utxo
+// describes the spent output; without it the input is treated as P2PKH.
+// P2PKH, P2WPKH, P2SH-P2WPKH and P2TR key path spends are supported.
+func BTCSignInput(trx *transaction.Transaction, idx int, privKeyBytes []byte, utxo *UTXODetail, prevOuts []transaction.TxOut, hashTypes []uint32) error {
+	_, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), privKeyBytes)
+	pubkeyCompress := pubKey.SerializeCompressed()
+	pubKeyHash := utility.Hash160(pubkeyCompress)
+
+	p2pkhScriptPubKey, err := BTCGetP2PKHScriptPubKey(hex.EncodeToString(pubKey.SerializeUncompressed()[1:]))
+	if err != nil {
+		return err
+	}
+	scriptPubKey, err := utxoScriptPubKey(utxo)
+	if err != nil {
+		return err
+	}
+	if scriptPubKey == nil {
+		scriptPubKey = p2pkhScriptPubKey
+	}
+	p2wpkhScriptPubKey := BTCGetWitnessScriptPubKey(0, pubKeyHash)
+
+	switch {
+	case bytes.Equal(scriptPubKey, p2pkhScriptPubKey):
+		hashType, err := inputHashType(hashTypes, idx, len(trx.Vin), SIGHASH_ALL)
 		if err != nil {
-			return "", err
+			return err
 		}
-
-		p2pkhScriptPubKey, err := BTCGetP2PKHScriptPubKey(hex.EncodeToString(pubKeyBytes))
+		hashBytes, err := BTCCalcLegacySigHash(trx, idx, p2pkhScriptPubKey, hashType)
 		if err != nil {
-			return "", err
+			return err
 		}
-		trxTemp.Vin[i].ScriptSig.SetScriptBytes(p2pkhScriptPubKey)
+		signedData, err := btcSignECDSA(privKeyBytes, pubkeyCompress, hashBytes, hashType)
+		if err != nil {
+			return err
+		}
+		trx.Vin[idx].ScriptSig.SetScriptBytes(BTCCombineSignatureAndPubKey(signedData, pubkeyCompress))
+		trx.Vin[idx].ScriptWitness.SetScriptWitnessBytes(nil)
+		return nil
 
-		rawTrxWithScript, err := BTCPackRawTransaction(*trxTemp)
+	case bytes.Equal(scriptPubKey, p2wpkhScriptPubKey),
+		bytes.Equal(scriptPubKey, BTCGetP2SHScriptPubKey(p2wpkhScriptPubKey)):
+		hashType, err := inputHashType(hashTypes, idx, len(trx.Vin), SIGHASH_ALL)
 		if err != nil {
-			return "", err
+			return err
 		}
+		hashBytes, err := BTCCalcWitnessV0SigHash(trx, idx, p2pkhScriptPubKey, int64(utxo.Amount), hashType)
+		if err != nil {
+			return err
+		}
+		signedData, err := btcSignECDSA(privKeyBytes, pubkeyCompress, hashBytes, hashType)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(scriptPubKey, p2wpkhScriptPubKey) {
+			trx.Vin[idx].ScriptSig.SetScriptBytes([]byte{})
+		} else {
+			trx.Vin[idx].ScriptSig.SetScriptBytes(BTCPushData(p2wpkhScriptPubKey))
+		}
+		trx.Vin[idx].ScriptWitness.SetScriptWitnessBytes([][]byte{signedData, pubkeyCompress})
+		return nil
+	}
 
-		rawTrxBytes, err := hex.DecodeString(rawTrxWithScript)
+	outputKey, err := BTCTaprootTweakPubKey(BTCXOnlyPubKey(pubKey), nil)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(scriptPubKey, BTCGetWitnessScriptPubKey(1, outputKey)) {
+		if prevOuts == nil {
+			return errors.New("taproot input needs the scriptPubKey and amount of every input")
+		}
+		hashType, err := inputHashType(hashTypes, idx, len(trx.Vin), SIGHASH_DEFAULT)
 		if err != nil {
-			return "", err
+			return err
 		}
-		// append SIGHASH_ALL
-		rawTrxBytes = append(rawTrxBytes, []byte{0x1, 0x0, 0x0, 0x0}...)
-		hashBytes := utility.Sha256(utility.Sha256(rawTrxBytes))
+		hashBytes, err := BTCCalcTaprootSigHash(trx, idx, prevOuts, hashType)
+		if err != nil {
+			return err
+		}
+		tweakedPrivKey, err := BTCTaprootTweakPrivKey(privKeyBytes, nil)
+		if err != nil {
+			return err
+		}
+		auxRand := make([]byte, 32)
+		_, err = rand.Read(auxRand)
+		if err != nil {
+			return err
+		}
+		signedData, err := BTCSchnorrSign(tweakedPrivKey, hashBytes, auxRand)
+		if err != nil {
+			return err
+		}
+		if !BTCSchnorrVerify(outputKey, hashBytes, signedData) {
+			return errors.New("verify signature error")
+		}
+		Info.Println("signedDataStr:", hex.EncodeToString(signedData))
+		if hashType != SIGHASH_DEFAULT {
+			signedData = append(signedData, byte(hashType))
+		}
+		trx.Vin[idx].ScriptSig.SetScriptBytes([]byte{})
+		trx.Vin[idx].ScriptWitness.SetScriptWitnessBytes([][]byte{signedData})
+		return nil
+	}
 
-		//Info.Println("rawTrxBytes:", hex.EncodeToString(rawTrxBytes))
-		//Info.Println("hashBytes:", hex.EncodeToString(hashBytes))
+	return fmt.Errorf("input %d: scriptPubKey does not belong to the private key", idx)
+}
 
-		// signature
-		signedData, err := BTCCoinSignTrx(privKeyBytes, hashBytes)
-		if err != nil {
-			return "", err
+// BTCKeyScriptPubKeys returns the scriptPubKeys of the P2PKH, P2WPKH,
+// P2SH-P2WPKH and P2TR outputs spendable by privKeyBytes.
+func BTCKeyScriptPubKeys(privKeyBytes []byte) ([][]byte, error) {
+	_, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), privKeyBytes)
+	pubKeyHash := utility.Hash160(pubKey.SerializeCompressed())
+
+	p2pkhScriptPubKey, err := BTCGetP2PKHScriptPubKey(hex.EncodeToString(pubKey.SerializeUncompressed()[1:]))
+	if err != nil {
+		return nil, err
+	}
+	p2wpkhScriptPubKey := BTCGetWitnessScriptPubKey(0, pubKeyHash)
+	outputKey, err := BTCTaprootTweakPubKey(BTCXOnlyPubKey(pubKey), nil)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{p2pkhScriptPubKey, p2wpkhScriptPubKey,
+		BTCGetP2SHScriptPubKey(p2wpkhScriptPubKey), BTCGetWitnessScriptPubKey(1, outputKey)}, nil
+}
+
+// BTCKeyOwnsAddress reports whether privKeyStr can spend outputs paying to addr.
+func BTCKeyOwnsAddress(privKeyStr string, addr string) (bool, error) {
+	privKeyBytes, err := hex.DecodeString(privKeyStr)
+	if err != nil {
+		return false, err
+	}
+	scriptPubKey, err := BTCScriptPubKeyFromAddress(addr)
+	if err != nil {
+		return false, err
+	}
+	scriptPubKeys, err := BTCKeyScriptPubKeys(privKeyBytes)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range scriptPubKeys {
+		if bytes.Equal(s, scriptPubKey) {
+			return true, nil
 		}
+	}
+	return false, nil
+}
 
-		verifyOk, err := BTCCoinVerifyTrx(pubkeyCompress, hashBytes, signedData)
+// BTCSignRawTransaction signs every input of rawTrx with privKeyStr, see
+// BTCSignRawTransactionWithKeys.
+func BTCSignRawTransaction(rawTrx string, privKeyStr string, utxos []UTXODetail, hashTypes ...uint32) (string, error) {
+	trxSigStr, _, err := BTCSignRawTransactionWithKeys(rawTrx, []string{privKeyStr}, utxos, hashTypes...)
+	return trxSigStr, err
+}
+
+// BTCSignRawTransactionWithKeys signs the inputs of rawTrx whose prevout
+// scriptPubKey (taken from utxos) belongs to one of privKeyStrs and leaves
+// all other inputs untouched, so the result can be passed on for further
+// signatures. Inputs missing from utxos are left untouched as well; at
+// least one input has to be signed. hashTypes optionally selects the sighash type, either
+// one for all inputs or one per input; the default is SIGHASH_ALL
+// (SIGHASH_DEFAULT for taproot). Every signed input is run through the
+// script interpreter and the per-input report is returned; the signed
+// transaction is withheld when any input fails verification.
+func BTCSignRawTransactionWithKeys(rawTrx string, privKeyStrs []string, utxos []UTXODetail, hashTypes ...uint32) (string, []InputVerification, error) {
+	keyByScriptPubKey := make(map[string][]byte)
+	for _, privKeyStr := range privKeyStrs {
+		privKeyBytes, err := hex.DecodeString(privKeyStr)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
-		if !verifyOk {
-			return "", errors.New("verify signature error")
+		scriptPubKeys, err := BTCKeyScriptPubKeys(privKeyBytes)
+		if err != nil {
+			return "", nil, err
 		}
+		for _, scriptPubKey := range scriptPubKeys {
+			keyByScriptPubKey[string(scriptPubKey)] = privKeyBytes
+		}
+	}
 
-		Info.Println("signedDataStr:", hex.EncodeToString(signedData))
+	Info.Println("rawTrxStr:", rawTrx)
 
-		// append SIGHASH_ALL
-		signedData = append(signedData, 0x1)
+	trx, err := BTCUnPackRawTransaction(rawTrx)
+	if err != nil {
+		return "", nil, err
+	}
 
-		scriptSig := BTCCombineSignatureAndPubKey(signedData, pubkeyCompress)
+	prevOuts := prevOutsFromUtxos(trx, utxos)
+	prevScripts := make([][]byte, len(trx.Vin))
+	amounts := make([]int64, len(trx.Vin))
+	for i := 0; i < len(trx.Vin); i++ {
+		utxoDetail := findUtxoDetail(utxos, trx.Vin[i].PrevOut.Hash.GetHex(), trx.Vin[i].PrevOut.N)
+
+		var privKeyBytes []byte
+		scriptPubKey, err := utxoScriptPubKey(utxoDetail)
+		if err != nil {
+			return "", nil, err
+		}
+		if scriptPubKey != nil {
+			privKeyBytes = keyByScriptPubKey[string(scriptPubKey)]
+		}
+		if privKeyBytes == nil {
+			Info.Printf("input %d: no matching key, left unsigned", i)
+			continue
+		}
 
-		signedDataList[i] = scriptSig
+		err = BTCSignInput(trx, i, privKeyBytes, utxoDetail, prevOuts, hashTypes)
+		if err != nil {
+			return "", nil, err
+		}
+		prevScripts[i] = scriptPubKey
+		if utxoDetail != nil {
+			amounts[i] = int64(utxoDetail.Amount)
+		}
 	}
 
-	for i := 0; i < len(trx.Vin); i++ {
-		trx.Vin[i].ScriptSig.SetScriptBytes(signedDataList[i])
+	report, err := BTCVerifyTransactionInputs(trx, prevScripts, amounts, prevOuts)
+	if err != nil {
+		return "", nil, err
+	}
+	err = VerificationError(report)
+	if err != nil {
+		return "", report, err
+	}
+	signed := 0
+	for _, v := range report {
+		if v.Status == InputVerifyStatusVerified {
+			signed++
+		}
+	}
+	if signed == 0 {
+		return "", report, errors.New("no input matches the private keys")
 	}
 
 	trxSigStr, err := BTCPackRawTransaction(*trx)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	Info.Println("rawTrxSignedStr:", trxSigStr)
 
-	return trxSigStr, nil
+	return trxSigStr, report, nil
 }
 
 func BTCGetRedeemScriptByPubKeys(needCount int, pubKeyStrList []string) (string, error) {
@@ -432,48 +703,59 @@ func BTCGetMultiSignAddressByRedeemScript(redeemScriptStr string) (string, error
 }
 
 func BTCCombineSignatureAndRedeemScript(signature []byte, redeemScriptBytes []byte) ([]byte, error) {
-	bytesBuf := bytes.NewBuffer([]byte{})
-	bufWriter := io.Writer(bytesBuf)
-	err := serialize.PackUint8(bufWriter, script.OP_0)
+	scriptSig := make([]byte, 0, 1+len(signature)+len(redeemScriptBytes)+6)
+	scriptSig = append(scriptSig, script.OP_0)
+	scriptSig = append(scriptSig, BTCPushData(signature)...)
+	scriptSig = append(scriptSig, BTCPushData(redeemScriptBytes)...)
+	return scriptSig, nil
+}
+
+// multiSigPrevOut returns the scriptPubKey and amount spent by input idx,
+// assuming a legacy P2SH output of redeemScriptBytes when utxos do not
+// describe it.
+func multiSigPrevOut(trx *transaction.Transaction, idx int, redeemScriptBytes []byte, utxos []UTXODetail) ([]byte, int64, error) {
+	utxoDetail := findUtxoDetail(utxos, trx.Vin[idx].PrevOut.Hash.GetHex(), trx.Vin[idx].PrevOut.N)
+	scriptPubKey, err := utxoScriptPubKey(utxoDetail)
+	if err != nil {
+		return nil, 0, err
+	}
+	if scriptPubKey == nil {
+		return BTCGetP2SHScriptPubKey(redeemScriptBytes), 0, nil
+	}
+	return scriptPubKey, int64(utxoDetail.Amount), nil
+}
+
+// BTCVerifyMultiSignTransaction runs every input of trxStr, spending the
+// multisig redeemScriptStr, through the script interpreter.
+func BTCVerifyMultiSignTransaction(trxStr string, redeemScriptStr string, utxos []UTXODetail) ([]InputVerification, error) {
+	redeemScriptBytes, err := hex.DecodeString(redeemScriptStr)
 	if err != nil {
 		return nil, err
 	}
-	signatureScript := new(script.Script)
-	signatureScript.SetScriptBytes(signature)
-	err = signatureScript.Pack(bufWriter)
+	trx, err := BTCUnPackRawTransaction(trxStr)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(redeemScriptBytes) < int(script.OP_PUSHDATA1) {
-	} else {
-		opPushData := uint8(0)
-		if len(redeemScriptBytes) <= 0xff {
-			opPushData = script.OP_PUSHDATA1
-		} else if len(redeemScriptBytes) <= 0xffff {
-			opPushData = script.OP_PUSHDATA2
-		} else {
-			opPushData = script.OP_PUSHDATA4
-		}
-		err = serialize.PackUint8(bufWriter, opPushData)
+	prevScripts := make([][]byte, len(trx.Vin))
+	amounts := make([]int64, len(trx.Vin))
+	for i := range trx.Vin {
+		prevScripts[i], amounts[i], err = multiSigPrevOut(trx, i, redeemScriptBytes, utxos)
 		if err != nil {
 			return nil, err
 		}
 	}
-
-	redeemScrip := new(script.Script)
-	redeemScrip.SetScriptBytes(redeemScriptBytes)
-	err = redeemScrip.Pack(bufWriter)
-	if err != nil {
-		return nil, err
-	}
-	return bytesBuf.Bytes(), nil
+	return BTCVerifyTransactionInputs(trx, prevScripts, amounts, nil)
 }
 
-func BTCMultiSignRawTransaction(rawTrx string, redeemScriptStr string, privKeyStr string, utxos []UTXODetail) (string, error) {
+// BTCMultiSignRawTransaction adds the signature of privKeyStr to every
+// input spending the multisig redeemScriptStr. Inputs whose utxo is a
+// P2WSH or P2SH-P2WSH output are signed per BIP143, others as legacy P2SH.
+// The result is checked with the script interpreter; inputs still missing
+// signatures are reported as partial.
+func BTCMultiSignRawTransaction(rawTrx string, redeemScriptStr string, privKeyStr string, utxos []UTXODetail, hashTypes ...uint32) (string, []InputVerification, error) {
 	privKeyBytes, err := hex.DecodeString(privKeyStr)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	_, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), privKeyBytes)
@@ -482,7 +764,7 @@ func BTCMultiSignRawTransaction(rawTrx string, redeemScriptStr string, privKeySt
 	pubkeyCompress, err := BTCGetCompressPubKey(pubKeyBytes)
 	if err != nil {
 		Error.Println("BTCGetCompressPubKey fail:", err.Error())
-		return "", err
+		return "", nil, err
 	}
 
 	Info.Println("rawTrxStr:", rawTrx)
@@ -490,86 +772,86 @@ func BTCMultiSignRawTransaction(rawTrx string, redeemScriptStr string, privKeySt
 	redeemScriptBytes, err := hex.DecodeString(redeemScriptStr)
 	if err != nil {
 		Error.Println("DecodeString redeemScriptStr fail:", err.Error())
-		return "", err
+		return "", nil, err
 	}
+	p2wshScriptPubKey := BTCGetWitnessScriptPubKey(0, utility.Sha256(redeemScriptBytes))
+	p2shP2wshScriptPubKey := BTCGetP2SHScriptPubKey(p2wshScriptPubKey)
 
 	trx, err := BTCUnPackRawTransaction(rawTrx)
 	if err != nil {
 		Error.Println("BTCUnPackRawTransaction rawTrx fail:", err.Error())
-		return "", err
+		return "", nil, err
 	}
 
-	signedDataList := make([][]byte, len(trx.Vin))
-
-	// add scriptPubKey
+	prevScripts := make([][]byte, len(trx.Vin))
+	amounts := make([]int64, len(trx.Vin))
 	for i := 0; i < len(trx.Vin); i++ {
-		trxTemp, err := BTCUnPackRawTransaction(rawTrx)
+		hashType, err := inputHashType(hashTypes, i, len(trx.Vin), SIGHASH_ALL)
 		if err != nil {
-			Error.Println("BTCUnPackRawTransaction rawTrx fail:", err.Error())
-			return "", err
+			return "", nil, err
 		}
 
-		trxTemp.Vin[i].ScriptSig.SetScriptBytes(redeemScriptBytes)
-
-		rawTrxWithScript, err := BTCPackRawTransaction(*trxTemp)
+		scriptPubKey, amount, err := multiSigPrevOut(trx, i, redeemScriptBytes, utxos)
 		if err != nil {
-			Error.Println("BTCPackRawTransaction fail:", err.Error())
-			return "", err
+			Error.Println("multiSigPrevOut fail:", err.Error())
+			return "", nil, err
 		}
+		prevScripts[i], amounts[i] = scriptPubKey, amount
+		isWitness := bytes.Equal(scriptPubKey, p2wshScriptPubKey) || bytes.Equal(scriptPubKey, p2shP2wshScriptPubKey)
 
-		rawTrxBytes, err := hex.DecodeString(rawTrxWithScript)
+		var hashBytes []byte
+		if isWitness {
+			hashBytes, err = BTCCalcWitnessV0SigHash(trx, i, redeemScriptBytes, amount, hashType)
+		} else {
+			hashBytes, err = BTCCalcLegacySigHash(trx, i, redeemScriptBytes, hashType)
+		}
 		if err != nil {
-			Error.Println("DecodeString rawTrxWithScript fail:", err.Error())
-			return "", err
+			Error.Println("calc signature hash fail:", err.Error())
+			return "", nil, err
 		}
-		// append SIGHASH_ALL
-		rawTrxBytes = append(rawTrxBytes, []byte{0x1, 0x0, 0x0, 0x0}...)
-		hashBytes := utility.Sha256(utility.Sha256(rawTrxBytes))
-
-		//Info.Println("rawTrxBytes:", hex.EncodeToString(rawTrxBytes))
-		//Info.Println("hashBytes:", hex.EncodeToString(hashBytes))
 
 		// signature
-		signedData, err := BTCCoinSignTrx(privKeyBytes, hashBytes)
+		signedData, err := btcSignECDSA(privKeyBytes, pubkeyCompress, hashBytes, hashType)
 		if err != nil {
-			Error.Println("BTCCoinSignTrx fail:", err.Error())
-			return "", err
+			Error.Println("btcSignECDSA fail:", err.Error())
+			return "", nil, err
 		}
 
-		verifyOk, err := BTCCoinVerifyTrx(pubkeyCompress, hashBytes, signedData)
-		if err != nil {
-			Error.Println("BTCCoinVerifyTrx fail:", err.Error())
-			return "", err
-		}
-		if !verifyOk {
-			Error.Println("verify signature error")
-			return "", errors.New("verify signature error")
+		if isWitness {
+			if bytes.Equal(scriptPubKey, p2wshScriptPubKey) {
+				trx.Vin[i].ScriptSig.SetScriptBytes([]byte{})
+			} else {
+				trx.Vin[i].ScriptSig.SetScriptBytes(BTCPushData(p2wshScriptPubKey))
+			}
+			trx.Vin[i].ScriptWitness.SetScriptWitnessBytes([][]byte{{}, signedData, redeemScriptBytes})
+			continue
 		}
 
-		Info.Println("signedDataStr:", hex.EncodeToString(signedData))
-
-		// append SIGHASH_ALL
-		signedData = append(signedData, 0x1)
-
 		scriptSig, err := BTCCombineSignatureAndRedeemScript(signedData, redeemScriptBytes)
 		if err != nil {
 			Error.Println("BTCCombineSignatureAndRedeemScript fail:", err.Error())
-			return "", err
+			return "", nil, err
 		}
-		signedDataList[i] = scriptSig
+		trx.Vin[i].ScriptSig.SetScriptBytes(scriptSig)
 	}
 
-	for i := 0; i < len(trx.Vin); i++ {
-		trx.Vin[i].ScriptSig.SetScriptBytes(signedDataList[i])
+	report, err := BTCVerifyTransactionInputs(trx, prevScripts, amounts, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	err = VerificationError(report)
+	if err != nil {
+		Error.Println("BTCVerifyTransactionInputs fail:", err.Error())
+		return "", report, err
 	}
 
 	trxSigStr, err := BTCPackRawTransaction(*trx)
 	if err != nil {
 		Error.Println("BTCPackRawTransaction fail:", err.Error())
-		return "", err
+		return "", nil, err
 	}
 
 	fmt.Println("rawTrxSignedStr:", trxSigStr)
 
-	return trxSigStr, nil
+	return trxSigStr, report, nil
 }
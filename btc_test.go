@@ -104,19 +104,19 @@ func TestBTCMultiSignRawTransaction(t *testing.T) {
 	privKeyEncryptBytes1, _ := hex.DecodeString(privKeyEncryptHexStr1)
 	privKeyHexStr1 := string(AesDecrypt(privKeyEncryptBytes1, []byte(SecurityPassStr)))
 
-	trxSignedData1, _ := BTCMultiSignRawTransaction(rawTrxStr, redeemScript, privKeyHexStr1, utxos)
+	trxSignedData1, _, _ := BTCMultiSignRawTransaction(rawTrxStr, redeemScript, privKeyHexStr1, utxos)
 	fmt.Println("trxSignedData1:", trxSignedData1)
 
 	privKeyEncryptBytes2, _ := hex.DecodeString(privKeyEncryptHexStr2)
 	privKeyHexStr2 := string(AesDecrypt(privKeyEncryptBytes2, []byte(SecurityPassStr)))
 
-	trxSignedData2, _ := BTCMultiSignRawTransaction(rawTrxStr, redeemScript, privKeyHexStr2, utxos)
+	trxSignedData2, _, _ := BTCMultiSignRawTransaction(rawTrxStr, redeemScript, privKeyHexStr2, utxos)
 	fmt.Println("trxSignedData2:", trxSignedData2)
 
 	privKeyEncryptBytes3, _ := hex.DecodeString(privKeyEncryptHexStr3)
 	privKeyHexStr3 := string(AesDecrypt(privKeyEncryptBytes3, []byte(SecurityPassStr)))
 
-	trxSignedData3, _ := BTCMultiSignRawTransaction(rawTrxStr, redeemScript, privKeyHexStr3, utxos)
+	trxSignedData3, _, _ := BTCMultiSignRawTransaction(rawTrxStr, redeemScript, privKeyHexStr3, utxos)
 	fmt.Println("trxSignedData3:", trxSignedData3)
 }
 
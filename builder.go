@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/mutalisk999/bitcoin-lib/src/transaction"
+	"math"
+	"sort"
+)
+
+const (
+	// nSequence of the inputs of built transactions, opting in to BIP125
+	// replacement
+	SequenceRBF uint32 = 0xfffffffd
+	// outputs below this amount in satoshis are not relayed
+	DustLimit int64 = 546
+	// minimum relay and BIP125 incremental relay fee rate, sat/vB
+	MinRelayFeeRate = 1.0
+)
+
+type TxBuildOutput struct {
+	ScriptPubKey []byte
+	Amount       int64
+}
+
+// FundedTransaction is the result of coin selection; ChangeIndex is -1
+// when the transaction has no change output.
+type FundedTransaction struct {
+	Inputs      []UTXODetail
+	Outputs     []TxBuildOutput
+	ChangeIndex int
+	Fee         int64
+	VSize       int64
+}
+
+// inputWeight returns the weight of an input spending scriptPubKey once
+// signed with a single key.
+func inputWeight(scriptPubKey []byte) (int64, error) {
+	// outpoint, nSequence and the scriptSig length byte
+	const base = 32 + 4 + 4 + 1
+	// witness item count, 72 bytes signature and 33 bytes pubkey
+	const p2wpkhWitness = 1 + 1 + 72 + 1 + 33
+	switch BTCScriptType(scriptPubKey) {
+	case "pubkeyhash":
+		return (base + 1 + 72 + 1 + 33) * 4, nil
+	case "witness_v0_keyhash":
+		return base*4 + p2wpkhWitness, nil
+	case "scripthash":
+		// only P2SH-P2WPKH is signed with a single key
+		return (base+23)*4 + p2wpkhWitness, nil
+	case "witness_v1_taproot":
+		return base*4 + 1 + 1 + 64, nil
+	}
+	return 0, fmt.Errorf("unsupported input script type %s", BTCScriptType(scriptPubKey))
+}
+
+// BTCEstimateVSize estimates the virtual size of a transaction spending
+// inputs into outputs once all inputs are signed.
+func BTCEstimateVSize(inputs []UTXODetail, outputs []TxBuildOutput) (int64, error) {
+	// version, locktime and the input and output counts
+	weight := int64(4+4+compactSizeLen(len(inputs))+compactSizeLen(len(outputs))) * 4
+	hasWitness := false
+	for i := range inputs {
+		scriptPubKey, err := utxoScriptPubKey(&inputs[i])
+		if err != nil {
+			return 0, err
+		}
+		if scriptPubKey == nil {
+			return 0, fmt.Errorf("input %s:%d without scriptPubKey", inputs[i].TxId, inputs[i].Vout)
+		}
+		w, err := inputWeight(scriptPubKey)
+		if err != nil {
+			return 0, err
+		}
+		if BTCScriptType(scriptPubKey) != "pubkeyhash" {
+			hasWitness = true
+		}
+		weight += w
+	}
+	if hasWitness {
+		// segwit marker and flag, one empty witness per legacy input
+		weight += 2
+		for i := range inputs {
+			scriptPubKey, _ := utxoScriptPubKey(&inputs[i])
+			if BTCScriptType(scriptPubKey) == "pubkeyhash" {
+				weight++
+			}
+		}
+	}
+	for _, output := range outputs {
+		weight += int64(8+compactSizeLen(len(output.ScriptPubKey))+len(output.ScriptPubKey)) * 4
+	}
+	return (weight + 3) / 4, nil
+}
+
+func compactSizeLen(n int) int {
+	switch {
+	case n < 0xfd:
+		return 1
+	case n <= 0xffff:
+		return 3
+	}
+	return 5
+}
+
+// FeeTarget describes the fee a transaction has to pay. FeeRate is in
+// sat/vB; a replacement pays at least ReplacedFee plus the minimum relay
+// fee of its own size as BIP125 requires; a child pays for its unconfirmed
+// ancestors of AncestorVSize and AncestorFee so that the package reaches
+// FeeRate.
+type FeeTarget struct {
+	FeeRate       float64
+	ReplacedFee   int64
+	AncestorVSize int64
+	AncestorFee   int64
+}
+
+// feeForVSize returns the fee of a transaction of vsize virtual bytes.
+func (f FeeTarget) feeForVSize(vsize int64) int64 {
+	fee := int64(math.Ceil(f.FeeRate*float64(vsize+f.AncestorVSize))) - f.AncestorFee
+	minFee := f.ReplacedFee + int64(math.Ceil(MinRelayFeeRate*float64(vsize)))
+	if fee < minFee {
+		return minFee
+	}
+	return fee
+}
+
+// BTCFundTransaction selects coins for paying outputs and the fee of
+// target. All of inputs are spent; candidates are added largest first until
+// the fee is covered. Change above the dust limit is paid to changeScript,
+// smaller change is left to the fee.
+func BTCFundTransaction(inputs []UTXODetail, candidates []UTXODetail, outputs []TxBuildOutput, changeScript []byte, target FeeTarget) (*FundedTransaction, error) {
+	selected := append([]UTXODetail{}, inputs...)
+	remaining := append([]UTXODetail{}, candidates...)
+	sort.SliceStable(remaining, func(i, j int) bool { return remaining[i].Amount > remaining[j].Amount })
+
+	totalOut := int64(0)
+	for _, output := range outputs {
+		if output.Amount < DustLimit {
+			return nil, fmt.Errorf("output amount %d below dust limit", output.Amount)
+		}
+		totalOut += output.Amount
+	}
+
+	for {
+		totalIn := int64(0)
+		for _, input := range selected {
+			totalIn += int64(input.Amount)
+		}
+		vsize, err := BTCEstimateVSize(selected, outputs)
+		if err != nil {
+			return nil, err
+		}
+		fee := target.feeForVSize(vsize)
+		if len(selected) > 0 && totalIn >= totalOut+fee {
+			funded := &FundedTransaction{Inputs: selected, Outputs: outputs, ChangeIndex: -1, Fee: totalIn - totalOut, VSize: vsize}
+			if changeScript == nil {
+				return funded, nil
+			}
+			withChange := append(append([]TxBuildOutput{}, outputs...), TxBuildOutput{ScriptPubKey: changeScript})
+			changeVSize, err := BTCEstimateVSize(selected, withChange)
+			if err != nil {
+				return nil, err
+			}
+			changeFee := target.feeForVSize(changeVSize)
+			change := totalIn - totalOut - changeFee
+			if change >= DustLimit {
+				withChange[len(withChange)-1].Amount = change
+				funded.Outputs = withChange
+				funded.ChangeIndex = len(withChange) - 1
+				funded.Fee = changeFee
+				funded.VSize = changeVSize
+			}
+			return funded, nil
+		}
+		if len(remaining) == 0 {
+			return nil, fmt.Errorf("insufficient funds: need %d satoshis plus fee, have %d", totalOut, totalIn)
+		}
+		selected = append(selected, remaining[0])
+		remaining = remaining[1:]
+	}
+}
+
+// BTCBuildRawTransaction serializes an unsigned version 2 transaction
+// spending inputs into outputs, all inputs with nSequence sequence.
+func BTCBuildRawTransaction(inputs []UTXODetail, outputs []TxBuildOutput, sequence uint32) (string, error) {
+	trx := transaction.Transaction{Version: 2}
+	for _, input := range inputs {
+		var txIn transaction.TxIn
+		err := txIn.PrevOut.Hash.SetHex(input.TxId)
+		if err != nil {
+			return "", err
+		}
+		txIn.PrevOut.N = uint32(input.Vout)
+		txIn.Sequence = sequence
+		trx.Vin = append(trx.Vin, txIn)
+	}
+	for _, output := range outputs {
+		var txOut transaction.TxOut
+		txOut.Value = output.Amount
+		txOut.ScriptPubKey.SetScriptBytes(output.ScriptPubKey)
+		trx.Vout = append(trx.Vout, txOut)
+	}
+	return BTCPackRawTransaction(trx)
+}
+
+// SignalsRBF reports whether trx opts in to BIP125 replacement.
+func SignalsRBF(trx *transaction.Transaction) bool {
+	for _, vin := range trx.Vin {
+		if vin.Sequence < 0xfffffffe {
+			return true
+		}
+	}
+	return false
+}
+
+// utxoDetail converts a tracked output for signing and coin selection.
+func utxoDetail(u utxo) (UTXODetail, error) {
+	scriptPubKey := u.Scriptpubkey
+	if scriptPubKey == "" {
+		scriptPubKeyBytes, err := BTCScriptPubKeyFromAddress(u.Address)
+		if err != nil {
+			return UTXODetail{}, err
+		}
+		scriptPubKey = hex.EncodeToString(scriptPubKeyBytes)
+	}
+	return UTXODetail{TxId: u.Txid, Vout: u.Vout, Address: u.Address, ScriptPubKey: scriptPubKey, Amount: u.Amount}, nil
+}
+
+// keyAddresses returns the P2PKH, P2WPKH, P2SH-P2WPKH and P2TR addresses
+// of privKeyStrs.
+func keyAddresses(privKeyStrs []string) ([]string, error) {
+	addrs := make([]string, 0, 4*len(privKeyStrs))
+	for _, privKeyStr := range privKeyStrs {
+		privKeyBytes, err := hex.DecodeString(privKeyStr)
+		if err != nil {
+			return nil, err
+		}
+		scriptPubKeys, err := BTCKeyScriptPubKeys(privKeyBytes)
+		if err != nil {
+			return nil, err
+		}
+		for _, scriptPubKey := range scriptPubKeys {
+			addr, err := BTCAddressFromScriptPubKey(scriptPubKey)
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// spendableUtxoDetails returns the spendable outputs, at the configured
+// confirmation thresholds, of the addresses of privKeyStrs among addrs, or
+// among the stored addresses when addrs is nil, that are not in exclude
+// ("txid:vout"). requireConfirmed raises both thresholds to at least one
+// confirmation.
+func spendableUtxoDetails(addrs []string, privKeyStrs []string, exclude map[string]bool, requireConfirmed bool) ([]UTXODetail, error) {
+	tipHeight, err := ConfirmationTipHeight()
+	if err != nil {
+		return nil, err
+	}
+	minDepositConf := GlobalConfig.UtxoConfig.MinDepositConfirmations
+	minChangeConf := GlobalConfig.UtxoConfig.MinChangeConfirmations
+	if requireConfirmed && minDepositConf < 1 {
+		minDepositConf = 1
+	}
+	if requireConfirmed && minChangeConf < 1 {
+		minChangeConf = 1
+	}
+
+	owned, err := keyAddresses(privKeyStrs)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, addr := range addrs {
+		wanted[addr] = true
+	}
+	if addrs == nil {
+		wanted, err = GlobalDBMgr.TblAddressMgr.FilterExistAddresses(owned)
+		if err != nil {
+			return nil, err
+		}
+	}
+	queried := make([]string, 0, len(owned))
+	for _, addr := range owned {
+		if wanted[addr] {
+			queried = append(queried, addr)
+			delete(wanted, addr)
+		}
+	}
+
+	details := make([]UTXODetail, 0)
+	if len(queried) == 0 {
+		return details, nil
+	}
+	utxos, _, err := GlobalDBMgr.TblUtxoMgr.QueryUtxos(UtxoQuery{Addresses: queried, MinDepositConf: minDepositConf,
+		MinChangeConf: minChangeConf, MaxConf: -1}, tipHeight)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range utxos {
+		if exclude[outPointKey(u.Txid, u.Vout)] {
+			continue
+		}
+		detail, err := utxoDetail(u)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, detail)
+	}
+	return details, nil
+}
+
+// signFundedTransaction builds and signs funded, records it as signed, as
+// replacing replacedTxId when not empty, and reserves its tracked inputs for
+// it. A transaction whose inputs were reserved meanwhile by another one is
+// recorded failed. It returns the txid and the signed hex.
+func signFundedTransaction(funded *FundedTransaction, privKeyStrs []string, replacedTxId string) (string, string, error) {
+	rawTrx, err := BTCBuildRawTransaction(funded.Inputs, funded.Outputs, SequenceRBF)
+	if err != nil {
+		return "", "", err
+	}
+	trxSigStr, report, err := BTCSignRawTransactionWithKeys(rawTrx, privKeyStrs, funded.Inputs)
+	if err != nil {
+		return "", "", err
+	}
+	for _, v := range report {
+		if v.Status != InputVerifyStatusVerified {
+			return "", "", fmt.Errorf("input %d is %s, no key for it", v.Index, v.Status)
+		}
+	}
+
+	trx, err := BTCUnPackRawTransaction(trxSigStr)
+	if err != nil {
+		return "", "", err
+	}
+	trxId, err := trx.CalcTrxId()
+	if err != nil {
+		return "", "", err
+	}
+	txId := trxId.GetHex()
+	err = GlobalDBMgr.TblTxMgr.SaveSigned(txId, trxSigStr)
+	if err != nil {
+		return "", "", err
+	}
+	if replacedTxId != "" {
+		err = GlobalDBMgr.TblTxMgr.SetReplaces(txId, replacedTxId)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	err = reserveTrackedInputs(trx, txId, replacedTxId)
+	if err != nil {
+		abandonSignedTransactions([]string{txId}, err.Error())
+		return "", "", err
+	}
+	return txId, trxSigStr, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func testTxid(seed byte) string {
+	return strings.Repeat(hex.EncodeToString([]byte{seed}), 32)
+}
+
+func TestBTCEstimateVSize(t *testing.T) {
+	keyHex := testPrivKeyHex(21)
+	keyBytes, _ := hex.DecodeString(keyHex)
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+
+	inputs := make([]UTXODetail, 0)
+	for i, scriptPubKey := range scriptPubKeys {
+		inputs = append(inputs, UTXODetail{TxId: testTxid(byte(i + 1)), Vout: i, ScriptPubKey: hex.EncodeToString(scriptPubKey), Amount: 100000})
+	}
+	for _, c := range [][]UTXODetail{inputs[:1], inputs[1:2], inputs[2:3], inputs[3:], inputs} {
+		outputs := []TxBuildOutput{{ScriptPubKey: scriptPubKeys[1], Amount: 10000}, {ScriptPubKey: scriptPubKeys[3], Amount: 10000}}
+		estimated, err := BTCEstimateVSize(c, outputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawTrx, err := BTCBuildRawTransaction(c, outputs, SequenceRBF)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signedHex, _, err := BTCSignRawTransactionWithKeys(rawTrx, []string{keyHex}, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, _ := BTCDecodeRawTransaction(signedHex, c)
+		// signatures may be a byte shorter than estimated
+		if int64(decoded.VSize) > estimated || estimated-int64(decoded.VSize) > int64(len(c)) {
+			t.Error("unexpected estimate", estimated, "of vsize", decoded.VSize)
+		}
+		if decoded.Vin[0].Sequence != SequenceRBF || decoded.Vin[0].TxId != c[0].TxId {
+			t.Error("unexpected input", decoded.Vin[0])
+		}
+	}
+}
+
+func TestBTCFundTransaction(t *testing.T) {
+	keyBytes, _ := hex.DecodeString(testPrivKeyHex(21))
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+	p2wpkh := hex.EncodeToString(scriptPubKeys[1])
+	candidates := []UTXODetail{
+		{TxId: testTxid(1), ScriptPubKey: p2wpkh, Amount: 20000},
+		{TxId: testTxid(2), ScriptPubKey: p2wpkh, Amount: 80000},
+		{TxId: testTxid(3), ScriptPubKey: p2wpkh, Amount: 50000},
+	}
+	payment := []TxBuildOutput{{ScriptPubKey: scriptPubKeys[0], Amount: 100000}}
+
+	funded, err := BTCFundTransaction(nil, candidates, payment, scriptPubKeys[1], FeeTarget{FeeRate: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// largest first
+	if len(funded.Inputs) != 2 || funded.Inputs[0].Amount != 80000 || funded.Inputs[1].Amount != 50000 {
+		t.Fatal("unexpected selection", funded.Inputs)
+	}
+	if funded.ChangeIndex != 1 || funded.Fee != 10*funded.VSize || funded.Outputs[1].Amount != 130000-100000-funded.Fee {
+		t.Fatal("unexpected change", funded)
+	}
+
+	// change below the dust limit goes to the fee
+	funded, err = BTCFundTransaction(candidates[1:2], nil, []TxBuildOutput{{ScriptPubKey: scriptPubKeys[0], Amount: 78500}}, scriptPubKeys[1], FeeTarget{FeeRate: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if funded.ChangeIndex != -1 || len(funded.Outputs) != 1 || funded.Fee != 1500 {
+		t.Fatal("unexpected dust handling", funded)
+	}
+
+	// a replacement pays the replaced fee plus the relay fee of its own size
+	funded, err = BTCFundTransaction(candidates[1:2], nil, []TxBuildOutput{{ScriptPubKey: scriptPubKeys[0], Amount: 50000}}, scriptPubKeys[1], FeeTarget{FeeRate: 1, ReplacedFee: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if funded.Fee != 5000+funded.VSize {
+		t.Fatal("unexpected replacement fee", funded.Fee)
+	}
+
+	if _, err = BTCFundTransaction(nil, candidates, []TxBuildOutput{{ScriptPubKey: scriptPubKeys[0], Amount: 150000}}, nil, FeeTarget{FeeRate: 10}); err == nil {
+		t.Fatal("insufficient funds accepted")
+	}
+	if _, err = BTCFundTransaction(nil, candidates, []TxBuildOutput{{ScriptPubKey: scriptPubKeys[0], Amount: 100}}, nil, FeeTarget{FeeRate: 10}); err == nil {
+		t.Fatal("dust output accepted")
+	}
+}
+
+func TestSpendableUtxoDetailsWithoutSync(t *testing.T) {
+	testInitDB(t)
+	savedConfig := GlobalConfig
+	defer func() { GlobalConfig = savedConfig }()
+	GlobalConfig.UtxoConfig.MinDepositConfirmations = 6
+	GlobalConfig.UtxoConfig.MinChangeConfirmations = 1
+
+	keyHex := testPrivKeyHex(41)
+	keyBytes, _ := hex.DecodeString(keyHex)
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+	addr, _ := BTCAddressFromScriptPubKey(scriptPubKeys[1])
+	// written by the external process, without a block height
+	err := GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: testTxid(0xb0), Amount: 100000, Address: addr,
+		Scriptpubkey: hex.EncodeToString(scriptPubKeys[1])}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tip left by an earlier scanner run
+	err = GlobalDBMgr.TblSyncStateMgr.SetLastBlock("BTC", "tip", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	details, err := spendableUtxoDetails([]string{addr}, []string{keyHex}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details) != 1 {
+		t.Error("confirmation thresholds applied with the scanner disabled", details)
+	}
+
+	GlobalConfig.SyncConfig.Enable = true
+	details, err = spendableUtxoDetails([]string{addr}, []string{keyHex}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details) != 0 {
+		t.Error("unconfirmed output spendable with the scanner enabled", details)
+	}
+}
+
+func TestSpendableUtxoDetails(t *testing.T) {
+	testInitDB(t)
+	keyHex, otherKeyHex := testPrivKeyHex(42), testPrivKeyHex(43)
+	keyBytes, _ := hex.DecodeString(keyHex)
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+	otherKeyBytes, _ := hex.DecodeString(otherKeyHex)
+	otherScriptPubKeys, _ := BTCKeyScriptPubKeys(otherKeyBytes)
+
+	// p2wpkh of the key is stored, its p2tr address is not
+	stored, _ := BTCAddressFromScriptPubKey(scriptPubKeys[1])
+	unstored, _ := BTCAddressFromScriptPubKey(scriptPubKeys[3])
+	foreign, _ := BTCAddressFromScriptPubKey(otherScriptPubKeys[1])
+	_, _, err := GlobalDBMgr.TblAddressMgr.AddNewAddresses([]address{{Address: stored}, {Address: foreign}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, scriptPubKey := range [][]byte{scriptPubKeys[1], scriptPubKeys[3], otherScriptPubKeys[1]} {
+		addr, _ := BTCAddressFromScriptPubKey(scriptPubKey)
+		err = GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: testTxid(byte(0xc0 + i)), Amount: 100000, Address: addr,
+			Scriptpubkey: hex.EncodeToString(scriptPubKey)}, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	details, err := spendableUtxoDetails(nil, []string{keyHex, keyHex}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details) != 1 || details[0].Address != stored {
+		t.Error("unexpected outputs of the stored addresses", details)
+	}
+	details, err = spendableUtxoDetails([]string{unstored, foreign}, []string{keyHex}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details) != 1 || details[0].Address != unstored {
+		t.Error("unexpected outputs of the given addresses", details)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/btcsuite/btcd/chaincfg"
 	"io/ioutil"
+	"time"
 )
 
 type DbConfig struct {
@@ -10,9 +13,57 @@ type DbConfig struct {
 	DbSource string `json:"dbSource"`
 }
 
+type SyncConfig struct {
+	Enable bool `json:"enable"`
+	// seconds between polls of the node
+	PollInterval int `json:"pollInterval"`
+	// first block to scan when nothing has been processed yet, the current
+	// tip when 0
+	StartHeight int64 `json:"startHeight"`
+}
+
+type UtxoConfig struct {
+	// confirmations before an output received from outside is spendable
+	MinDepositConfirmations int64 `json:"minDepositConfirmations"`
+	// confirmations before change of our own transactions is spendable
+	MinChangeConfirmations int64 `json:"minChangeConfirmations"`
+	// seconds a reservation of a signed transaction's inputs is held before
+	// the reaper may release it, 3600 when 0
+	PendingTimeout int64 `json:"pendingTimeout"`
+	// seconds between runs of the pending reaper, disabled when 0
+	ReapInterval int `json:"reapInterval"`
+}
+
+func (c UtxoConfig) PendingExpiry() time.Duration {
+	if c.PendingTimeout <= 0 {
+		return time.Hour
+	}
+	return time.Duration(c.PendingTimeout) * time.Second
+}
+
+type TxConfig struct {
+	// seconds between mempool polls of broadcast transactions, disabled
+	// when 0
+	PollInterval int `json:"pollInterval"`
+	// payees per batch payout transaction, larger batches are split;
+	// 100 when 0
+	MaxPayoutOutputs int `json:"maxPayoutOutputs"`
+}
+
+func (c TxConfig) PayoutBatchSize() int {
+	if c.MaxPayoutOutputs <= 0 {
+		return 100
+	}
+	return c.MaxPayoutOutputs
+}
+
 type Config struct {
-	ServerUrl string   `json:"serverUrl"`
-	DbConfig  DbConfig `json:"dbConfig"`
+	ServerUrl  string     `json:"serverUrl"`
+	Network    string     `json:"network"`
+	DbConfig   DbConfig   `json:"dbConfig"`
+	SyncConfig SyncConfig `json:"syncConfig"`
+	UtxoConfig UtxoConfig `json:"utxoConfig"`
+	TxConfig   TxConfig   `json:"txConfig"`
 }
 
 var GlobalConfig Config
@@ -31,3 +82,23 @@ func (j *JsonStruct) Load(configFile string, config interface{}) error {
 	}
 	return nil
 }
+
+// GetNetParams returns the parameters of the configured network, which
+// LoadConf has checked.
+func GetNetParams() (*chaincfg.Params, error) {
+	return NetParamsByName(GlobalConfig.Network)
+}
+
+func NetParamsByName(network string) (*chaincfg.Params, error) {
+	switch network {
+	case "", "mainnet":
+		return &chaincfg.MainNetParams, nil
+	case "testnet", "testnet3":
+		return &chaincfg.TestNet3Params, nil
+	case "regtest":
+		return &chaincfg.RegressionNetParams, nil
+	case "simnet":
+		return &chaincfg.SimNetParams, nil
+	}
+	return nil, errors.New("unknown network: " + network)
+}
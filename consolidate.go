@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// bitcoind relays transactions up to 400000 weight units
+	MaxStandardTxVSize int64 = 100000
+	// confirmation target of the fee estimate consolidations are paid at
+	ConsolidateConfTarget = 144
+)
+
+// ConsolidateOptions are the optional parameters of BTCConsolidate.
+type ConsolidateOptions struct {
+	// only outputs up to this amount, all outputs when 0
+	MaxAmount Satoshi
+	// addresses to consolidate, all addresses of the address table when empty
+	Addresses []string
+}
+
+type ConsolidationTx struct {
+	TxId   string `json:"txid"`
+	Hex    string `json:"hex"`
+	Inputs int    `json:"inputs"`
+	Amount int64  `json:"amount"`
+	Fee    int64  `json:"fee"`
+	VSize  int64  `json:"vsize"`
+}
+
+type ConsolidateResult struct {
+	FeeRate      float64           `json:"feeRate"`
+	Transactions []ConsolidationTx `json:"transactions"`
+	// outputs left out as worth less than the fee of spending them
+	Skipped int `json:"skipped"`
+}
+
+// BTCConsolidate merges the spendable outputs of our addresses owned by
+// privKeyStrs into as few transactions paying to targetAddress as the
+// standard size limit allows. The transactions pay the node's economical
+// fee estimate, maxFeeRate when it has none, and nothing is built while the
+// estimate is above maxFeeRate. Outputs worth less than the fee of spending
+// them are skipped. The transactions are recorded as signed and their
+// inputs reserved for them.
+func BTCConsolidate(node ChainNode, maxFeeRate float64, targetAddress string, privKeyStrs []string, opts ConsolidateOptions) (*ConsolidateResult, error) {
+	feeRate, err := node.EstimateSmartFee(ConsolidateConfTarget)
+	if err != nil {
+		return nil, err
+	}
+	if feeRate > maxFeeRate {
+		return nil, fmt.Errorf("estimated fee rate %.2f sat/vB above the ceiling %.2f sat/vB", feeRate, maxFeeRate)
+	}
+	if feeRate == 0 {
+		feeRate = maxFeeRate
+	}
+	if feeRate < MinRelayFeeRate {
+		feeRate = MinRelayFeeRate
+	}
+	targetScript, err := BTCScriptPubKeyFromAddress(targetAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	if len(opts.Addresses) > 0 {
+		addrs = opts.Addresses
+	}
+	details, err := spendableUtxoDetails(addrs, privKeyStrs, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	result := &ConsolidateResult{FeeRate: feeRate}
+	inputs := make([]UTXODetail, 0, len(details))
+	for _, detail := range details {
+		if opts.MaxAmount > 0 && detail.Amount > opts.MaxAmount {
+			continue
+		}
+		weight, err := consolidationInputWeight(&detail)
+		if err != nil {
+			return nil, err
+		}
+		if int64(detail.Amount) <= int64(math.Ceil(feeRate*float64(weight)/4)) {
+			result.Skipped++
+			continue
+		}
+		inputs = append(inputs, detail)
+	}
+	if len(inputs) < 2 {
+		return nil, errors.New("not enough outputs worth consolidating")
+	}
+	sort.SliceStable(inputs, func(i, j int) bool { return inputs[i].Amount < inputs[j].Amount })
+
+	batches, err := batchConsolidation(inputs, targetScript, feeRate)
+	if err != nil {
+		return nil, err
+	}
+	result.Transactions, err = signConsolidation(batches, privKeyStrs)
+	if err != nil {
+		return nil, err
+	}
+	Info.Printf("consolidated %d outputs into %d transactions to %s", len(inputs), len(batches), targetAddress)
+	return result, nil
+}
+
+// BTCSweepAddress spends all spendable outputs of addr to targetAddress at
+// feeRate sat/vB, in several transactions when they exceed the standard
+// size limit.
+func BTCSweepAddress(addr string, targetAddress string, feeRate float64, privKeyStrs []string) (*ConsolidateResult, error) {
+	targetScript, err := BTCScriptPubKeyFromAddress(targetAddress)
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := spendableUtxoDetails([]string{addr}, privKeyStrs, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) == 0 {
+		return nil, errors.New("no spendable outputs of " + addr)
+	}
+
+	batches, err := batchConsolidation(inputs, targetScript, feeRate)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := signConsolidation(batches, privKeyStrs)
+	if err != nil {
+		return nil, err
+	}
+	Info.Printf("swept %d outputs of %s to %s", len(inputs), addr, targetAddress)
+	return &ConsolidateResult{FeeRate: feeRate, Transactions: txs}, nil
+}
+
+// consolidationInputWeight is the weight of spending input, counting the
+// empty witness a legacy input has in a segwit transaction.
+func consolidationInputWeight(input *UTXODetail) (int64, error) {
+	scriptPubKey, err := utxoScriptPubKey(input)
+	if err != nil {
+		return 0, err
+	}
+	if scriptPubKey == nil {
+		return 0, fmt.Errorf("input %s:%d without scriptPubKey", input.TxId, input.Vout)
+	}
+	weight, err := inputWeight(scriptPubKey)
+	if err != nil {
+		return 0, err
+	}
+	return weight + 1, nil
+}
+
+// batchConsolidation splits inputs in order into transactions paying all of
+// their value less the fee to targetScript, each below MaxStandardTxVSize.
+func batchConsolidation(inputs []UTXODetail, targetScript []byte, feeRate float64) ([]*FundedTransaction, error) {
+	outputs := []TxBuildOutput{{ScriptPubKey: targetScript}}
+	// version, locktime, the largest input count, the output count, the
+	// segwit marker and flag, and the output
+	overhead := int64(4+4+5+1)*4 + 2 + int64(8+compactSizeLen(len(targetScript))+len(targetScript))*4
+
+	batches := make([]*FundedTransaction, 0)
+	flush := func(batch []UTXODetail) error {
+		vsize, err := BTCEstimateVSize(batch, outputs)
+		if err != nil {
+			return err
+		}
+		fee := int64(math.Ceil(feeRate * float64(vsize)))
+		totalIn := int64(0)
+		for _, input := range batch {
+			totalIn += int64(input.Amount)
+		}
+		if totalIn-fee < DustLimit {
+			return fmt.Errorf("%d outputs of %d satoshis do not pay the fee of %d satoshis", len(batch), totalIn, fee)
+		}
+		batches = append(batches, &FundedTransaction{Inputs: batch, ChangeIndex: -1, Fee: fee, VSize: vsize,
+			Outputs: []TxBuildOutput{{ScriptPubKey: targetScript, Amount: totalIn - fee}}})
+		return nil
+	}
+
+	batch := make([]UTXODetail, 0)
+	weight := overhead
+	for i := range inputs {
+		w, err := consolidationInputWeight(&inputs[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) > 0 && weight+w > MaxStandardTxVSize*4 {
+			err = flush(batch)
+			if err != nil {
+				return nil, err
+			}
+			batch = make([]UTXODetail, 0)
+			weight = overhead
+		}
+		batch = append(batch, inputs[i])
+		weight += w
+	}
+	err := flush(batch)
+	if err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// signConsolidation signs batches; when one fails the transactions signed
+// before are marked failed and their inputs released.
+func signConsolidation(batches []*FundedTransaction, privKeyStrs []string) ([]ConsolidationTx, error) {
+	txs := make([]ConsolidationTx, 0, len(batches))
+	for _, funded := range batches {
+		txId, trxSigStr, err := signFundedTransaction(funded, privKeyStrs, "")
+		if err != nil {
+			txIds := make([]string, 0, len(txs))
+			for _, signed := range txs {
+				txIds = append(txIds, signed.TxId)
+			}
+			abandonSignedTransactions(txIds, "consolidation aborted")
+			return nil, err
+		}
+		txs = append(txs, ConsolidationTx{TxId: txId, Hex: trxSigStr, Inputs: len(funded.Inputs),
+			Amount: funded.Outputs[0].Amount, Fee: funded.Fee, VSize: funded.VSize})
+	}
+	return txs, nil
+}
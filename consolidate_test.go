@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBTCConsolidate(t *testing.T) {
+	testInitDB(t)
+	keyHex := testPrivKeyHex(51)
+	ourScript := testFundedAddress(t, keyHex, 10000, 20000, 30000, 100, 100000000)
+	ourAddr, _ := BTCAddressFromScriptPubKey(ourScript)
+	targetAddr, _ := BTCAddressFromScriptPubKey(BTCGetWitnessScriptPubKey(0, bytes.Repeat([]byte{0x22}, 20)))
+	node := newFakeChainNode()
+
+	node.feeRate = 20
+	_, err := BTCConsolidate(node, 10, targetAddr, []string{keyHex}, ConsolidateOptions{})
+	if err == nil {
+		t.Fatal("consolidated above the fee rate ceiling")
+	}
+
+	// the 100 satoshis output does not pay for its input
+	node.feeRate = 5
+	result, err := BTCConsolidate(node, 10, targetAddr, []string{keyHex}, ConsolidateOptions{MaxAmount: 100000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FeeRate != 5 || result.Skipped != 1 || len(result.Transactions) != 1 {
+		t.Fatal("unexpected consolidation", result)
+	}
+	consolidation := result.Transactions[0]
+	decoded, _ := BTCDecodeRawTransaction(consolidation.Hex, nil)
+	if len(decoded.Vin) != 3 || len(decoded.Vout) != 1 || decoded.Vout[0].Address != targetAddr {
+		t.Fatal("unexpected consolidation transaction", decoded)
+	}
+	if decoded.Vout[0].Amount != 60000-consolidation.Fee || consolidation.Fee < 5*int64(decoded.VSize) {
+		t.Fatal("unexpected consolidation fee", consolidation, decoded.VSize)
+	}
+	for i := 0; i < 3; i++ {
+		if u, _ := testTrackedUtxo(t, testTxid(byte(0xa0+i)), 0); u.Pending != 1 || u.Pending_txid != consolidation.TxId {
+			t.Fatal("input not reserved", u)
+		}
+	}
+
+	// the rest of the address, including the small output
+	result, err = BTCSweepAddress(ourAddr, targetAddr, 2, []string{keyHex})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ = BTCDecodeRawTransaction(result.Transactions[0].Hex, nil)
+	if len(result.Transactions) != 1 || len(decoded.Vin) != 2 || decoded.Vout[0].Amount != 100000100-result.Transactions[0].Fee {
+		t.Fatal("unexpected sweep", result, decoded)
+	}
+	if _, err = BTCSweepAddress(ourAddr, targetAddr, 2, []string{keyHex}); err == nil {
+		t.Fatal("swept an empty address")
+	}
+}
+
+func TestBatchConsolidation(t *testing.T) {
+	keyBytes, _ := hex.DecodeString(testPrivKeyHex(52))
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+	inputs := make([]UTXODetail, 1600)
+	for i := range inputs {
+		inputs[i] = UTXODetail{TxId: testTxid(byte(i)), Vout: i, ScriptPubKey: hex.EncodeToString(scriptPubKeys[1]), Amount: 10000}
+	}
+
+	batches, err := batchConsolidation(inputs, scriptPubKeys[1], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || len(batches[0].Inputs)+len(batches[1].Inputs) != len(inputs) {
+		t.Fatal("unexpected batches", len(batches))
+	}
+	for _, batch := range batches {
+		if batch.VSize > MaxStandardTxVSize || batch.Outputs[0].Amount != int64(len(batch.Inputs))*10000-batch.Fee {
+			t.Fatal("unexpected batch", batch.VSize, batch.Fee)
+		}
+	}
+
+	// the outputs do not pay the fee
+	if _, err = batchConsolidation(inputs[:2], scriptPubKeys[1], 150); err == nil {
+		t.Fatal("batch below the dust limit accepted")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+type CpfpResult struct {
+	TxId        string `json:"txid"`
+	Hex         string `json:"hex"`
+	ParentTxId  string `json:"parentTxid"`
+	ParentVSize int64  `json:"parentVSize"`
+	ParentFee   int64  `json:"parentFee"`
+	Fee         int64  `json:"fee"`
+	VSize       int64  `json:"vsize"`
+	// fee rate of parent and child together, sat/vB
+	PackageFeeRate float64 `json:"packageFeeRate"`
+}
+
+// CpfpOptions are the optional parameters of BTCCpfp; zero values are
+// looked up. Vout selects the parent output to spend, the largest one
+// paying to our addresses when negative.
+type CpfpOptions struct {
+	Vout        int
+	ParentVSize int64
+	ParentFee   int64
+	Address     string
+}
+
+// BTCCpfp builds a child of the unconfirmed transaction parentTxId spending
+// one of its outputs to us, paying a fee that brings parent and child
+// together to feeRate sat/vB. The parent's vsize and fee are taken from the
+// node's mempool unless given in opts. Confirmed outputs of the same address
+// are added when the spent output cannot pay the fee. The child pays to
+// opts.Address, by default back to the spent output's address.
+func BTCCpfp(node ChainNode, parentTxId string, feeRate float64, privKeyStrs []string, opts CpfpOptions) (*CpfpResult, error) {
+	rawParent := ""
+	record, exist, err := GlobalDBMgr.TblTxMgr.GetTx(parentTxId)
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		rawParent = record.Raw
+	} else {
+		rawParent, err = node.GetRawTransaction(parentTxId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	parent, err := BTCDecodeRawTransaction(rawParent, nil)
+	if err != nil {
+		return nil, err
+	}
+	if parent.TxId != parentTxId {
+		return nil, errors.New("parent transaction does not match its txid")
+	}
+
+	parentVSize, parentFee := opts.ParentVSize, opts.ParentFee
+	if parentVSize <= 0 || parentFee <= 0 {
+		entry, err := node.GetMempoolEntry(parentTxId)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, errors.New("parent transaction not in the mempool")
+		}
+		if parentVSize <= 0 {
+			parentVSize = entry.VSize
+		}
+		if parentFee <= 0 {
+			parentFee = entry.Fee
+		}
+	}
+
+	outputAddrs := make([]string, 0, len(parent.Vout))
+	for _, vout := range parent.Vout {
+		outputAddrs = append(outputAddrs, vout.Address)
+	}
+	ourAddrs, err := GlobalDBMgr.TblAddressMgr.FilterExistAddresses(outputAddrs)
+	if err != nil {
+		return nil, err
+	}
+	var spend *DecodedTxOut
+	for i, vout := range parent.Vout {
+		if vout.Address == "" || !ourAddrs[vout.Address] {
+			continue
+		}
+		if opts.Vout == vout.N || (opts.Vout < 0 && (spend == nil || vout.Amount > spend.Amount)) {
+			spend = &parent.Vout[i]
+		}
+	}
+	if spend == nil {
+		return nil, errors.New("no output of the parent pays to our addresses")
+	}
+
+	input := UTXODetail{TxId: parentTxId, Vout: spend.N, Address: spend.Address, ScriptPubKey: spend.ScriptPubKey, Amount: Satoshi(spend.Amount)}
+	changeScript, _ := hex.DecodeString(spend.ScriptPubKey)
+	if opts.Address != "" {
+		changeScript, err = BTCScriptPubKeyFromAddress(opts.Address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	candidates, err := spendableUtxoDetails([]string{spend.Address}, privKeyStrs, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	target := FeeTarget{FeeRate: feeRate, AncestorVSize: parentVSize, AncestorFee: parentFee}
+	funded, err := BTCFundTransaction([]UTXODetail{input}, candidates, nil, changeScript, target)
+	if err != nil {
+		return nil, err
+	}
+	if funded.ChangeIndex < 0 {
+		return nil, fmt.Errorf("spent outputs of %d satoshis too small to pay the fee", spend.Amount)
+	}
+
+	txId, trxSigStr, err := signFundedTransaction(funded, privKeyStrs, "")
+	if err != nil {
+		return nil, err
+	}
+	packageFeeRate := float64(parentFee+funded.Fee) / float64(parentVSize+funded.VSize)
+	Info.Printf("transaction %s accelerated by child %s, package fee rate %.2f sat/vB", parentTxId, txId, packageFeeRate)
+	return &CpfpResult{TxId: txId, Hex: trxSigStr, ParentTxId: parentTxId, ParentVSize: parentVSize, ParentFee: parentFee,
+		Fee: funded.Fee, VSize: funded.VSize, PackageFeeRate: packageFeeRate}, nil
+}
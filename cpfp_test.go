@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// testForeignPayment returns a transaction of another wallet paying the
+// given amounts to scriptPubKeys.
+func testForeignPayment(t *testing.T, seed byte, scriptPubKeys [][]byte, amounts []int64) (*DecodedTransaction, string) {
+	keyHex := testPrivKeyHex(seed)
+	keyBytes, _ := hex.DecodeString(keyHex)
+	keyScriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+	input := UTXODetail{TxId: testTxid(seed), ScriptPubKey: hex.EncodeToString(keyScriptPubKeys[1]), Amount: 10000000}
+	outputs := make([]TxBuildOutput, 0)
+	for i := range scriptPubKeys {
+		outputs = append(outputs, TxBuildOutput{ScriptPubKey: scriptPubKeys[i], Amount: amounts[i]})
+	}
+	rawTrx, _ := BTCBuildRawTransaction([]UTXODetail{input}, outputs, 0xffffffff)
+	signedHex, _, err := BTCSignRawTransactionWithKeys(rawTrx, []string{keyHex}, []UTXODetail{input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := BTCDecodeRawTransaction(signedHex, nil)
+	return decoded, signedHex
+}
+
+func TestBTCCpfp(t *testing.T) {
+	testInitDB(t)
+	keyHex := testPrivKeyHex(41)
+	ourScript := testFundedAddress(t, keyHex, 100000)
+	foreignScript := BTCGetWitnessScriptPubKey(0, make([]byte, 20))
+
+	parent, parentHex := testForeignPayment(t, 42, [][]byte{foreignScript, ourScript}, []int64{9000000, 50000})
+	node := newFakeChainNode()
+	node.raws[parent.TxId] = parentHex
+	node.entries[parent.TxId] = &MempoolEntry{VSize: int64(parent.VSize), Fee: int64(parent.VSize)}
+
+	result, err := BTCCpfp(node, parent.TxId, 20, []string{keyHex}, CpfpOptions{Vout: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, _ := BTCDecodeRawTransaction(result.Hex, nil)
+	if len(child.Vin) != 1 || child.Vin[0].TxId != parent.TxId || child.Vin[0].Vout != 1 {
+		t.Fatal("parent output not spent", child.Vin)
+	}
+	if len(child.Vout) != 1 || child.Vout[0].ScriptPubKey != hex.EncodeToString(ourScript) || child.Vout[0].Amount != 50000-result.Fee {
+		t.Fatal("unexpected child outputs", child.Vout)
+	}
+	if result.ParentFee != int64(parent.VSize) || result.PackageFeeRate < 20 || result.PackageFeeRate > 20.1 {
+		t.Fatal("unexpected package fee rate", result)
+	}
+	if testTxStatus(t, result.TxId).Status != TxStatusSigned {
+		t.Fatal("child not recorded")
+	}
+
+	// the parent output cannot pay for the package, a confirmed output is added
+	parent, parentHex = testForeignPayment(t, 43, [][]byte{ourScript}, []int64{2000})
+	node.raws[parent.TxId] = parentHex
+	result, err = BTCCpfp(node, parent.TxId, 50, []string{keyHex}, CpfpOptions{Vout: 0, ParentVSize: int64(parent.VSize), ParentFee: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, _ = BTCDecodeRawTransaction(result.Hex, nil)
+	if len(child.Vin) != 2 || child.Vout[0].Amount != 2000+100000-result.Fee || result.PackageFeeRate < 50 {
+		t.Fatal("unexpected child", result, child)
+	}
+	if u, _ := testTrackedUtxo(t, testTxid(0xa0), 0); u.Pending != 1 || u.Pending_txid != result.TxId {
+		t.Fatal("added input not reserved", u)
+	}
+
+	// nothing of ours to spend
+	parent, parentHex = testForeignPayment(t, 44, [][]byte{foreignScript}, []int64{2000})
+	node.raws[parent.TxId] = parentHex
+	if _, err = BTCCpfp(node, parent.TxId, 50, []string{keyHex}, CpfpOptions{Vout: -1, ParentVSize: 100, ParentFee: 100}); err == nil {
+		t.Fatal("child of a foreign payment built")
+	}
+	// not in the mempool
+	if _, err = BTCCpfp(node, parent.TxId, 50, []string{keyHex}, CpfpOptions{Vout: -1}); err == nil {
+		t.Fatal("child of a transaction outside the mempool built")
+	}
+}
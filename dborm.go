@@ -1,14 +1,24 @@
 package main
 
 import (
+	"errors"
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/go-xorm/xorm"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"xorm.io/core"
 )
 
 type DBMgr struct {
-	DBEngine      *xorm.Engine
-	TblAddressMgr *tblAddressMgr
-	TblUtxoMgr    *tblUtxoMgr
+	DBEngine            *xorm.Engine
+	TblAddressMgr       *tblAddressMgr
+	TblUtxoMgr          *tblUtxoMgr
+	TblXpubAccountMgr   *tblXpubAccountMgr
+	TblSyncStateMgr     *tblSyncStateMgr
+	TblSyncBlockMgr     *tblSyncBlockMgr
+	TblTxMgr            *tblTxMgr
+	TblSchemaVersionMgr *tblSchemaVersionMgr
+	TblLedgerMgr        *tblLedgerMgr
 }
 
 var GlobalDBMgr *DBMgr
@@ -17,13 +27,22 @@ func GetDBEngine() *xorm.Engine {
 	return GlobalDBMgr.DBEngine
 }
 
+// InitDB opens the database of dbType, one of mysql, sqlite3 and postgres.
 func InitDB(dbType string, dbSource string) error {
+	if dbType != "mysql" && dbType != "sqlite3" && dbType != "postgres" {
+		return errors.New("unsupported dbType " + dbType)
+	}
 	var err error
 	GlobalDBMgr = new(DBMgr)
 	GlobalDBMgr.DBEngine, err = xorm.NewEngine(dbType, dbSource)
 	if err != nil {
 		return err
 	}
+	if dbType == "sqlite3" {
+		// sqlite locks the whole database for writing, and an in-memory
+		// database lives in a single connection
+		GlobalDBMgr.DBEngine.SetMaxOpenConns(1)
+	}
 	GlobalDBMgr.DBEngine.SetTableMapper(core.SnakeMapper{})
 	GlobalDBMgr.DBEngine.SetColumnMapper(core.SnakeMapper{})
 
@@ -33,5 +52,23 @@ func InitDB(dbType string, dbSource string) error {
 	GlobalDBMgr.TblUtxoMgr = new(tblUtxoMgr)
 	GlobalDBMgr.TblUtxoMgr.Init()
 
+	GlobalDBMgr.TblXpubAccountMgr = new(tblXpubAccountMgr)
+	GlobalDBMgr.TblXpubAccountMgr.Init()
+
+	GlobalDBMgr.TblSyncStateMgr = new(tblSyncStateMgr)
+	GlobalDBMgr.TblSyncStateMgr.Init()
+
+	GlobalDBMgr.TblSyncBlockMgr = new(tblSyncBlockMgr)
+	GlobalDBMgr.TblSyncBlockMgr.Init()
+
+	GlobalDBMgr.TblTxMgr = new(tblTxMgr)
+	GlobalDBMgr.TblTxMgr.Init()
+
+	GlobalDBMgr.TblSchemaVersionMgr = new(tblSchemaVersionMgr)
+	GlobalDBMgr.TblSchemaVersionMgr.Init()
+
+	GlobalDBMgr.TblLedgerMgr = new(tblLedgerMgr)
+	GlobalDBMgr.TblLedgerMgr.Init()
+
 	return nil
 }
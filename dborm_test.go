@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"xorm.io/core"
+)
+
+// testOpenDB points GlobalDBMgr at an empty database: the one named by the
+// TEST_DB_TYPE and TEST_DB_SOURCE environment variables, whose tables are
+// dropped, or else a fresh in-memory SQLite database.
+func testOpenDB(t *testing.T) {
+	dbType, dbSource := os.Getenv("TEST_DB_TYPE"), os.Getenv("TEST_DB_SOURCE")
+	if dbType == "" {
+		dbType, dbSource = "sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	}
+	err := InitDB(dbType, dbSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := GetDBEngine().DBMetas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		err = GetDBEngine().DropTables(table.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testInitDB points GlobalDBMgr at an empty database with the current
+// schema.
+func testInitDB(t *testing.T) {
+	testOpenDB(t)
+	_, err := Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitDB(t *testing.T) {
+	testInitDB(t)
+	if err := GetDBEngine().Ping(); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []interface{}{new(address), new(utxo), new(xpubAccount), new(syncState), new(syncBlock),
+		new(tx), new(schemaVersion), new(ledger)} {
+		exist, err := GetDBEngine().IsTableExist(table)
+		if err != nil || !exist {
+			t.Errorf("table of %T not created: %v", table, err)
+		}
+	}
+	if os.Getenv("TEST_DB_TYPE") == "" && GetDBEngine().Dialect().DBType() != core.SQLITE {
+		t.Error("unexpected dialect", GetDBEngine().Dialect().DBType())
+	}
+	if err := InitDB("oracle", "btc"); err == nil {
+		t.Fatal("unsupported dbType accepted")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/mutalisk999/bitcoin-lib/src/bigint"
+)
+
+type DecodedScriptSig struct {
+	Hex string `json:"hex"`
+}
+
+type DecodedPrevOut struct {
+	Amount       int64  `json:"amount"`
+	ScriptPubKey string `json:"scriptPubKey"`
+	Type         string `json:"type"`
+	Address      string `json:"address,omitempty"`
+}
+
+type DecodedTxIn struct {
+	TxId      string           `json:"txid"`
+	Vout      uint32           `json:"vout"`
+	Sequence  uint32           `json:"sequence"`
+	ScriptSig DecodedScriptSig `json:"scriptSig"`
+	Witness   []string         `json:"witness,omitempty"`
+	PrevOut   *DecodedPrevOut  `json:"prevout,omitempty"`
+}
+
+type DecodedTxOut struct {
+	N            int    `json:"n"`
+	Amount       int64  `json:"amount"`
+	ScriptPubKey string `json:"scriptPubKey"`
+	Type         string `json:"type"`
+	Address      string `json:"address,omitempty"`
+}
+
+type DecodedTransaction struct {
+	TxId     string         `json:"txid"`
+	WTxId    string         `json:"wtxid"`
+	Version  int32          `json:"version"`
+	LockTime uint32         `json:"locktime"`
+	Size     int            `json:"size"`
+	VSize    int            `json:"vsize"`
+	Weight   int            `json:"weight"`
+	Vin      []DecodedTxIn  `json:"vin"`
+	Vout     []DecodedTxOut `json:"vout"`
+	TotalOut int64          `json:"total_out"`
+	// only set when the spent outputs of all inputs are known
+	TotalIn *int64 `json:"total_in,omitempty"`
+	Fee     *int64 `json:"fee,omitempty"`
+}
+
+// BTCScriptType classifies scriptPubKey with the names used by bitcoind.
+func BTCScriptType(scriptPubKey []byte) string {
+	if len(scriptPubKey) == 34 && scriptPubKey[0] == txscript.OP_1 && scriptPubKey[1] == txscript.OP_DATA_32 {
+		return "witness_v1_taproot"
+	}
+	switch txscript.GetScriptClass(scriptPubKey) {
+	case txscript.PubKeyTy:
+		return "pubkey"
+	case txscript.PubKeyHashTy:
+		return "pubkeyhash"
+	case txscript.ScriptHashTy:
+		return "scripthash"
+	case txscript.WitnessV0PubKeyHashTy:
+		return "witness_v0_keyhash"
+	case txscript.WitnessV0ScriptHashTy:
+		return "witness_v0_scripthash"
+	case txscript.MultiSigTy:
+		return "multisig"
+	case txscript.NullDataTy:
+		return "nulldata"
+	}
+	if txscript.IsWitnessProgram(scriptPubKey) {
+		return "witness_unknown"
+	}
+	return "nonstandard"
+}
+
+func decodePrevOut(scriptPubKey []byte, amount int64) *DecodedPrevOut {
+	prevOut := &DecodedPrevOut{Amount: amount, ScriptPubKey: hex.EncodeToString(scriptPubKey), Type: BTCScriptType(scriptPubKey)}
+	prevOut.Address, _ = BTCAddressFromScriptPubKey(scriptPubKey)
+	return prevOut
+}
+
+// BTCDecodeRawTransaction decodes rawTrx for inspection. utxos optionally
+// describe the spent outputs; when all of them are known the total input
+// amount and fee are filled in.
+func BTCDecodeRawTransaction(rawTrx string, utxos []UTXODetail) (*DecodedTransaction, error) {
+	trx, err := BTCUnPackRawTransaction(rawTrx)
+	if err != nil {
+		return nil, err
+	}
+
+	txId, err := trx.CalcTrxId()
+	if err != nil {
+		return nil, err
+	}
+	bytesBuf := bytes.NewBuffer([]byte{})
+	err = trx.PackNoWitness(bytesBuf)
+	if err != nil {
+		return nil, err
+	}
+	baseSize := bytesBuf.Len()
+	bytesBuf.Reset()
+	err = trx.Pack(bytesBuf)
+	if err != nil {
+		return nil, err
+	}
+	totalSize := bytesBuf.Len()
+	var wtxId bigint.Uint256
+	_ = wtxId.SetData(doubleSha256(bytesBuf.Bytes()))
+
+	decoded := &DecodedTransaction{
+		TxId:     txId.GetHex(),
+		WTxId:    wtxId.GetHex(),
+		Version:  trx.Version,
+		LockTime: trx.LockTime,
+		Size:     totalSize,
+		Weight:   baseSize*3 + totalSize,
+		Vin:      make([]DecodedTxIn, 0, len(trx.Vin)),
+		Vout:     make([]DecodedTxOut, 0, len(trx.Vout)),
+	}
+	decoded.VSize = (decoded.Weight + 3) / 4
+
+	totalIn := int64(0)
+	allPrevOutsKnown := true
+	for _, vin := range trx.Vin {
+		in := DecodedTxIn{
+			TxId:      vin.PrevOut.Hash.GetHex(),
+			Vout:      vin.PrevOut.N,
+			Sequence:  vin.Sequence,
+			ScriptSig: DecodedScriptSig{Hex: hex.EncodeToString(vin.ScriptSig.GetScriptBytes())},
+		}
+		for _, item := range vin.ScriptWitness.GetScriptWitnessBytes() {
+			in.Witness = append(in.Witness, hex.EncodeToString(item))
+		}
+
+		utxoDetail := findUtxoDetail(utxos, in.TxId, in.Vout)
+		scriptPubKey, err := utxoScriptPubKey(utxoDetail)
+		if err != nil {
+			return nil, err
+		}
+		if scriptPubKey != nil {
+			in.PrevOut = decodePrevOut(scriptPubKey, int64(utxoDetail.Amount))
+			totalIn += int64(utxoDetail.Amount)
+		} else {
+			allPrevOutsKnown = false
+		}
+		decoded.Vin = append(decoded.Vin, in)
+	}
+
+	for i, vout := range trx.Vout {
+		scriptPubKey := vout.ScriptPubKey.GetScriptBytes()
+		out := DecodedTxOut{
+			N:            i,
+			Amount:       vout.Value,
+			ScriptPubKey: hex.EncodeToString(scriptPubKey),
+			Type:         BTCScriptType(scriptPubKey),
+		}
+		out.Address, _ = BTCAddressFromScriptPubKey(scriptPubKey)
+		decoded.Vout = append(decoded.Vout, out)
+		decoded.TotalOut += vout.Value
+	}
+
+	if allPrevOutsKnown && len(trx.Vin) > 0 {
+		fee := totalIn - decoded.TotalOut
+		decoded.TotalIn = &totalIn
+		decoded.Fee = &fee
+	}
+	return decoded, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/wire"
+	"strings"
+	"testing"
+)
+
+func TestBTCDecodeRawTransaction(t *testing.T) {
+	privKeyHex := testPrivKeyHex(7)
+	privKeyBytes, _ := hex.DecodeString(privKeyHex)
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(privKeyBytes)
+
+	rawTrx := testUnsignedTrx(2, 2)
+	trx, _ := BTCUnPackRawTransaction(rawTrx)
+	utxos := make([]UTXODetail, 0)
+	for i, vin := range trx.Vin {
+		utxos = append(utxos, UTXODetail{TxId: vin.PrevOut.Hash.GetHex(), Vout: int(vin.PrevOut.N),
+			ScriptPubKey: hex.EncodeToString(scriptPubKeys[i]), Amount: 50000})
+	}
+	signedHex, err := BTCSignRawTransaction(rawTrx, privKeyHex, utxos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := BTCDecodeRawTransaction(signedHex, utxos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedBytes, _ := hex.DecodeString(signedHex)
+	msgTx := wire.NewMsgTx(2)
+	_ = msgTx.Deserialize(bytes.NewReader(signedBytes))
+	if decoded.TxId != msgTx.TxHash().String() || decoded.WTxId != msgTx.WitnessHash().String() {
+		t.Error("txid/wtxid mismatch")
+	}
+	if decoded.Size != msgTx.SerializeSize() || decoded.Weight != msgTx.SerializeSizeStripped()*3+msgTx.SerializeSize() {
+		t.Error("size/weight mismatch")
+	}
+	if decoded.VSize != (decoded.Weight+3)/4 || decoded.VSize >= decoded.Size {
+		t.Error("unexpected vsize", decoded.VSize)
+	}
+	if decoded.Vin[0].PrevOut.Type != "pubkeyhash" || decoded.Vin[1].PrevOut.Type != "witness_v0_keyhash" {
+		t.Error("unexpected prevout types")
+	}
+	if len(decoded.Vin[0].Witness) != 0 || len(decoded.Vin[1].Witness) != 2 || decoded.Vin[0].ScriptSig.Hex == "" {
+		t.Error("unexpected input scripts")
+	}
+	if decoded.Vout[0].Type != "nonstandard" || decoded.Vout[0].Amount != 10000 || decoded.TotalOut != 30000 {
+		t.Error("unexpected outputs")
+	}
+	if decoded.TotalIn == nil || *decoded.TotalIn != 100000 || *decoded.Fee != 70000 {
+		t.Error("unexpected fee")
+	}
+
+	decoded, err = BTCDecodeRawTransaction(signedHex, utxos[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Fee != nil || decoded.TotalIn != nil || decoded.Vin[1].PrevOut != nil {
+		t.Error("fee reported with unknown prevouts")
+	}
+}
+
+func TestBTCScriptType(t *testing.T) {
+	privKeyBytes, _ := hex.DecodeString(testPrivKeyHex(7))
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(privKeyBytes)
+	expected := []string{"pubkeyhash", "witness_v0_keyhash", "scripthash", "witness_v1_taproot"}
+	for i, scriptPubKey := range scriptPubKeys {
+		if BTCScriptType(scriptPubKey) != expected[i] {
+			t.Error("unexpected script type", BTCScriptType(scriptPubKey))
+		}
+	}
+	if BTCScriptType([]byte{0x6a, 0x01, 0x01}) != "nulldata" {
+		t.Error("unexpected script type for OP_RETURN")
+	}
+}
+
+func TestBTCUnPackRawTransactionMalformed(t *testing.T) {
+	rawTrx := testUnsignedTrx(2, 2)
+	for _, malformed := range []string{
+		"",
+		"zz",
+		rawTrx[:len(rawTrx)/2],
+		rawTrx[:len(rawTrx)-2],
+		"02000000ffffffffff",
+		"0200000001" + strings.Repeat("00", 36) + "ffffffffffffffffff",
+		"020000000001ff",
+		"02000000ffffffffffffffff7f",
+		"0200000000010100000000ffffffffffffffff7f",
+	} {
+		if _, err := BTCUnPackRawTransaction(malformed); err == nil {
+			t.Error("malformed transaction unpacked:", malformed)
+		}
+		if _, err := BTCDecodeRawTransaction(malformed, nil); err == nil {
+			t.Error("malformed transaction decoded:", malformed)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/mutalisk999/bitcoin-lib/src/keyid"
+	"github.com/mutalisk999/bitcoin-lib/src/script"
+	"github.com/mutalisk999/bitcoin-lib/src/utility"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const descriptorInputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+	"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+	"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+
+const descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// max number of addresses derived by a single request
+const maxDescriptorRangeSize = 1000
+
+type DerivedAddress struct {
+	Index        int    `json:"index"`
+	Address      string `json:"address"`
+	ScriptPubKey string `json:"scriptPubKey"`
+}
+
+type descriptorKey struct {
+	Origin string
+	PubKey []byte
+	ExtKey *hdkeychain.ExtendedKey
+	Path   []uint32
+	Ranged bool
+}
+
+type Descriptor struct {
+	Name      string
+	Keys      []*descriptorKey
+	Threshold int
+	Sub       *Descriptor
+	// descriptor string without checksum
+	body string
+}
+
+func descriptorPolymod(symbols []uint64) uint64 {
+	generator := []uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+	chk := uint64(1)
+	for _, value := range symbols {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ value
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// BTCDescriptorChecksum computes the 8 character BIP380 checksum of a
+// descriptor given without its '#' suffix.
+func BTCDescriptorChecksum(desc string) (string, error) {
+	symbols := make([]uint64, 0, len(desc)*2)
+	groups := make([]uint64, 0, 3)
+	for i := 0; i < len(desc); i++ {
+		v := strings.IndexByte(descriptorInputCharset, desc[i])
+		if v < 0 {
+			return "", fmt.Errorf("invalid descriptor character '%c'", desc[i])
+		}
+		symbols = append(symbols, uint64(v&31))
+		groups = append(groups, uint64(v>>5))
+		if len(groups) == 3 {
+			symbols = append(symbols, groups[0]*9+groups[1]*3+groups[2])
+			groups = groups[:0]
+		}
+	}
+	if len(groups) == 1 {
+		symbols = append(symbols, groups[0])
+	} else if len(groups) == 2 {
+		symbols = append(symbols, groups[0]*3+groups[1])
+	}
+	symbols = append(symbols, 0, 0, 0, 0, 0, 0, 0, 0)
+	checksum := descriptorPolymod(symbols) ^ 1
+
+	var sb strings.Builder
+	for i := 0; i < 8; i++ {
+		sb.WriteByte(descriptorChecksumCharset[(checksum>>uint(5*(7-i)))&31])
+	}
+	return sb.String(), nil
+}
+
+// BTCParseDescriptor parses an output descriptor. When requireChecksum is
+// set the descriptor must carry a '#checksum' suffix; a present checksum is
+// always verified.
+func BTCParseDescriptor(desc string, requireChecksum bool) (*Descriptor, error) {
+	desc = strings.TrimSpace(desc)
+	body := desc
+	if pos := strings.IndexByte(desc, '#'); pos >= 0 {
+		body = desc[:pos]
+		checksum, err := BTCDescriptorChecksum(body)
+		if err != nil {
+			return nil, err
+		}
+		if desc[pos+1:] != checksum {
+			return nil, fmt.Errorf("invalid descriptor checksum, expected %s", checksum)
+		}
+	} else {
+		if requireChecksum {
+			return nil, errors.New("missing descriptor checksum")
+		}
+		_, err := BTCDescriptorChecksum(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d, err := parseDescriptorExpr(body, "top")
+	if err != nil {
+		return nil, err
+	}
+	d.body = body
+	return d, nil
+}
+
+// context is one of "top", "sh", "wsh"
+func parseDescriptorExpr(expr string, context string) (*Descriptor, error) {
+	open := strings.IndexByte(expr, '(')
+	if open <= 0 || expr[len(expr)-1] != ')' {
+		return nil, fmt.Errorf("invalid descriptor expression: %s", expr)
+	}
+	d := new(Descriptor)
+	d.Name = expr[:open]
+	args, err := splitDescriptorArgs(expr[open+1 : len(expr)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	switch d.Name {
+	case "pkh":
+		if len(args) != 1 {
+			return nil, errors.New("pkh() takes exactly one key")
+		}
+		key, err := parseDescriptorKey(args[0], context == "top" || context == "sh", false)
+		if err != nil {
+			return nil, err
+		}
+		d.Keys = []*descriptorKey{key}
+	case "wpkh":
+		if context == "wsh" {
+			return nil, errors.New("wpkh() is not allowed inside wsh()")
+		}
+		if len(args) != 1 {
+			return nil, errors.New("wpkh() takes exactly one key")
+		}
+		key, err := parseDescriptorKey(args[0], false, false)
+		if err != nil {
+			return nil, err
+		}
+		d.Keys = []*descriptorKey{key}
+	case "sh":
+		if context != "top" {
+			return nil, errors.New("sh() is only allowed at top level")
+		}
+		if len(args) != 1 {
+			return nil, errors.New("sh() takes exactly one argument")
+		}
+		d.Sub, err = parseDescriptorExpr(args[0], "sh")
+		if err != nil {
+			return nil, err
+		}
+		if d.Sub.Name == "tr" {
+			return nil, errors.New("tr() is not allowed inside sh()")
+		}
+	case "wsh":
+		if context == "wsh" {
+			return nil, errors.New("wsh() is not allowed inside wsh()")
+		}
+		if len(args) != 1 {
+			return nil, errors.New("wsh() takes exactly one argument")
+		}
+		d.Sub, err = parseDescriptorExpr(args[0], "wsh")
+		if err != nil {
+			return nil, err
+		}
+		if d.Sub.Name == "tr" {
+			return nil, errors.New("tr() is not allowed inside wsh()")
+		}
+	case "multi", "sortedmulti":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s() needs a threshold and at least one key", d.Name)
+		}
+		d.Threshold, err = strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s() threshold: %s", d.Name, args[0])
+		}
+		if len(args)-1 > 16 {
+			return nil, fmt.Errorf("%s() supports at most 16 keys", d.Name)
+		}
+		if d.Threshold <= 0 || d.Threshold > len(args)-1 {
+			return nil, fmt.Errorf("%s() threshold out of range", d.Name)
+		}
+		for _, arg := range args[1:] {
+			key, err := parseDescriptorKey(arg, context != "wsh", false)
+			if err != nil {
+				return nil, err
+			}
+			d.Keys = append(d.Keys, key)
+		}
+	case "tr":
+		if context != "top" {
+			return nil, errors.New("tr() is only allowed at top level")
+		}
+		if len(args) != 1 {
+			return nil, errors.New("tr() script trees are not supported")
+		}
+		key, err := parseDescriptorKey(args[0], false, true)
+		if err != nil {
+			return nil, err
+		}
+		d.Keys = []*descriptorKey{key}
+	default:
+		return nil, fmt.Errorf("unsupported descriptor function: %s", d.Name)
+	}
+	return d, nil
+}
+
+func splitDescriptorArgs(s string) ([]string, error) {
+	args := make([]string, 0)
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unbalanced brackets in descriptor")
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced brackets in descriptor")
+	}
+	args = append(args, s[start:])
+	for _, arg := range args {
+		if arg == "" {
+			return nil, errors.New("empty descriptor argument")
+		}
+	}
+	return args, nil
+}
+
+func parseDescriptorKey(s string, allowUncompressed bool, xOnly bool) (*descriptorKey, error) {
+	key := new(descriptorKey)
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return nil, errors.New("key origin start '[' without end ']'")
+		}
+		key.Origin = s[1:end]
+		fingerprint := strings.Split(key.Origin, "/")[0]
+		if len(fingerprint) != 8 {
+			return nil, errors.New("key origin fingerprint must be 8 hex characters")
+		}
+		if _, err := hex.DecodeString(fingerprint); err != nil {
+			return nil, errors.New("key origin fingerprint is not hex")
+		}
+		s = s[end+1:]
+	}
+
+	elems := strings.Split(s, "/")
+	pubKeyBytes, err := hex.DecodeString(elems[0])
+	if err == nil {
+		if len(elems) > 1 {
+			return nil, errors.New("derivation path is only allowed after extended keys")
+		}
+		if xOnly && len(pubKeyBytes) == 32 {
+			pubKeyBytes = append([]byte{0x2}, pubKeyBytes...)
+		}
+		pubKey, err := btcec.ParsePubKey(pubKeyBytes, btcec.S256())
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %s", elems[0], err.Error())
+		}
+		if len(pubKeyBytes) != btcec.PubKeyBytesLenCompressed {
+			if !allowUncompressed {
+				return nil, errors.New("uncompressed keys are not allowed here")
+			}
+			key.PubKey = pubKey.SerializeUncompressed()
+		} else {
+			key.PubKey = pubKey.SerializeCompressed()
+		}
+		return key, nil
+	}
+
+	extKey, err := hdkeychain.NewKeyFromString(elems[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %s", elems[0], err.Error())
+	}
+	if extKey.IsPrivate() {
+		return nil, errors.New("private keys are not supported in descriptors")
+	}
+	netParams, err := GetNetParams()
+	if err != nil {
+		return nil, err
+	}
+	if !extKey.IsForNet(netParams) {
+		return nil, errors.New("extended key is not for the configured network")
+	}
+	key.ExtKey = extKey
+	for i, elem := range elems[1:] {
+		if elem == "*" {
+			if i != len(elems)-2 {
+				return nil, errors.New("'*' is only allowed as the last derivation step")
+			}
+			key.Ranged = true
+			continue
+		}
+		if strings.HasSuffix(elem, "'") || strings.HasSuffix(elem, "h") || elem == "*'" {
+			return nil, errors.New("hardened derivation is not possible from an extended public key")
+		}
+		n, err := strconv.ParseUint(elem, 10, 32)
+		if err != nil || n >= hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("invalid derivation step: %s", elem)
+		}
+		key.Path = append(key.Path, uint32(n))
+	}
+	return key, nil
+}
+
+func (k *descriptorKey) derive(index uint32) ([]byte, error) {
+	if k.ExtKey == nil {
+		return k.PubKey, nil
+	}
+	extKey := k.ExtKey
+	var err error
+	for _, n := range k.Path {
+		extKey, err = extKey.Child(n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if k.Ranged {
+		extKey, err = extKey.Child(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pubKey, err := extKey.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	return pubKey.SerializeCompressed(), nil
+}
+
+// IsRange reports whether the descriptor contains a '*' derivation step.
+func (d *Descriptor) IsRange() bool {
+	for _, key := range d.Keys {
+		if key.Ranged {
+			return true
+		}
+	}
+	if d.Sub != nil {
+		return d.Sub.IsRange()
+	}
+	return false
+}
+
+// String returns the descriptor with its checksum appended.
+func (d *Descriptor) String() string {
+	checksum, _ := BTCDescriptorChecksum(d.body)
+	return d.body + "#" + checksum
+}
+
+// expand returns the output script of this descriptor node at index.
+func (d *Descriptor) expand(index uint32) ([]byte, error) {
+	switch d.Name {
+	case "pkh":
+		pubKey, err := d.Keys[0].derive(index)
+		if err != nil {
+			return nil, err
+		}
+		bufBytes := make([]byte, 0, 25)
+		bufBytes = append(bufBytes, script.OP_DUP, script.OP_HASH160, byte(keyid.KEY_ID_SIZE))
+		bufBytes = append(bufBytes, utility.Hash160(pubKey)...)
+		bufBytes = append(bufBytes, script.OP_EQUALVERIFY, script.OP_CHECKSIG)
+		return bufBytes, nil
+	case "wpkh":
+		pubKey, err := d.Keys[0].derive(index)
+		if err != nil {
+			return nil, err
+		}
+		return BTCGetWitnessScriptPubKey(0, utility.Hash160(pubKey)), nil
+	case "sh":
+		subScript, err := d.Sub.expand(index)
+		if err != nil {
+			return nil, err
+		}
+		if len(subScript) > 520 {
+			return nil, errors.New("redeem script exceeds 520 bytes")
+		}
+		return BTCGetP2SHScriptPubKey(subScript), nil
+	case "wsh":
+		subScript, err := d.Sub.expand(index)
+		if err != nil {
+			return nil, err
+		}
+		return BTCGetWitnessScriptPubKey(0, utility.Sha256(subScript)), nil
+	case "multi", "sortedmulti":
+		pubKeys := make([][]byte, 0, len(d.Keys))
+		for _, key := range d.Keys {
+			pubKey, err := key.derive(index)
+			if err != nil {
+				return nil, err
+			}
+			pubKeys = append(pubKeys, pubKey)
+		}
+		if d.Name == "sortedmulti" {
+			sort.Slice(pubKeys, func(i, j int) bool {
+				return bytes.Compare(pubKeys[i], pubKeys[j]) < 0
+			})
+		}
+		bufBytes := make([]byte, 0)
+		bufBytes = append(bufBytes, script.OP_1+byte(d.Threshold)-1)
+		for _, pubKey := range pubKeys {
+			bufBytes = append(bufBytes, byte(len(pubKey)))
+			bufBytes = append(bufBytes, pubKey...)
+		}
+		bufBytes = append(bufBytes, script.OP_1+byte(len(pubKeys))-1, script.OP_CHECKMULTISIG)
+		return bufBytes, nil
+	case "tr":
+		pubKey, err := d.Keys[0].derive(index)
+		if err != nil {
+			return nil, err
+		}
+		outputKey, err := BTCTaprootTweakPubKey(pubKey[1:], nil)
+		if err != nil {
+			return nil, err
+		}
+		return BTCGetWitnessScriptPubKey(1, outputKey), nil
+	}
+	return nil, fmt.Errorf("unsupported descriptor function: %s", d.Name)
+}
+
+// DeriveAddresses derives the addresses for indexes begin..end inclusive.
+// Non-range descriptors only yield a single address.
+func (d *Descriptor) DeriveAddresses(begin uint32, end uint32) ([]DerivedAddress, error) {
+	if !d.IsRange() {
+		begin, end = 0, 0
+	}
+	if end < begin {
+		return nil, errors.New("invalid range, end is less than begin")
+	}
+	if end-begin >= maxDescriptorRangeSize {
+		return nil, fmt.Errorf("range too large, at most %d addresses", maxDescriptorRangeSize)
+	}
+	if end >= hdkeychain.HardenedKeyStart {
+		return nil, errors.New("range end out of bounds")
+	}
+
+	derived := make([]DerivedAddress, 0, end-begin+1)
+	for i := begin; i <= end; i++ {
+		scriptPubKey, err := d.expand(i)
+		if err != nil {
+			return nil, err
+		}
+		addrStr, err := BTCAddressFromScriptPubKey(scriptPubKey)
+		if err != nil {
+			return nil, fmt.Errorf("descriptor %s() has no address form", d.Name)
+		}
+		index := -1
+		if d.IsRange() {
+			index = int(i)
+		}
+		derived = append(derived, DerivedAddress{Index: index, Address: addrStr, ScriptPubKey: hex.EncodeToString(scriptPubKey)})
+	}
+	return derived, nil
+}
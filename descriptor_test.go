@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBTCDescriptorChecksum(t *testing.T) {
+	checksum, _ := BTCDescriptorChecksum("raw(deadbeef)")
+	if checksum != "89f8spxm" {
+		t.Error("unexpected checksum:", checksum)
+	}
+
+	_, err := BTCParseDescriptor("pkh(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)#00000000", false)
+	if err == nil {
+		t.Error("invalid checksum accepted")
+	}
+	_, err = BTCParseDescriptor("pkh(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)", true)
+	if err == nil {
+		t.Error("missing checksum accepted")
+	}
+}
+
+func TestBTCParseDescriptorSingleKey(t *testing.T) {
+	pubKeyHexStr := "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	expected := map[string]string{
+		"pkh(" + pubKeyHexStr + ")":                     "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH",
+		"wpkh(" + pubKeyHexStr + ")":                    "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		"sh(wpkh(" + pubKeyHexStr + "))":                "3JvL6Ymt8MVWiCNHC7oWU6nLeHNJKLZGLN",
+		"pkh([d34db33f/44'/0'/0']" + pubKeyHexStr + ")": "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH",
+	}
+	for descStr, addrStr := range expected {
+		desc, err := BTCParseDescriptor(descStr, false)
+		if err != nil {
+			t.Error(descStr, err)
+			continue
+		}
+		derived, err := desc.DeriveAddresses(0, 0)
+		if err != nil {
+			t.Error(descStr, err)
+			continue
+		}
+		if len(derived) != 1 || derived[0].Address != addrStr || derived[0].Index != -1 {
+			t.Error(descStr, "unexpected derived:", derived)
+		}
+	}
+}
+
+func TestBTCParseDescriptorTaproot(t *testing.T) {
+	// BIP86 test vectors
+	desc, err := BTCParseDescriptor("tr(xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ/0/*)#8e7pq23w", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err := desc.DeriveAddresses(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(derived) != 2 ||
+		derived[0].Address != "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr" ||
+		derived[1].Address != "bc1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0was9fqzwh" {
+		t.Error("unexpected taproot addresses")
+	}
+}
+
+func TestBTCParseDescriptorMulti(t *testing.T) {
+	pubKeyHexStr1 := "0303b98c2753cb48a456d88c89727936797d7fa890eb600dddf32940a1e835188b"
+	pubKeyHexStr2 := "02cd7c2fe2be798cf062de43783177fab7a3436af29a6aeb65c78399cbf25f84a9"
+	pubKeyHexStr3 := "0351519038c945c71a5268ae27729731f886b56b5e14b202d351530a92bdec8f59"
+	pubKeyHexStr4 := "02ec30578e5647e00a20ad3ef98b08381cd57e28e00293ff5a27bf0981bac008b5"
+	pubKeyHexStr5 := "036ff86d871899f06bd68f201c894cd872a19b15f4e284c2d86227176fbdc0a9bf"
+
+	// must match TestBTCGetMultiSignAddressByRedeemScript
+	desc, err := BTCParseDescriptor(fmt.Sprintf("sh(multi(3,%s,%s,%s,%s,%s))",
+		pubKeyHexStr1, pubKeyHexStr2, pubKeyHexStr3, pubKeyHexStr4, pubKeyHexStr5), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err := desc.DeriveAddresses(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived[0].Address != "3MDSq8EZGz71f9BCLy1tpndHjvbXH8Wj4V" {
+		t.Error("unexpected multisig address:", derived[0].Address)
+	}
+
+	sorted, _ := BTCParseDescriptor(fmt.Sprintf("wsh(sortedmulti(2,%s,%s))", pubKeyHexStr1, pubKeyHexStr2), false)
+	ordered, _ := BTCParseDescriptor(fmt.Sprintf("wsh(multi(2,%s,%s))", pubKeyHexStr2, pubKeyHexStr1), false)
+	sortedDerived, err := sorted.DeriveAddresses(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderedDerived, err := ordered.DeriveAddresses(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sortedDerived[0].Address != orderedDerived[0].Address {
+		t.Error("sortedmulti does not sort keys")
+	}
+
+	bare, _ := BTCParseDescriptor(fmt.Sprintf("multi(1,%s)", pubKeyHexStr1), false)
+	_, err = bare.DeriveAddresses(0, 0)
+	if err == nil {
+		t.Error("bare multisig has no address")
+	}
+}
+
+func TestBTCParseDescriptorInvalid(t *testing.T) {
+	invalid := []string{
+		"wsh(wpkh(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798))",
+		"sh(sh(pkh(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)))",
+		"wpkh(0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8)",
+		"tr(xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ/0'/*)",
+		"multi(3,0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)",
+		"combo(0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798)",
+	}
+	for _, descStr := range invalid {
+		_, err := BTCParseDescriptor(descStr, false)
+		if err == nil {
+			t.Error("invalid descriptor accepted:", descStr)
+		}
+	}
+}
@@ -3,11 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/kataras/iris/v12"
 	"golang.org/x/crypto/ssh/terminal"
 	"os"
 	"syscall"
+	"time"
 )
 
 var SecurityPassStr string = "xxkz1&rlje\x00\x00\x00\x00\x00\x00"
@@ -40,6 +40,11 @@ func LoadConf() error {
 		fmt.Println("Load config.json", err)
 		return err
 	}
+	_, err = NetParamsByName(GlobalConfig.Network)
+	if err != nil {
+		fmt.Println("Load config.json", err)
+		return err
+	}
 	return nil
 }
 
@@ -87,9 +92,40 @@ func main() {
 
 	err = InitDB(GlobalConfig.DbConfig.DbType, GlobalConfig.DbConfig.DbSource)
 	if err != nil {
-		Error.Println("InitDB fail")
+		Error.Println("InitDB fail:", err.Error())
+		os.Exit(-1)
+	}
+
+	version, err := Migrate()
+	if err != nil {
+		Error.Println("Migrate fail:", err.Error())
+		fmt.Println("Migrate fail:", err.Error())
 		os.Exit(-1)
 	}
+	// migrate only: xt_btc_signer migrate
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		fmt.Println("schema version:", version)
+		return
+	}
+
+	if GlobalConfig.SyncConfig.Enable {
+		pollInterval := GlobalConfig.SyncConfig.PollInterval
+		if pollInterval <= 0 {
+			pollInterval = 30
+		}
+		scanner := NewChainScanner(NewRpcChainNode(GlobalConfig.ServerUrl), GlobalConfig.SyncConfig.StartHeight)
+		go scanner.Run(time.Duration(pollInterval)*time.Second, make(chan struct{}))
+	}
+
+	if GlobalConfig.UtxoConfig.ReapInterval > 0 {
+		reaper := NewPendingReaper(NewRpcChainNode(GlobalConfig.ServerUrl))
+		go reaper.Run(time.Duration(GlobalConfig.UtxoConfig.ReapInterval)*time.Second, make(chan struct{}))
+	}
+
+	if GlobalConfig.TxConfig.PollInterval > 0 {
+		tracker := NewTxTracker(NewRpcChainNode(GlobalConfig.ServerUrl))
+		go tracker.Run(time.Duration(GlobalConfig.TxConfig.PollInterval)*time.Second, make(chan struct{}))
+	}
 
 	app = iris.New()
 	app.Use(func(ctx iris.Context) {
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	InitLog(os.DevNull, os.DevNull, DEBUG)
+	os.Exit(m.Run())
+}
+
+func TestLoadConfNetwork(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	defer func(config Config) { GlobalConfig = config }(GlobalConfig)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	for network, valid := range map[string]bool{"": true, "testnet": true, "regtest": true, "signet": false, "mainet": false} {
+		err := os.WriteFile("config.json", []byte(`{"network": "`+network+`"}`), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = LoadConf(); (err == nil) != valid {
+			t.Error("unexpected config load of network", network, err)
+		}
+	}
+	GlobalConfig.Network = "signet"
+	if _, err := BTCScriptPubKeyFromAddress("1GJ23Q56cMqfVuGskN5gUKj2YYkmbtNVnL"); err == nil {
+		t.Error("address decoded for an unknown network")
+	}
+}
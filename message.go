@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/mutalisk999/bitcoin-lib/src/bigint"
+	"github.com/mutalisk999/bitcoin-lib/src/script"
+	"github.com/mutalisk999/bitcoin-lib/src/serialize"
+	"github.com/mutalisk999/bitcoin-lib/src/transaction"
+	"github.com/mutalisk999/bitcoin-lib/src/utility"
+	"io"
+	"strings"
+)
+
+const (
+	// Bitcoin Core compatible compact signature, also used by BIP322 for
+	// P2PKH addresses
+	MessageFormatBIP137 = "bip137"
+	// BIP322 witness stack of the to_sign transaction
+	MessageFormatSimple = "simple"
+	// BIP322 fully serialized to_sign transaction
+	MessageFormatFull = "full"
+
+	messageMagic = "Bitcoin Signed Message:\n"
+)
+
+// BTCMessageHash returns the double sha256 of the magic prefixed message
+// signed by BIP137 signatures.
+func BTCMessageHash(message string) []byte {
+	bytesBuf := bytes.NewBuffer([]byte{})
+	_ = serialize.PackCompactSize(bytesBuf, uint64(len(messageMagic)))
+	bytesBuf.WriteString(messageMagic)
+	_ = serialize.PackCompactSize(bytesBuf, uint64(len(message)))
+	bytesBuf.WriteString(message)
+	return doubleSha256(bytesBuf.Bytes())
+}
+
+// BTCSignMessageBIP137 creates a base64 compact signature. The header byte
+// follows BIP137 for the address type of addr, which must belong to the key.
+func BTCSignMessageBIP137(privKeyStr string, addr string, message string) (string, error) {
+	privKeyBytes, err := hex.DecodeString(privKeyStr)
+	if err != nil {
+		return "", err
+	}
+	scriptPubKey, err := BTCScriptPubKeyFromAddress(addr)
+	if err != nil {
+		return "", err
+	}
+	scriptPubKeys, err := BTCKeyScriptPubKeys(privKeyBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// header offsets of compressed P2PKH, P2SH-P2WPKH and P2WPKH keys
+	headerOffset := byte(0)
+	switch {
+	case bytes.Equal(scriptPubKey, scriptPubKeys[0]):
+		headerOffset = 4
+	case bytes.Equal(scriptPubKey, scriptPubKeys[2]):
+		headerOffset = 8
+	case bytes.Equal(scriptPubKey, scriptPubKeys[1]):
+		headerOffset = 12
+	default:
+		return "", errors.New("address does not belong to the private key or has no BIP137 form")
+	}
+
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), privKeyBytes)
+	signature, err := btcec.SignCompact(btcec.S256(), privKey, BTCMessageHash(message), true)
+	if err != nil {
+		return "", err
+	}
+	// SignCompact already added the compressed offset of 4
+	signature[0] = signature[0] - 4 + headerOffset
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+func verifyMessageBIP137(addr string, message string, signature []byte) (bool, error) {
+	if len(signature) != 65 || signature[0] < 27 || signature[0] > 42 {
+		return false, errors.New("invalid compact signature")
+	}
+	scriptPubKey, err := BTCScriptPubKeyFromAddress(addr)
+	if err != nil {
+		return false, err
+	}
+
+	header := signature[0]
+	compactSig := make([]byte, 65)
+	copy(compactSig, signature)
+	compactSig[0] = 27 + (header-27)%4
+	if header >= 31 {
+		compactSig[0] += 4
+	}
+	pubKey, compressed, err := btcec.RecoverCompact(btcec.S256(), compactSig, BTCMessageHash(message))
+	if err != nil {
+		return false, nil
+	}
+
+	if !compressed {
+		// OP_DUP OP_HASH160 <hash160(uncompressed pubkey)> OP_EQUALVERIFY OP_CHECKSIG
+		p2pkhScriptPubKey := []byte{script.OP_DUP, script.OP_HASH160, 20}
+		p2pkhScriptPubKey = append(p2pkhScriptPubKey, utility.Hash160(pubKey.SerializeUncompressed())...)
+		p2pkhScriptPubKey = append(p2pkhScriptPubKey, script.OP_EQUALVERIFY, script.OP_CHECKSIG)
+		return bytes.Equal(scriptPubKey, p2pkhScriptPubKey), nil
+	}
+
+	// like Bitcoin Core and most wallets, a compressed key signature is
+	// accepted for any single key address type the key controls
+	pubKeyHash := utility.Hash160(pubKey.SerializeCompressed())
+	p2pkhScriptPubKey, err := BTCGetP2PKHScriptPubKey(hex.EncodeToString(pubKey.SerializeUncompressed()[1:]))
+	if err != nil {
+		return false, err
+	}
+	p2wpkhScriptPubKey := BTCGetWitnessScriptPubKey(0, pubKeyHash)
+	for _, candidate := range [][]byte{p2pkhScriptPubKey, p2wpkhScriptPubKey, BTCGetP2SHScriptPubKey(p2wpkhScriptPubKey)} {
+		if bytes.Equal(scriptPubKey, candidate) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// BTCBIP322MessageHash returns the BIP322 tagged hash of message.
+func BTCBIP322MessageHash(message string) []byte {
+	return BTCTaggedHash("BIP0322-signed-message", []byte(message))
+}
+
+// bip322ToSpend builds the virtual to_spend transaction committing to
+// message and paying to messageChallenge.
+func bip322ToSpend(messageChallenge []byte, message string) transaction.Transaction {
+	var toSpend transaction.Transaction
+	toSpend.Version = 0
+	toSpend.LockTime = 0
+	toSpend.Vin = make([]transaction.TxIn, 1)
+	_ = toSpend.Vin[0].PrevOut.Hash.SetData(make([]byte, 32))
+	toSpend.Vin[0].PrevOut.N = 0xffffffff
+	scriptSig := []byte{script.OP_0}
+	scriptSig = append(scriptSig, BTCPushData(BTCBIP322MessageHash(message))...)
+	toSpend.Vin[0].ScriptSig.SetScriptBytes(scriptSig)
+	toSpend.Vin[0].Sequence = 0
+	toSpend.Vout = make([]transaction.TxOut, 1)
+	toSpend.Vout[0].Value = 0
+	toSpend.Vout[0].ScriptPubKey.SetScriptBytes(messageChallenge)
+	return toSpend
+}
+
+// bip322ToSign builds the unsigned virtual to_sign transaction spending
+// toSpend.
+func bip322ToSign(toSpend transaction.Transaction) (transaction.Transaction, error) {
+	var toSign transaction.Transaction
+	toSpendId, err := toSpend.CalcTrxId()
+	if err != nil {
+		return toSign, err
+	}
+	toSign.Version = 0
+	toSign.LockTime = 0
+	toSign.Vin = make([]transaction.TxIn, 1)
+	toSign.Vin[0].PrevOut.Hash = toSpendId
+	toSign.Vin[0].PrevOut.N = 0
+	toSign.Vin[0].ScriptSig.SetScriptBytes([]byte{})
+	toSign.Vin[0].Sequence = 0
+	toSign.Vout = make([]transaction.TxOut, 1)
+	toSign.Vout[0].Value = 0
+	toSign.Vout[0].ScriptPubKey.SetScriptBytes([]byte{script.OP_RETURN})
+	return toSign, nil
+}
+
+// BTCSignMessageBIP322 creates a BIP322 simple or full signature of message
+// for addr, which must be a single key address of privKeyStr. P2SH-P2WPKH
+// needs a scriptSig and therefore only has the full format.
+func BTCSignMessageBIP322(privKeyStr string, addr string, message string, format string) (string, error) {
+	privKeyBytes, err := hex.DecodeString(privKeyStr)
+	if err != nil {
+		return "", err
+	}
+	messageChallenge, err := BTCScriptPubKeyFromAddress(addr)
+	if err != nil {
+		return "", err
+	}
+
+	toSign, err := bip322ToSign(bip322ToSpend(messageChallenge, message))
+	if err != nil {
+		return "", err
+	}
+	utxo := UTXODetail{TxId: toSign.Vin[0].PrevOut.Hash.GetHex(), Vout: 0, ScriptPubKey: hex.EncodeToString(messageChallenge), Amount: 0}
+	prevOuts := prevOutsFromUtxos(&toSign, []UTXODetail{utxo})
+	err = BTCSignInput(&toSign, 0, privKeyBytes, &utxo, prevOuts, nil)
+	if err != nil {
+		return "", err
+	}
+
+	report, err := BTCVerifyTransactionInputs(&toSign, [][]byte{messageChallenge}, []int64{0}, prevOuts)
+	if err != nil {
+		return "", err
+	}
+	err = VerificationError(report)
+	if err != nil {
+		return "", err
+	}
+
+	bytesBuf := bytes.NewBuffer([]byte{})
+	switch format {
+	case MessageFormatSimple:
+		if len(toSign.Vin[0].ScriptSig.GetScriptBytes()) != 0 {
+			return "", errors.New("address type needs the full signature format")
+		}
+		err = toSign.Vin[0].ScriptWitness.Pack(bytesBuf)
+	case MessageFormatFull:
+		err = toSign.Pack(bytesBuf)
+	default:
+		return "", errors.New("invalid message signature format: " + format)
+	}
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(bytesBuf.Bytes()), nil
+}
+
+// isWitnessEncoding reports whether data is exactly one serialized witness
+// stack, checking the item count and sizes against the bytes left before
+// anything is allocated for them.
+func isWitnessEncoding(data []byte) bool {
+	reader := bytes.NewReader(data)
+	count, err := wire.ReadVarInt(reader, 0)
+	if err != nil || count > uint64(reader.Len()) {
+		return false
+	}
+	for i := uint64(0); i < count; i++ {
+		size, err := wire.ReadVarInt(reader, 0)
+		if err != nil || size > uint64(reader.Len()) {
+			return false
+		}
+		_, _ = reader.Seek(int64(size), io.SeekCurrent)
+	}
+	return reader.Len() == 0
+}
+
+func verifyMessageBIP322(addr string, message string, signature []byte) (bool, error) {
+	messageChallenge, err := BTCScriptPubKeyFromAddress(addr)
+	if err != nil {
+		return false, err
+	}
+	toSpend := bip322ToSpend(messageChallenge, message)
+	toSign, err := bip322ToSign(toSpend)
+	if err != nil {
+		return false, err
+	}
+
+	// full format, the proof of funds variant with extra inputs is not supported
+	signed, err := unpackTransaction(signature)
+	if err == nil && len(signed.Vin) == 1 && len(signed.Vout) == 1 &&
+		bigint.IsUint256Equal(&signed.Vin[0].PrevOut.Hash, &toSign.Vin[0].PrevOut.Hash) && signed.Vin[0].PrevOut.N == 0 &&
+		signed.Vout[0].Value == 0 && bytes.Equal(signed.Vout[0].ScriptPubKey.GetScriptBytes(), []byte{script.OP_RETURN}) {
+		toSign = *signed
+	} else {
+		var witness script.ScriptWitness
+		if !isWitnessEncoding(signature) {
+			return false, errors.New("invalid BIP322 signature encoding")
+		}
+		rest, err := safeUnPack(signature, witness.UnPack)
+		if err != nil || rest != 0 {
+			return false, errors.New("invalid BIP322 signature encoding")
+		}
+		toSign.Vin[0].ScriptWitness = witness
+	}
+
+	prevOuts := make([]transaction.TxOut, 1)
+	prevOuts[0].Value = 0
+	prevOuts[0].ScriptPubKey.SetScriptBytes(messageChallenge)
+	report, err := BTCVerifyTransactionInputs(&toSign, [][]byte{messageChallenge}, []int64{0}, prevOuts)
+	if err != nil {
+		return false, err
+	}
+	return report[0].Status == InputVerifyStatusVerified, nil
+}
+
+// BTCSignMessage signs message for addr with privKeyStr. An empty format
+// picks BIP137 for P2PKH, the BIP322 full format for P2SH-P2WPKH and the
+// BIP322 simple format for native segwit and taproot addresses.
+func BTCSignMessage(privKeyStr string, addr string, message string, format string) (string, error) {
+	format = strings.ToLower(format)
+	if format == "" {
+		scriptPubKey, err := BTCScriptPubKeyFromAddress(addr)
+		if err != nil {
+			return "", err
+		}
+		switch BTCScriptType(scriptPubKey) {
+		case "pubkeyhash":
+			format = MessageFormatBIP137
+		case "scripthash":
+			format = MessageFormatFull
+		default:
+			format = MessageFormatSimple
+		}
+	}
+	if format == MessageFormatBIP137 {
+		return BTCSignMessageBIP137(privKeyStr, addr, message)
+	}
+	return BTCSignMessageBIP322(privKeyStr, addr, message, format)
+}
+
+// BTCVerifyMessage verifies a BIP137 compact signature or a BIP322 simple
+// or full signature of message for addr.
+func BTCVerifyMessage(addr string, message string, signatureStr string) (bool, error) {
+	signature, err := base64.StdEncoding.DecodeString(signatureStr)
+	if err != nil {
+		return false, errors.New("signature is not base64 encoded")
+	}
+	if len(signature) == 65 && signature[0] >= 27 && signature[0] <= 42 {
+		verified, err := verifyMessageBIP137(addr, message, signature)
+		if err != nil || verified {
+			return verified, err
+		}
+	}
+	return verifyMessageBIP322(addr, message, signature)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/mutalisk999/bitcoin-lib/src/base58"
+	"testing"
+)
+
+// BIP322 test vector key and address
+const (
+	testMessageWIF     = "L3VFeEujGtevx9w18HD1fhRbCH67Az2dpCymeRE1SoPK6XQtaN2k"
+	testMessageAddress = "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l"
+)
+
+func testMessagePrivKeyHex(t *testing.T) string {
+	decoded, err := base58.Decode(testMessageWIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(decoded[1:33])
+}
+
+func TestBTCBIP322MessageHash(t *testing.T) {
+	if hex.EncodeToString(BTCBIP322MessageHash("")) != "c90c269c4f8fcbe6880f72a721ddfbf1914268a794cbb21cfafee13770ae19f1" {
+		t.Error("unexpected message hash of empty message")
+	}
+	if hex.EncodeToString(BTCBIP322MessageHash("Hello World")) != "f0eb03b1a75ac6d9847f55c624a99169b5dccba2a31f5b23bea77ba270de0a7a" {
+		t.Error("unexpected message hash of Hello World")
+	}
+}
+
+func TestBTCVerifyMessageBIP322Vectors(t *testing.T) {
+	for _, vector := range []struct {
+		addr, message, signature string
+	}{
+		{testMessageAddress, "", "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="},
+		{testMessageAddress, "Hello World", "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="},
+		{"bc1ppv609nr0vr25u07u95waq5lucwfm6tde4nydujnu8npg4q75mr5sxq8lt3", "Hello World", "AUHd69PrJQEv+oKTfZ8l+WROBHuy9HKrbFCJu7U1iK2iiEy1vMU5EfMtjc+VSHM7aU0SDbak5IUZRVno2P5mjSafAQ=="},
+	} {
+		verified, err := BTCVerifyMessage(vector.addr, vector.message, vector.signature)
+		if err != nil || !verified {
+			t.Error("BIP322 vector verify fail:", vector.message, err)
+		}
+		verified, _ = BTCVerifyMessage(vector.addr, vector.message+"!", vector.signature)
+		if verified {
+			t.Error("BIP322 signature verified for another message")
+		}
+	}
+}
+
+func TestBTCSignMessage(t *testing.T) {
+	privKeyHex := testMessagePrivKeyHex(t)
+
+	foreignKeyBytes, _ := hex.DecodeString(testPrivKeyHex(3))
+	foreignScriptPubKeys, _ := BTCKeyScriptPubKeys(foreignKeyBytes)
+
+	privKeyBytes, _ := hex.DecodeString(privKeyHex)
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(privKeyBytes)
+	for i, scriptPubKey := range scriptPubKeys {
+		addr, _ := BTCAddressFromScriptPubKey(scriptPubKey)
+		formats := []string{"", MessageFormatFull}
+		if i != 3 {
+			formats = append(formats, MessageFormatBIP137)
+		}
+		for _, format := range formats {
+			signature, err := BTCSignMessage(privKeyHex, addr, "proof of reserves", format)
+			if err != nil {
+				t.Fatal(addr, format, err)
+			}
+			verified, err := BTCVerifyMessage(addr, "proof of reserves", signature)
+			if err != nil || !verified {
+				t.Error("verify fail:", addr, format, err)
+			}
+			foreignAddr, _ := BTCAddressFromScriptPubKey(foreignScriptPubKeys[i])
+			verified, _ = BTCVerifyMessage(foreignAddr, "proof of reserves", signature)
+			if verified {
+				t.Error("signature verified for another address:", addr, format)
+			}
+		}
+	}
+
+	_, err := BTCSignMessage(testPrivKeyHex(3), testMessageAddress, "Hello World", "")
+	if err == nil {
+		t.Error("message signed with a foreign key")
+	}
+	_, err = BTCSignMessage(privKeyHex, testMessageAddress, "Hello World", "unknown")
+	if err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestBTCVerifyMessageBIP322Malformed(t *testing.T) {
+	valid, _ := base64.StdEncoding.DecodeString("AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI=")
+	for _, malformed := range [][]byte{
+		{0x02, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0x02, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0x01, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		valid[:len(valid)/2],
+		append(append([]byte{}, valid...), 0x00),
+	} {
+		verified, _ := BTCVerifyMessage(testMessageAddress, "Hello World", base64.StdEncoding.EncodeToString(malformed))
+		if verified {
+			t.Error("malformed BIP322 signature accepted:", hex.EncodeToString(malformed))
+		}
+	}
+}
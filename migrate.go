@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-xorm/xorm"
+	"xorm.io/core"
+)
+
+// Migration upgrades the schema of the previous version to Version. New
+// schema changes are appended with the next version; applied migrations are
+// never changed. Migration 2 syncs the current table structs, so on a new
+// database it also creates the columns and indexes added by later
+// migrations, which therefore have to leave an up to date schema unchanged.
+type Migration struct {
+	Version int
+	Name    string
+	Apply   func() error
+}
+
+var Migrations = []Migration{
+	{Version: 1, Name: "remove duplicate rows", Apply: removeDuplicateRows},
+	{Version: 2, Name: "create and upgrade tables", Apply: syncTables},
+	{Version: 3, Name: "utxo amounts in satoshis", Apply: func() error {
+		_, err := GlobalDBMgr.TblUtxoMgr.MigrateAmounts()
+		return err
+	}},
+	{Version: 4, Name: "address metadata", Apply: func() error {
+		return GetDBEngine().Sync2(new(address))
+	}},
+	{Version: 5, Name: "utxo address index", Apply: func() error {
+		return GetDBEngine().Sync2(new(utxo))
+	}},
+	{Version: 6, Name: "transaction ledger", Apply: func() error {
+		err := GetDBEngine().Sync2(new(ledger))
+		if err != nil {
+			return err
+		}
+		return GlobalDBMgr.TblLedgerMgr.BackfillFromUtxos()
+	}},
+	{Version: 7, Name: "address script types", Apply: func() error {
+		return GlobalDBMgr.TblAddressMgr.BackfillScriptTypes()
+	}},
+	{Version: 8, Name: "unique transactions and sync rows", Apply: func() error {
+		err := removeDuplicateTxAndSyncRows()
+		if err != nil {
+			return err
+		}
+		return GetDBEngine().Sync2(new(tx), new(syncState), new(syncBlock))
+	}},
+	{Version: 9, Name: "unique xpub account names", Apply: func() error {
+		err := mergeDuplicateAccounts()
+		if err != nil {
+			return err
+		}
+		return GetDBEngine().Sync2(new(xpubAccount))
+	}},
+}
+
+// The migration lock serializes the migrations of signers sharing a
+// database; mysql names it and postgres identifies it by a key. mysql gives
+// up waiting after migrationLockTimeout seconds.
+const (
+	migrationLockName    = "xt_btc_signer_migrate"
+	migrationLockKey     = 0x78745f6d696772
+	migrationLockTimeout = 600
+)
+
+// lockMigrations takes the migration lock of a mysql or postgres database,
+// held by the returned session until unlockMigrations. A sqlite database is
+// written by a single signer and is not locked.
+func lockMigrations() (*xorm.Session, error) {
+	session := GetDBEngine().NewSession()
+	var err error
+	switch GetDBEngine().Dialect().DBType() {
+	case core.MYSQL:
+		// GET_LOCK is held by the connection of the transaction
+		err = session.Begin()
+		if err != nil {
+			break
+		}
+		var rows []map[string]string
+		rows, err = session.QueryString("select get_lock(?, ?) as locked", migrationLockName, migrationLockTimeout)
+		if err == nil && (len(rows) != 1 || rows[0]["locked"] != "1") {
+			err = errors.New("migration lock not acquired")
+		}
+	case core.POSTGRES:
+		err = session.Begin()
+		if err != nil {
+			break
+		}
+		_, err = session.Exec("select pg_advisory_xact_lock(?)", migrationLockKey)
+	}
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func unlockMigrations(session *xorm.Session) {
+	defer session.Close()
+	switch GetDBEngine().Dialect().DBType() {
+	case core.MYSQL:
+		_, _ = session.QueryString("select release_lock(?)", migrationLockName)
+		_ = session.Commit()
+	case core.POSTGRES:
+		// the advisory lock ends with the transaction
+		_ = session.Commit()
+	}
+}
+
+// Migrate applies the migrations above the recorded schema version in
+// order and returns the resulting version. Signers starting together on a
+// shared database wait for each other, so that each migration is applied
+// once.
+func Migrate() (int, error) {
+	session, err := lockMigrations()
+	if err != nil {
+		return 0, err
+	}
+	defer unlockMigrations(session)
+
+	err = GetDBEngine().Sync2(new(schemaVersion))
+	if err != nil {
+		return 0, err
+	}
+	version, err := GlobalDBMgr.TblSchemaVersionMgr.CurrentVersion()
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range Migrations {
+		if m.Version <= version {
+			continue
+		}
+		err = m.Apply()
+		if err != nil {
+			return version, fmt.Errorf("migration %d %s fail: %s", m.Version, m.Name, err.Error())
+		}
+		err = GlobalDBMgr.TblSchemaVersionMgr.AddVersion(m.Version, m.Name)
+		if err != nil {
+			return version, err
+		}
+		version = m.Version
+		Info.Printf("schema migrated to version %d: %s", m.Version, m.Name)
+	}
+	return version, nil
+}
+
+// removeDuplicateRows keeps the first row of addresses stored several times
+// so that the unique indexes can be created. Duplicate outputs cannot be
+// merged safely and fail the migration.
+func removeDuplicateRows() error {
+	exist, err := GetDBEngine().IsTableExist(new(address))
+	if err != nil {
+		return err
+	}
+	if exist {
+		_, err = GetDBEngine().Exec("delete from address where id not in (select id from (select min(id) as id from address group by address) as first_rows)")
+		if err != nil {
+			return err
+		}
+	}
+
+	exist, err = GetDBEngine().IsTableExist(new(utxo))
+	if err != nil || !exist {
+		return err
+	}
+	duplicates, err := GetDBEngine().QueryString("select txid, vout from utxo group by txid, vout having count(*) > 1")
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("%d outputs stored several times, first %s:%s", len(duplicates), duplicates[0]["txid"], duplicates[0]["vout"])
+	}
+	return nil
+}
+
+// removeDuplicateTxAndSyncRows keeps the first row of transactions and
+// sync rows that signers sharing a database stored several times, so that
+// their unique indexes can be created. The updates of those tables apply to
+// every row of a transaction or coin, so the rows kept are up to date.
+func removeDuplicateTxAndSyncRows() error {
+	for _, dml := range []string{
+		"delete from tx where id not in (select id from (select min(id) as id from tx group by txid) as first_rows)",
+		"delete from sync_state where id not in (select id from (select min(id) as id from sync_state group by coin_symbol) as first_rows)",
+		"delete from sync_block where id not in (select id from (select min(id) as id from sync_block group by coin_symbol, block_height) as first_rows)",
+	} {
+		_, err := GetDBEngine().Exec(dml)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeDuplicateAccounts keeps the first row of xpub accounts stored
+// several times with the same xpub, moved past the indexes any of the rows
+// handed out. Accounts stored with different xpubs under one name cannot be
+// merged and fail the migration.
+func mergeDuplicateAccounts() error {
+	duplicates, err := GetDBEngine().QueryString("select name from xpub_account group by name having count(*) > 1")
+	if err != nil {
+		return err
+	}
+	for _, duplicate := range duplicates {
+		accounts := make([]xpubAccount, 0)
+		err = GetDBEngine().Where("name=?", duplicate["name"]).Asc("id").Find(&accounts)
+		if err != nil {
+			return err
+		}
+		first := accounts[0]
+		for _, account := range accounts[1:] {
+			if account.Xpub != first.Xpub || account.Script_type != first.Script_type {
+				return fmt.Errorf("account %s stored with different xpubs", first.Name)
+			}
+			if account.Next_receive_index > first.Next_receive_index {
+				first.Next_receive_index = account.Next_receive_index
+			}
+			if account.Next_change_index > first.Next_change_index {
+				first.Next_change_index = account.Next_change_index
+			}
+		}
+		_, err = GetDBEngine().ID(first.Id).Cols("next_receive_index", "next_change_index").Update(&first)
+		if err != nil {
+			return err
+		}
+		_, err = GetDBEngine().Where("name=? and id<>?", first.Name, first.Id).Delete(new(xpubAccount))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncTables creates the missing tables, columns and indexes.
+func syncTables() error {
+	return GetDBEngine().Sync2(new(address), new(utxo), new(xpubAccount), new(syncState), new(syncBlock), new(tx))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"xorm.io/core"
+)
+
+// testInitLegacyDB creates the address and utxo tables of the first release
+// without running any migration.
+func testInitLegacyDB(t *testing.T) {
+	testOpenDB(t)
+	pk, datetime := "INTEGER primary key autoincrement", "DATETIME"
+	switch GetDBEngine().Dialect().DBType() {
+	case core.MYSQL:
+		pk = "INTEGER primary key auto_increment"
+	case core.POSTGRES:
+		pk, datetime = "SERIAL primary key", "TIMESTAMP"
+	}
+	for _, ddl := range []string{
+		"create table address (id " + pk + ", address VARCHAR(128) NOT NULL, extra INT NULL, created_at " + datetime + ", updated_at " + datetime + ")",
+		"create table utxo (id " + pk + ", txid VARCHAR(128) NOT NULL, vout INT NOT NULL, amount VARCHAR(128) NOT NULL, used INT NOT NULL, " +
+			"address VARCHAR(128) NOT NULL, scriptpubkey VARCHAR(128) NOT NULL, coin_symbol VARCHAR(128) NOT NULL, created_at " + datetime + ", updated_at " + datetime + ", pending INT NOT NULL)",
+		"insert into address (address) values ('addr1'), ('addr2'), ('addr1')",
+		"insert into utxo (txid, vout, amount, used, address, scriptpubkey, coin_symbol, pending) values ('a', 0, '0.25000000', 0, 'addr1', '', 'BTC', 0)",
+	} {
+		if _, err := GetDBEngine().Exec(ddl); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	testInitLegacyDB(t)
+
+	version, err := Migrate()
+	if err != nil || version != len(Migrations) {
+		t.Fatal("unexpected migration", version, err)
+	}
+	addrs, err := GlobalDBMgr.TblAddressMgr.ListAddresses()
+	if err != nil || len(addrs) != 2 {
+		t.Fatal("duplicate address kept", addrs, err)
+	}
+	u, _ := testTrackedUtxo(t, "a", 0)
+	if u.Amount != 25000000 || u.Address != "addr1" {
+		t.Fatal("unexpected migrated utxo", u)
+	}
+	err = GlobalDBMgr.TblUtxoMgr.ReserveUtxo("a", 0, "b", u.Created_at)
+	if err != nil {
+		t.Fatal("added columns missing", err)
+	}
+	txs, _, err := GlobalDBMgr.TblLedgerMgr.ListTransactions(LedgerQuery{Address: "addr1"})
+	if err != nil || len(txs) != 1 || txs[0].Txid != "a" || txs[0].Net != 25000000 {
+		t.Fatal("ledger not backfilled", txs, err)
+	}
+
+	if _, err = GetDBEngine().Exec("insert into address (address) values ('addr2')"); err == nil {
+		t.Error("duplicate address inserted")
+	}
+	if _, err = GetDBEngine().Exec("insert into utxo (txid, vout, amount, used, address, scriptpubkey, coin_symbol, pending) values ('a', 0, 1, 0, 'addr1', '', 'BTC', 0)"); err == nil {
+		t.Error("duplicate utxo inserted")
+	}
+
+	version, err = Migrate()
+	if err != nil || version != len(Migrations) {
+		t.Fatal("unexpected second migration", version, err)
+	}
+	current, err := GlobalDBMgr.TblSchemaVersionMgr.CurrentVersion()
+	if err != nil || current != version {
+		t.Fatal("unexpected schema version", current, err)
+	}
+}
+
+func TestMigrateDuplicateUtxos(t *testing.T) {
+	testInitLegacyDB(t)
+	_, err := GetDBEngine().Exec("insert into utxo (txid, vout, amount, used, address, scriptpubkey, coin_symbol, pending) values ('a', 0, '0.25000000', 0, 'addr1', '', 'BTC', 0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := Migrate()
+	if err == nil || version != 0 {
+		t.Fatal("duplicate utxos migrated", version, err)
+	}
+}
+
+func TestMigrateScriptTypes(t *testing.T) {
+	testInitLegacyDB(t)
+	_, err := GetDBEngine().Exec("insert into address (address) values ('1GJ23Q56cMqfVuGskN5gUKj2YYkmbtNVnL')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	addr, exist, err := GlobalDBMgr.TblAddressMgr.GetAddress("1GJ23Q56cMqfVuGskN5gUKj2YYkmbtNVnL")
+	if err != nil || !exist || addr.Script_type != "pubkeyhash" {
+		t.Fatal("script type not backfilled", addr, err)
+	}
+	if addr, _, _ = GlobalDBMgr.TblAddressMgr.GetAddress("addr1"); addr.Script_type != "" {
+		t.Fatal("script type of an invalid address set", addr)
+	}
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	testInitLegacyDB(t)
+	if GetDBEngine().Dialect().DBType() == core.SQLITE {
+		t.Skip("sqlite databases are migrated by a single signer")
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = Migrate()
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if u, _ := testTrackedUtxo(t, "a", 0); u.Amount != 25000000 {
+		t.Fatal("amount migrated twice", u.Amount)
+	}
+}
+
+func TestMigrateDuplicateTxs(t *testing.T) {
+	testInitDB(t)
+	// rows several signers stored before the unique indexes
+	for _, index := range []string{"UQE_tx_txid", "UQE_sync_state_coin_symbol", "UQE_sync_block_sync_block_height"} {
+		ddl := "drop index " + index
+		if GetDBEngine().Dialect().DBType() == core.MYSQL {
+			ddl = "alter table " + map[string]string{"UQE_tx_txid": "tx", "UQE_sync_state_coin_symbol": "sync_state",
+				"UQE_sync_block_sync_block_height": "sync_block"}[index] + " drop index " + index
+		}
+		if _, err := GetDBEngine().Exec(ddl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dml := range []string{
+		"insert into tx (txid, raw, status) values ('a', '00', 'broadcast'), ('a', '00', 'broadcast'), ('b', '00', 'signed')",
+		"insert into sync_state (coin_symbol, block_hash, block_height) values ('BTC', 'h1', 1), ('BTC', 'h1', 1)",
+		"insert into sync_block (coin_symbol, block_hash, block_height) values ('BTC', 'h1', 1), ('BTC', 'h1', 1)",
+		"delete from schema_version where version>=8",
+	} {
+		if _, err := GetDBEngine().Exec(dml); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	for table, expected := range map[string]int64{"tx": 2, "sync_state": 1, "sync_block": 1} {
+		count, err := GetDBEngine().Table(table).Count()
+		if err != nil || count != expected {
+			t.Error("duplicates of", table, "kept", count, err)
+		}
+	}
+	if _, err := GetDBEngine().Exec("insert into tx (txid, raw, status) values ('a', '00', 'signed')"); err == nil {
+		t.Error("duplicate transaction inserted")
+	}
+}
+
+func TestMigrateDuplicateAccounts(t *testing.T) {
+	testInitDB(t)
+	ddl := "drop index UQE_xpub_account_name"
+	if GetDBEngine().Dialect().DBType() == core.MYSQL {
+		ddl = "alter table xpub_account drop index UQE_xpub_account_name"
+	}
+	for _, dml := range []string{ddl,
+		"insert into xpub_account (name, xpub, script_type, next_receive_index, next_change_index) values " +
+			"('a', 'xpub1', 'wpkh', 3, 1), ('a', 'xpub1', 'wpkh', 5, 0), ('b', 'xpub2', 'wpkh', 0, 0)",
+		"delete from schema_version where version>=9",
+	} {
+		if _, err := GetDBEngine().Exec(dml); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	account, err := GlobalDBMgr.TblXpubAccountMgr.GetAccount("a")
+	if err != nil || account.Next_receive_index != 5 || account.Next_change_index != 1 {
+		t.Fatal("unexpected merged account", account, err)
+	}
+	if count, _ := GetDBEngine().Count(new(xpubAccount)); count != 2 {
+		t.Fatal("duplicate account kept", count)
+	}
+
+	// accounts of different xpubs are not merged
+	for _, dml := range []string{ddl,
+		"insert into xpub_account (name, xpub, script_type, next_receive_index, next_change_index) values ('b', 'xpub3', 'wpkh', 0, 0)",
+		"delete from schema_version where version>=9",
+	} {
+		if _, err = GetDBEngine().Exec(dml); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = Migrate(); err == nil {
+		t.Fatal("accounts of different xpubs merged")
+	}
+}
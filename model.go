@@ -2,43 +2,254 @@ package main
 
 import (
 	"errors"
-	"sync"
+	"fmt"
+	"github.com/go-xorm/xorm"
+	"strconv"
+	"strings"
 	"time"
+	"xorm.io/core"
 )
 
 type address struct {
-	Id         int       `xorm:"pk INTEGER autoincr"`
-	Address    string    `xorm:"VARCHAR(128) NOT NULL"`
-	Extra      int       `xorm:"INT NULL"`
-	Created_at time.Time `xorm:"created"`
-	Updated_at time.Time `xorm:"DATETIME"`
+	Id           int       `xorm:"pk INTEGER autoincr"`
+	Address      string    `xorm:"VARCHAR(128) NOT NULL unique"`
+	Extra        int       `xorm:"INT NULL"`
+	Descriptor   string    `xorm:"VARCHAR(1024) NULL"`
+	Derive_index int       `xorm:"INT NULL"`
+	Created_at   time.Time `xorm:"created"`
+	Updated_at   time.Time `xorm:"DATETIME"`
+	Label        string    `xorm:"VARCHAR(256) NULL"`
+	// account or customer the address was handed out to
+	Account string `xorm:"VARCHAR(128) NULL index"`
+	// one of the AddressPurpose values
+	Purpose string `xorm:"VARCHAR(16) NULL"`
+	// script type of the scriptPubKey as named by BTCScriptType
+	Script_type string `xorm:"VARCHAR(32) NULL"`
+	// path below the xpub of the account the address was derived from
+	Derive_path   string `xorm:"VARCHAR(256) NULL"`
+	Redeem_script string `xorm:"TEXT NULL"`
+	// 1 when the private key was generated by the signer
+	Has_key int `xorm:"INT NULL"`
+}
+
+const (
+	AddressPurposeDeposit  = "deposit"
+	AddressPurposeChange   = "change"
+	AddressPurposeCold     = "cold"
+	AddressPurposeMultisig = "multisig"
+)
+
+func IsAddressPurpose(purpose string) bool {
+	return purpose == AddressPurposeDeposit || purpose == AddressPurposeChange ||
+		purpose == AddressPurposeCold || purpose == AddressPurposeMultisig
 }
 
 type tblAddressMgr struct {
 	TableName string
-	Mutex     *sync.Mutex
 }
 
 func (t *tblAddressMgr) Init() {
 	t.TableName = "address"
-	t.Mutex = new(sync.Mutex)
 }
 
-func (t *tblAddressMgr) AddNewAddresses(addrs []string) error {
-	t.Mutex.Lock()
-	defer t.Mutex.Unlock()
+// AddressInsertBatchSize is the number of addresses written by one insert
+// statement, below the bind parameter limit of every supported database.
+const AddressInsertBatchSize = 1000
+
+// addressInsertAttempts bounds the retries of AddNewAddresses racing with
+// another process storing the same addresses.
+const addressInsertAttempts = 3
 
+var errAddressesStoredMeanwhile = errors.New("addresses stored by another process meanwhile")
+
+// AddNewAddresses stores the addresses not stored yet in one database
+// transaction, a batch at a time. Addresses given several times are stored
+// once. It returns the added addresses and the ones already stored, in the
+// order given. The transaction is retried when another process stores some
+// of the addresses meanwhile, so that they are reported as already stored.
+func (t *tblAddressMgr) AddNewAddresses(addrs []address) ([]string, []string, error) {
+	seen := make(map[string]bool)
+	unique := make([]address, 0, len(addrs))
 	for _, addr := range addrs {
-		var addressRes address
-		count, err := GetDBEngine().Where("address=?", addr).Count(addressRes)
+		if seen[addr.Address] {
+			continue
+		}
+		seen[addr.Address] = true
+		if addr.Script_type == "" {
+			if scriptPubKey, err := BTCScriptPubKeyFromAddress(addr.Address); err == nil {
+				addr.Script_type = BTCScriptType(scriptPubKey)
+			}
+		}
+		unique = append(unique, addr)
+	}
+
+	for attempt := 1; ; attempt++ {
+		added, existing, err := t.addNewAddresses(unique)
+		if err != errAddressesStoredMeanwhile || attempt == addressInsertAttempts {
+			return added, existing, err
+		}
+	}
+}
+
+func (t *tblAddressMgr) addNewAddresses(unique []address) ([]string, []string, error) {
+	added := make([]string, 0, len(unique))
+	existing := make([]string, 0)
+	session := GetDBEngine().NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	for begin := 0; begin < len(unique); begin += AddressInsertBatchSize {
+		end := begin + AddressInsertBatchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+		batch := unique[begin:end]
+		names := make([]string, 0, len(batch))
+		for _, addr := range batch {
+			names = append(names, addr.Address)
+		}
+		stored := make([]address, 0)
+		err = session.Cols("address").In("address", names).Find(&stored)
 		if err != nil {
-			return err
+			_ = session.Rollback()
+			return nil, nil, err
+		}
+		storedSet := make(map[string]bool)
+		for _, addr := range stored {
+			storedSet[addr.Address] = true
+		}
+		rows := make([]address, 0, len(batch))
+		for _, addr := range batch {
+			if storedSet[addr.Address] {
+				existing = append(existing, addr.Address)
+			} else {
+				added = append(added, addr.Address)
+				rows = append(rows, addr)
+			}
 		}
-		if count > 0 {
+		inserted, err := insertAddressesIgnore(session, rows)
+		if err != nil {
+			_ = session.Rollback()
+			return nil, nil, err
+		}
+		if inserted != int64(len(rows)) {
+			_ = session.Rollback()
+			return nil, nil, errAddressesStoredMeanwhile
+		}
+	}
+	err = session.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return added, existing, nil
+}
+
+// insertAddressesIgnore inserts addrs in one statement, skipping the ones
+// another process stored meanwhile, and returns the number of rows inserted.
+func insertAddressesIgnore(session *xorm.Session, addrs []address) (int64, error) {
+	if len(addrs) == 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	values := make([]string, 0, len(addrs))
+	args := make([]interface{}, 1, 12*len(addrs)+1)
+	for _, addr := range addrs {
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, addr.Address, addr.Descriptor, addr.Derive_index, addr.Label, addr.Account, addr.Purpose,
+			addr.Script_type, addr.Derive_path, addr.Redeem_script, addr.Has_key, now, now)
+	}
+	insert, conflict := insertIgnoreClauses("address")
+	args[0] = insert + " address (address, descriptor, derive_index, label, account, purpose, script_type, derive_path, redeem_script, has_key, created_at, updated_at) values " +
+		strings.Join(values, ", ") + conflict
+	res, err := session.Exec(args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// insertIgnoreClauses returns the insert statement start and end of the
+// database skipping rows that conflict on the unique key conflictCols.
+func insertIgnoreClauses(conflictCols string) (string, string) {
+	switch GetDBEngine().Dialect().DBType() {
+	case core.MYSQL:
+		return "insert ignore into", ""
+	case core.SQLITE:
+		return "insert or ignore into", ""
+	case core.POSTGRES:
+		return "insert into", " on conflict (" + conflictCols + ") do nothing"
+	}
+	return "insert into", ""
+}
+
+// FilterExistAddresses returns the subset of addrs stored in the address table.
+func (t *tblAddressMgr) FilterExistAddresses(addrs []string) (map[string]bool, error) {
+	exists := make(map[string]bool)
+	if len(addrs) == 0 {
+		return exists, nil
+	}
+	addresses := make([]address, 0)
+	err := GetDBEngine().Cols("address").In("address", addrs).Find(&addresses)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addresses {
+		exists[addr.Address] = true
+	}
+	return exists, nil
+}
+
+// ListAddresses returns all addresses of the address table.
+func (t *tblAddressMgr) ListAddresses() ([]string, error) {
+	addresses := make([]address, 0)
+	err := GetDBEngine().Cols("address").Asc("id").Find(&addresses)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		addrs = append(addrs, addr.Address)
+	}
+	return addrs, nil
+}
+
+// GetAddress returns a stored address with its metadata.
+func (t *tblAddressMgr) GetAddress(addr string) (address, bool, error) {
+	var a address
+	exist, err := GetDBEngine().Where("address=?", addr).Get(&a)
+	return a, exist, err
+}
+
+// SetMetadata updates the given label, account and purpose columns of a
+// stored address. It returns false when the address is not stored.
+func (t *tblAddressMgr) SetMetadata(addr string, cols map[string]interface{}) (bool, error) {
+	cols["updated_at"] = time.Now()
+	affected, err := GetDBEngine().Table(new(address)).Where("address=?", addr).Update(cols)
+	if err != nil {
+		return false, err
+	}
+	if affected > 0 {
+		return true, nil
+	}
+	return GetDBEngine().Where("address=?", addr).Exist(new(address))
+}
+
+// BackfillScriptTypes sets the script type of the stored addresses without
+// one from their scriptPubKey. Addresses not decoded are left untouched.
+func (t *tblAddressMgr) BackfillScriptTypes() error {
+	addresses := make([]address, 0)
+	err := GetDBEngine().Cols("id", "address").Where("script_type is null or script_type=''").Find(&addresses)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addresses {
+		scriptPubKey, err := BTCScriptPubKeyFromAddress(addr.Address)
+		if err != nil {
 			continue
 		}
-		addressRes.Address = addr
-		_, err = GetDBEngine().Cols("address").InsertOne(addressRes)
+		_, err = GetDBEngine().Table(new(address)).ID(addr.Id).Update(map[string]interface{}{"script_type": BTCScriptType(scriptPubKey)})
 		if err != nil {
 			return err
 		}
@@ -46,34 +257,85 @@ func (t *tblAddressMgr) AddNewAddresses(addrs []string) error {
 	return nil
 }
 
+// AddressFilter selects addresses by metadata; empty fields match all.
+type AddressFilter struct {
+	Label       string
+	Account     string
+	Purpose     string
+	Script_type string
+	// "1" or "0" to select addresses with or without a key held by the signer
+	Has_key string
+}
+
+// ListAddressesPage returns up to limit addresses matching filter with an
+// id above afterId, in id order, so that the last id is the cursor of the
+// next page.
+func (t *tblAddressMgr) ListAddressesPage(filter AddressFilter, afterId int, limit int) ([]address, error) {
+	session := GetDBEngine().Where("id>?", afterId)
+	for col, value := range map[string]string{
+		"label":       filter.Label,
+		"account":     filter.Account,
+		"purpose":     filter.Purpose,
+		"script_type": filter.Script_type,
+	} {
+		if value != "" {
+			session = session.And(col+"=?", value)
+		}
+	}
+	if filter.Has_key == "1" {
+		session = session.And("has_key=1")
+	} else if filter.Has_key == "0" {
+		session = session.And("(has_key is null or has_key=0)")
+	}
+	addresses := make([]address, 0)
+	err := session.Asc("id").Limit(limit).Find(&addresses)
+	return addresses, err
+}
+
 type utxo struct {
 	Id           int       `xorm:"pk INTEGER autoincr"`
-	Txid         string    `xorm:"VARCHAR(128) NOT NULL"`
-	Vout         int       `xorm:"INT NOT NULL"`
-	Amount       string    `xorm:"VARCHAR(128) NOT NULL"`
+	Txid         string    `xorm:"VARCHAR(128) NOT NULL unique(txid_vout)"`
+	Vout         int       `xorm:"INT NOT NULL unique(txid_vout)"`
+	Amount       Satoshi   `xorm:"BIGINT NOT NULL"`
 	Used         int       `xorm:"INT NOT NULL"`
-	Address      string    `xorm:"VARCHAR(128) NOT NULL"`
+	Address      string    `xorm:"VARCHAR(128) NOT NULL index"`
 	Scriptpubkey string    `xorm:"VARCHAR(128) NOT NULL"`
 	Coin_symbol  string    `xorm:"VARCHAR(128) NOT NULL"`
 	Created_at   time.Time `xorm:"created"`
 	Updated_at   time.Time `xorm:"DATETIME"`
 	Pending      int       `xorm:"INT NOT NULL"`
+	// block confirming the output
+	Block_hash   string `xorm:"VARCHAR(128) NULL"`
+	Block_height int64  `xorm:"BIGINT NULL"`
+	// transaction and block spending the output
+	Spent_txid         string `xorm:"VARCHAR(128) NULL"`
+	Spent_block_hash   string `xorm:"VARCHAR(128) NULL"`
+	Spent_block_height int64  `xorm:"BIGINT NULL"`
+	// 1 when created by a transaction spending our own outputs
+	Is_change int `xorm:"INT NULL"`
+	// transaction holding the pending reservation and when it may be released
+	Pending_txid      string    `xorm:"VARCHAR(128) NULL"`
+	Pending_expire_at time.Time `xorm:"DATETIME NULL"`
+}
+
+// Confirmations returns the depth of the output below tipHeight, 0 when it
+// is unconfirmed or its block is unknown.
+func (u utxo) Confirmations(tipHeight int64) int64 {
+	if u.Block_height <= 0 || u.Block_height > tipHeight {
+		return 0
+	}
+	return tipHeight - u.Block_height + 1
 }
 
 type tblUtxoMgr struct {
 	TableName string
-	Mutex     *sync.Mutex
 }
 
 func (t *tblUtxoMgr) Init() {
 	t.TableName = "utxo"
-	t.Mutex = new(sync.Mutex)
 }
 
 func (t *tblUtxoMgr) ListAddrUtxos(addr string) ([]utxo, error) {
-	t.Mutex.Lock()
-	defer t.Mutex.Unlock()
-
 	utxos := make([]utxo, 0)
 	err := GetDBEngine().Cols("*").Where("address=? and used=0 and pending=0", addr).Find(&utxos)
 	if err != nil {
@@ -82,22 +344,1123 @@ func (t *tblUtxoMgr) ListAddrUtxos(addr string) ([]utxo, error) {
 	return utxos, nil
 }
 
-func (t *tblUtxoMgr) UpdateUtxoPendingState(txId string, vout int, pending int) error {
-	t.Mutex.Lock()
-	defer t.Mutex.Unlock()
+// ListSpendableUtxos returns the unused, not pending outputs of addr deep
+// enough below tipHeight: change needs minChangeConf confirmations, other
+// outputs minDepositConf.
+func (t *tblUtxoMgr) ListSpendableUtxos(addr string, tipHeight int64, minDepositConf int64, minChangeConf int64) ([]utxo, error) {
+	utxos, _, err := t.QueryUtxos(UtxoQuery{Addresses: []string{addr}, MinDepositConf: minDepositConf,
+		MinChangeConf: minChangeConf, MaxConf: -1}, tipHeight)
+	return utxos, err
+}
+
+// UtxoQuery selects unspent outputs. Zero values do not filter, except
+// that pending outputs are only included with IncludePending.
+type UtxoQuery struct {
+	Addresses []string
+	// addresses of the account, exclusive with Addresses
+	Account   string
+	MinAmount Satoshi
+	MaxAmount Satoshi
+	// minimum confirmations of change and of other outputs
+	MinDepositConf int64
+	MinChangeConf  int64
+	// maximum confirmations, ignored when negative
+	MaxConf        int64
+	IncludePending bool
+	// order by amount instead of age, both with the id as tie breaker
+	SortByAmount bool
+	Desc         bool
+	// position after which the page starts, returned with the previous page
+	Cursor string
+	// page size, all outputs when 0
+	Limit int
+}
+
+// minConfCond returns the condition of outputs with at least minConf
+// confirmations below tipHeight; without a tip every output qualifies.
+func minConfCond(minConf int64, tipHeight int64) (string, []interface{}) {
+	if minConf <= 0 || tipHeight <= 0 {
+		return "1=1", nil
+	}
+	return "(block_height>0 and block_height<=?)", []interface{}{tipHeight - minConf + 1}
+}
+
+// QueryUtxos returns a page of the unspent outputs matching q and the
+// cursor of the next page, empty after the last page. The confirmation
+// filters are ignored when tipHeight is 0, the chain is not followed then.
+func (t *tblUtxoMgr) QueryUtxos(q UtxoQuery, tipHeight int64) ([]utxo, string, error) {
+	session := GetDBEngine().Where("used=0")
+	if !q.IncludePending {
+		session = session.And("pending=0")
+	}
+	if len(q.Addresses) > 0 {
+		session = session.In("address", q.Addresses)
+	}
+	if q.Account != "" {
+		session = session.And("address in (select address from address where account=?)", q.Account)
+	}
+	if q.MinAmount > 0 {
+		session = session.And("amount>=?", int64(q.MinAmount))
+	}
+	if q.MaxAmount > 0 {
+		session = session.And("amount<=?", int64(q.MaxAmount))
+	}
+	depositCond, depositArgs := minConfCond(q.MinDepositConf, tipHeight)
+	changeCond, changeArgs := minConfCond(q.MinChangeConf, tipHeight)
+	if q.MinDepositConf == q.MinChangeConf {
+		session = session.And(depositCond, depositArgs...)
+	} else {
+		session = session.And("((is_change=1 and "+changeCond+") or ((is_change is null or is_change=0) and "+depositCond+"))",
+			append(changeArgs, depositArgs...)...)
+	}
+	if q.MaxConf >= 0 && tipHeight > 0 {
+		session = session.And("(block_height is null or block_height<=0 or block_height>=?)", tipHeight-q.MaxConf+1)
+	}
+
+	cmp := ">"
+	if q.Desc {
+		cmp = "<"
+	}
+	if q.Cursor != "" {
+		if q.SortByAmount {
+			parts := strings.Split(q.Cursor, ":")
+			amount, err1 := strconv.ParseInt(parts[0], 10, 64)
+			id, err2 := strconv.Atoi(parts[len(parts)-1])
+			if len(parts) != 2 || err1 != nil || err2 != nil {
+				return nil, "", errors.New("invalid cursor")
+			}
+			session = session.And("(amount"+cmp+"? or (amount=? and id"+cmp+"?))", amount, amount, id)
+		} else {
+			id, err := strconv.Atoi(q.Cursor)
+			if err != nil {
+				return nil, "", errors.New("invalid cursor")
+			}
+			session = session.And("id"+cmp+"?", id)
+		}
+	}
+	orderCols := []string{"id"}
+	if q.SortByAmount {
+		orderCols = []string{"amount", "id"}
+	}
+	if q.Desc {
+		session = session.Desc(orderCols...)
+	} else {
+		session = session.Asc(orderCols...)
+	}
+	if q.Limit > 0 {
+		session = session.Limit(q.Limit)
+	}
+
+	utxos := make([]utxo, 0)
+	err := session.Find(&utxos)
+	if err != nil {
+		return nil, "", err
+	}
+	cursor := ""
+	if q.Limit > 0 && len(utxos) == q.Limit {
+		last := utxos[len(utxos)-1]
+		cursor = strconv.Itoa(last.Id)
+		if q.SortByAmount {
+			cursor = fmt.Sprintf("%d:%d", last.Amount, last.Id)
+		}
+	}
+	return utxos, cursor, nil
+}
+
+// OutPoint identifies an output by its transaction and index.
+type OutPoint struct {
+	Txid string
+	Vout int
+}
+
+// ReserveUtxo sets an unused output pending for the transaction pendingTxId
+// until expireAt.
+func (t *tblUtxoMgr) ReserveUtxo(txId string, vout int, pendingTxId string, expireAt time.Time) error {
+	return t.ReserveUtxos([]OutPoint{{Txid: txId, Vout: vout}}, pendingTxId, expireAt, "")
+}
+
+// ReserveUtxos sets the unused outputs pending for the transaction
+// pendingTxId until expireAt, all of them or none. Each output is reserved
+// by a conditional update, so an output pending for another transaction is
+// never taken, whichever process reserved it; only the reservations of
+// replacedTxId, the transaction pendingTxId replaces, are taken over.
+func (t *tblUtxoMgr) ReserveUtxos(outPoints []OutPoint, pendingTxId string, expireAt time.Time, replacedTxId string) error {
+	session := GetDBEngine().NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+	for _, o := range outPoints {
+		err = reserveUtxo(session, o, pendingTxId, expireAt, replacedTxId)
+		if err != nil {
+			_ = session.Rollback()
+			return err
+		}
+	}
+	return session.Commit()
+}
+
+func reserveUtxo(session *xorm.Session, o OutPoint, pendingTxId string, expireAt time.Time, replacedTxId string) error {
+	if replacedTxId == "" {
+		replacedTxId = pendingTxId
+	}
+	affected, err := session.Table(new(utxo)).
+		Where("txid=? and vout=? and used=0 and (pending=0 or pending_txid=? or pending_txid=?)", o.Txid, o.Vout, pendingTxId, replacedTxId).
+		Update(map[string]interface{}{
+			"pending":           1,
+			"pending_txid":      pendingTxId,
+			"pending_expire_at": expireAt,
+			"updated_at":        time.Now(),
+		})
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
 
+	// MySQL does not count rows left unchanged, so look at why nothing was updated
 	var u utxo
-	u.Txid = txId
-	u.Vout = vout
-	exist, err := GetDBEngine().Where("txid=?", txId).And("vout=?", vout).Get(&u)
+	exist, err := session.Where("txid=?", o.Txid).And("vout=?", o.Vout).Get(&u)
 	if err != nil {
 		return err
 	}
 	if !exist {
 		return errors.New("key not found!")
 	}
+	if u.Used == 1 {
+		return errors.New("utxo already spent")
+	}
+	if u.Pending == 1 && u.Pending_txid != pendingTxId {
+		return fmt.Errorf("utxo %s already reserved by %s", outPointKey(o.Txid, o.Vout), u.Pending_txid)
+	}
+	return nil
+}
+
+// ReleaseUtxo clears the reservation of an unused output. It returns false
+// when the output is not pending.
+func (t *tblUtxoMgr) ReleaseUtxo(txId string, vout int) (bool, error) {
+	affected, err := GetDBEngine().Table(new(utxo)).Where("txid=? and vout=? and used=0 and pending=1", txId, vout).
+		Update(map[string]interface{}{
+			"pending":           0,
+			"pending_txid":      nil,
+			"pending_expire_at": nil,
+			"updated_at":        time.Now(),
+		})
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// ReleaseByPendingTxid clears the reservations held by pendingTxId.
+func (t *tblUtxoMgr) ReleaseByPendingTxid(pendingTxId string) (int64, error) {
+	return GetDBEngine().Table(new(utxo)).Where("pending_txid=? and used=0 and pending=1", pendingTxId).
+		Update(map[string]interface{}{
+			"pending":           0,
+			"pending_txid":      nil,
+			"pending_expire_at": nil,
+			"updated_at":        time.Now(),
+		})
+}
+
+// GetUtxo returns a tracked output whether spent or not.
+func (t *tblUtxoMgr) GetUtxo(txId string, vout int) (utxo, bool, error) {
+	var u utxo
+	exist, err := GetDBEngine().Where("txid=?", txId).And("vout=?", vout).Get(&u)
+	return u, exist, err
+}
+
+// ListPendingUtxos returns the reserved, unused outputs of addr, or of all
+// addresses when addr is empty.
+func (t *tblUtxoMgr) ListPendingUtxos(addr string) ([]utxo, error) {
+	utxos := make([]utxo, 0)
+	session := GetDBEngine().Where("used=0 and pending=1")
+	if addr != "" {
+		session = session.And("address=?", addr)
+	}
+	err := session.Asc("id").Find(&utxos)
+	return utxos, err
+}
+
+// AddUtxo inserts a newly seen output and records its receive entry in the
+// ledger, in one database transaction; for an output already known only its
+// confirming block is updated. fee is the fee of the creating transaction, 0
+// when unknown.
+func (t *tblUtxoMgr) AddUtxo(u utxo, blockTime int64, fee int64) error {
+	err := t.addUtxo(u, blockTime, fee)
+	if err != nil {
+		// another process may have inserted it since the count
+		if count, _ := GetDBEngine().Where("txid=?", u.Txid).And("vout=?", u.Vout).Count(new(utxo)); count > 0 {
+			return t.addUtxo(u, blockTime, fee)
+		}
+	}
+	return err
+}
+
+func (t *tblUtxoMgr) addUtxo(u utxo, blockTime int64, fee int64) error {
+	session := GetDBEngine().NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+	count, err := session.Where("txid=?", u.Txid).And("vout=?", u.Vout).Count(new(utxo))
+	if err != nil {
+		_ = session.Rollback()
+		return err
+	}
+	if count > 0 {
+		// refresh the confirming block of an output imported before it was scanned
+		var utxoRes utxo
+		utxoRes.Block_hash = u.Block_hash
+		utxoRes.Block_height = u.Block_height
+		utxoRes.Updated_at = time.Now()
+		_, err = session.Where("txid=?", u.Txid).And("vout=?", u.Vout).
+			Cols("block_hash", "block_height", "updated_at").Update(&utxoRes)
+	} else {
+		u.Used = 0
+		u.Pending = 0
+		u.Updated_at = time.Now()
+		_, err = session.InsertOne(u)
+	}
+	if err != nil {
+		_ = session.Rollback()
+		return err
+	}
+	err = addLedgerEntry(session, ledger{Txid: u.Txid, Address: u.Address, Entry_type: LedgerEntryReceive,
+		Utxo_txid: u.Txid, Utxo_vout: u.Vout, Amount: int64(u.Amount), Fee: fee, Coin_symbol: u.Coin_symbol,
+		Block_hash: u.Block_hash, Block_height: u.Block_height, Block_time: blockTime})
+	if err != nil {
+		_ = session.Rollback()
+		return err
+	}
+	return session.Commit()
+}
+
+// ListUnspentByTxids returns the unspent tracked outputs created by txIds.
+func (t *tblUtxoMgr) ListUnspentByTxids(txIds []string) ([]utxo, error) {
+	utxos := make([]utxo, 0)
+	if len(txIds) == 0 {
+		return utxos, nil
+	}
+	err := GetDBEngine().Where("used=0").In("txid", txIds).Find(&utxos)
+	return utxos, err
+}
+
+// MarkUtxoSpent flags an output as used by spentTxId in the given block,
+// clears its pending state and records its spend entry in the ledger, in one
+// database transaction. fee is the fee of spentTxId, 0 when unknown. It
+// returns false when the output is not tracked.
+func (t *tblUtxoMgr) MarkUtxoSpent(txId string, vout int, spentTxId string, blockHash string, blockHeight int64,
+	blockTime int64, fee int64) (bool, error) {
+	session := GetDBEngine().NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return false, err
+	}
+	var spent utxo
+	exist, err := session.Where("txid=?", txId).And("vout=?", vout).Get(&spent)
+	if err != nil || !exist {
+		_ = session.Rollback()
+		return false, err
+	}
+	var u utxo
+	u.Used = 1
+	u.Pending = 0
+	u.Spent_txid = spentTxId
+	u.Spent_block_hash = blockHash
+	u.Spent_block_height = blockHeight
+	u.Updated_at = time.Now()
+	_, err = session.Where("txid=?", txId).And("vout=?", vout).
+		Cols("used", "pending", "spent_txid", "spent_block_hash", "spent_block_height", "updated_at").Update(&u)
+	if err != nil {
+		_ = session.Rollback()
+		return false, err
+	}
+	err = addLedgerEntry(session, ledger{Txid: spentTxId, Address: spent.Address, Entry_type: LedgerEntrySpend,
+		Utxo_txid: txId, Utxo_vout: vout, Amount: -int64(spent.Amount), Fee: fee, Coin_symbol: spent.Coin_symbol,
+		Block_hash: blockHash, Block_height: blockHeight, Block_time: blockTime})
+	if err != nil {
+		_ = session.Rollback()
+		return false, err
+	}
+	return true, session.Commit()
+}
+
+// UtxoBalance sums the unspent outputs. Confirmed and Unconfirmed exclude
+// the outputs reserved for a pending transaction, counted in Pending.
+type UtxoBalance struct {
+	Utxos       int64
+	Confirmed   Satoshi
+	Unconfirmed Satoshi
+	Pending     Satoshi
+	Total       Satoshi
+}
+
+// GetBalance returns the balance of addr, of the addresses of account, or
+// of all addresses when both are empty, summed by the database.
+func (t *tblUtxoMgr) GetBalance(addr string, account string) (UtxoBalance, error) {
+	var balance UtxoBalance
+	sql := "select count(*) as utxos, coalesce(sum(amount), 0) as total, " +
+		"coalesce(sum(case when pending=1 then amount else 0 end), 0) as pending, " +
+		"coalesce(sum(case when pending=0 and block_height>0 then amount else 0 end), 0) as confirmed, " +
+		"coalesce(sum(case when pending=0 and (block_height is null or block_height<=0) then amount else 0 end), 0) as unconfirmed " +
+		"from utxo where used=0"
+	args := []interface{}{}
+	if addr != "" {
+		sql += " and address=?"
+		args = append(args, addr)
+	}
+	if account != "" {
+		sql += " and address in (select address from address where account=?)"
+		args = append(args, account)
+	}
+	rows, err := GetDBEngine().QueryString(append([]interface{}{sql}, args...)...)
+	if err != nil {
+		return balance, err
+	}
+	if len(rows) != 1 {
+		return balance, errors.New("unexpected balance query result")
+	}
+	for col, sum := range map[string]*int64{
+		"utxos":       &balance.Utxos,
+		"total":       (*int64)(&balance.Total),
+		"pending":     (*int64)(&balance.Pending),
+		"confirmed":   (*int64)(&balance.Confirmed),
+		"unconfirmed": (*int64)(&balance.Unconfirmed),
+	} {
+		*sum, err = strconv.ParseInt(rows[0][col], 10, 64)
+		if err != nil {
+			return balance, fmt.Errorf("invalid %s sum %s", col, rows[0][col])
+		}
+	}
+	return balance, nil
+}
+
+// RollbackAbove forgets the outputs created and un-spends the outputs spent
+// in blocks above height, after those blocks were orphaned. The un-spent
+// outputs stay reserved for their spending transaction until expireAt, as it
+// usually returns to the mempool; the reaper releases them if it does not.
+func (t *tblUtxoMgr) RollbackAbove(height int64, expireAt time.Time) (int64, int64, error) {
+	session := GetDBEngine().NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	removed, err := session.Where("block_height>?", height).Delete(new(utxo))
+	if err != nil {
+		_ = session.Rollback()
+		return 0, 0, err
+	}
+	// reserved for the spending transaction before its spend is cleared
+	var reserved utxo
+	reserved.Pending = 1
+	reserved.Pending_expire_at = expireAt
+	reserved.Updated_at = time.Now()
+	_, err = session.Where("used=1 and spent_block_height>?", height).SetExpr("pending_txid", "spent_txid").
+		Cols("pending", "pending_expire_at", "updated_at").Update(&reserved)
+	if err != nil {
+		_ = session.Rollback()
+		return 0, 0, err
+	}
+	var u utxo
+	u.Updated_at = time.Now()
+	restored, err := session.Where("used=1 and spent_block_height>?", height).
+		Cols("used", "spent_txid", "spent_block_hash", "spent_block_height", "updated_at").Update(&u)
+	if err != nil {
+		_ = session.Rollback()
+		return 0, 0, err
+	}
+	return removed, restored, session.Commit()
+}
+
+// MigrateAmounts converts the amounts earlier versions stored as decimal BTC
+// strings in a text amount column to satoshis in a new BIGINT column, which
+// replaces the text column as the last step. The text amounts are never
+// changed, so a run interrupted at any step is completed by the next one.
+// Every amount of a text column is in BTC, with or without a point. It
+// returns the number of converted outputs.
+func (t *tblUtxoMgr) MigrateAmounts() (int64, error) {
+	tables, err := GetDBEngine().DBMetas()
+	if err != nil {
+		return 0, err
+	}
+	var column, converted *core.Column
+	for _, table := range tables {
+		if table.Name == t.TableName {
+			column = table.GetColumn("amount")
+			converted = table.GetColumn("amount_satoshi")
+		}
+	}
+	if column == nil && converted != nil {
+		// interrupted after the text column was dropped
+		return 0, t.swapAmountColumn(false)
+	}
+	if column == nil {
+		return 0, nil
+	}
+	// the sqlite3 dialect reports the type with its length
+	sqlType := core.SQLType{Name: strings.ToUpper(strings.SplitN(column.SQLType.Name, "(", 2)[0])}
+	if !sqlType.IsText() {
+		return 0, nil
+	}
+
+	if converted == nil {
+		_, err = GetDBEngine().Exec("alter table utxo add amount_satoshi BIGINT NOT NULL DEFAULT 0")
+		if err != nil {
+			return 0, err
+		}
+	}
+	rows, err := GetDBEngine().QueryString("select id, amount from utxo")
+	if err != nil {
+		return 0, err
+	}
+	session := GetDBEngine().NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range rows {
+		satoshi, err := ParseBTCAmount(row["amount"])
+		if err != nil {
+			_ = session.Rollback()
+			return 0, fmt.Errorf("utxo %s: %s", row["id"], err.Error())
+		}
+		_, err = session.Exec("update utxo set amount_satoshi=? where id=?", satoshi, row["id"])
+		if err != nil {
+			_ = session.Rollback()
+			return 0, err
+		}
+	}
+	err = session.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(rows)), t.swapAmountColumn(true)
+}
+
+// swapAmountColumn replaces the text amount column by amount_satoshi, in one
+// statement or database transaction where the database allows it.
+func (t *tblUtxoMgr) swapAmountColumn(dropText bool) error {
+	var ddls []string
+	switch GetDBEngine().Dialect().DBType() {
+	case core.MYSQL:
+		// DDL is not transactional, one statement is atomic
+		ddl := "alter table utxo change amount_satoshi amount BIGINT NOT NULL"
+		if dropText {
+			ddl = "alter table utxo drop column amount, change amount_satoshi amount BIGINT NOT NULL"
+		}
+		ddls = []string{ddl}
+	default:
+		if dropText {
+			ddls = append(ddls, "alter table utxo drop column amount")
+		}
+		ddls = append(ddls, "alter table utxo rename column amount_satoshi to amount")
+	}
+	session := GetDBEngine().NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+	for _, ddl := range ddls {
+		_, err = session.Exec(ddl)
+		if err != nil {
+			_ = session.Rollback()
+			return err
+		}
+	}
+	return session.Commit()
+}
+
+type xpubAccount struct {
+	Id                 int       `xorm:"pk INTEGER autoincr"`
+	Name               string    `xorm:"VARCHAR(128) NOT NULL unique"`
+	Xpub               string    `xorm:"VARCHAR(256) NOT NULL"`
+	Script_type        string    `xorm:"VARCHAR(16) NOT NULL"`
+	Next_receive_index int       `xorm:"INT NOT NULL"`
+	Next_change_index  int       `xorm:"INT NOT NULL"`
+	Created_at         time.Time `xorm:"created"`
+	Updated_at         time.Time `xorm:"DATETIME"`
+}
+
+type tblXpubAccountMgr struct {
+	TableName string
+}
+
+func (t *tblXpubAccountMgr) Init() {
+	t.TableName = "xpub_account"
+}
+
+func (t *tblXpubAccountMgr) AddAccount(name string, xpub string, scriptType string) error {
+	now := time.Now()
+	insert, conflict := insertIgnoreClauses("name")
+	res, err := GetDBEngine().Exec(insert+" xpub_account (name, xpub, script_type, next_receive_index, next_change_index, created_at, updated_at) values (?, ?, ?, 0, 0, ?, ?)"+conflict,
+		name, xpub, scriptType, now, now)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return errors.New("account already exists")
+	}
+	return nil
+}
+
+func (t *tblXpubAccountMgr) GetAccount(name string) (xpubAccount, error) {
+	var account xpubAccount
+	exist, err := GetDBEngine().Where("name=?", name).Get(&account)
+	if err != nil {
+		return account, err
+	}
+	if !exist {
+		return account, errors.New("account not found")
+	}
+	return account, nil
+}
+
+// ReserveIndexes hands out count unused indexes of the receive or change
+// chain and returns the first one. The index is moved by the database, so
+// signers sharing it never hand out the same index.
+func (t *tblXpubAccountMgr) ReserveIndexes(name string, change bool, count int) (int, error) {
+	col := "next_receive_index"
+	if change {
+		col = "next_change_index"
+	}
+	session := GetDBEngine().NewSession()
+	defer session.Close()
+	err := session.Begin()
+	if err != nil {
+		return 0, err
+	}
+	affected, err := session.Table(new(xpubAccount)).Where("name=?", name).Incr(col, count).
+		Update(map[string]interface{}{"updated_at": time.Now()})
+	if err != nil {
+		_ = session.Rollback()
+		return 0, err
+	}
+	if affected == 0 {
+		_ = session.Rollback()
+		return 0, errors.New("account not found")
+	}
+	// the row stays locked by the update until the commit
+	var account xpubAccount
+	_, err = session.Where("name=?", name).Get(&account)
+	if err != nil {
+		_ = session.Rollback()
+		return 0, err
+	}
+	next := account.Next_receive_index
+	if change {
+		next = account.Next_change_index
+	}
+	return next - count, session.Commit()
+}
+
+// MarkIndexUsed moves the next unused index of a chain past index and
+// returns the resulting next unused index. The index only moves forward,
+// whichever signer moved it last.
+func (t *tblXpubAccountMgr) MarkIndexUsed(name string, change bool, index int) (int, error) {
+	col := "next_receive_index"
+	if change {
+		col = "next_change_index"
+	}
+	_, err := GetDBEngine().Table(new(xpubAccount)).Where("name=? and "+col+"<=?", name, index).
+		Update(map[string]interface{}{col: index + 1, "updated_at": time.Now()})
+	if err != nil {
+		return 0, err
+	}
+	account, err := t.GetAccount(name)
+	if err != nil {
+		return 0, err
+	}
+	if change {
+		return account.Next_change_index, nil
+	}
+	return account.Next_receive_index, nil
+}
+
+// syncBlock records a processed block so that the stored chain can be
+// compared with the node's after a reorganization.
+type syncBlock struct {
+	Id            int       `xorm:"pk INTEGER autoincr"`
+	Coin_symbol   string    `xorm:"VARCHAR(128) NOT NULL unique(sync_block_height)"`
+	Block_hash    string    `xorm:"VARCHAR(128) NOT NULL"`
+	Block_height  int64     `xorm:"BIGINT NOT NULL unique(sync_block_height)"`
+	Previous_hash string    `xorm:"VARCHAR(128) NULL"`
+	Created_at    time.Time `xorm:"created"`
+}
+
+type tblSyncBlockMgr struct {
+	TableName string
+}
+
+func (t *tblSyncBlockMgr) Init() {
+	t.TableName = "sync_block"
+}
+
+func (t *tblSyncBlockMgr) AddBlock(coinSymbol string, blockHash string, blockHeight int64, previousHash string) error {
+	_, err := GetDBEngine().Where("coin_symbol=? and block_height>=?", coinSymbol, blockHeight).Delete(new(syncBlock))
+	if err != nil {
+		return err
+	}
+	_, err = GetDBEngine().InsertOne(syncBlock{Coin_symbol: coinSymbol, Block_hash: blockHash,
+		Block_height: blockHeight, Previous_hash: previousHash})
+	return err
+}
+
+// GetBlockHash returns the stored hash at blockHeight, or "" when unknown.
+func (t *tblSyncBlockMgr) GetBlockHash(coinSymbol string, blockHeight int64) (string, error) {
+	var block syncBlock
+	exist, err := GetDBEngine().Where("coin_symbol=? and block_height=?", coinSymbol, blockHeight).Get(&block)
+	if err != nil || !exist {
+		return "", err
+	}
+	return block.Block_hash, nil
+}
+
+func (t *tblSyncBlockMgr) DeleteAbove(coinSymbol string, blockHeight int64) error {
+	_, err := GetDBEngine().Where("coin_symbol=? and block_height>?", coinSymbol, blockHeight).Delete(new(syncBlock))
+	return err
+}
+
+type syncState struct {
+	Id           int       `xorm:"pk INTEGER autoincr"`
+	Coin_symbol  string    `xorm:"VARCHAR(128) NOT NULL unique"`
+	Block_hash   string    `xorm:"VARCHAR(128) NOT NULL"`
+	Block_height int64     `xorm:"BIGINT NOT NULL"`
+	Created_at   time.Time `xorm:"created"`
+	Updated_at   time.Time `xorm:"DATETIME"`
+}
+
+type tblSyncStateMgr struct {
+	TableName string
+}
+
+func (t *tblSyncStateMgr) Init() {
+	t.TableName = "sync_state"
+}
+
+// GetTipHeight returns the height of the last processed block of
+// coinSymbol, 0 when nothing has been processed yet.
+func (t *tblSyncStateMgr) GetTipHeight(coinSymbol string) (int64, error) {
+	state, exist, err := t.GetLastBlock(coinSymbol)
+	if err != nil || !exist {
+		return 0, err
+	}
+	return state.Block_height, nil
+}
+
+// GetLastBlock returns the last processed block of coinSymbol; the bool is
+// false when nothing has been processed yet.
+func (t *tblSyncStateMgr) GetLastBlock(coinSymbol string) (syncState, bool, error) {
+	var state syncState
+	exist, err := GetDBEngine().Where("coin_symbol=?", coinSymbol).Get(&state)
+	return state, exist, err
+}
+
+// SetLastBlock records the last processed block of coinSymbol, in the
+// single state row of the coin.
+func (t *tblSyncStateMgr) SetLastBlock(coinSymbol string, blockHash string, blockHeight int64) error {
+	var state syncState
+	state.Block_hash = blockHash
+	state.Block_height = blockHeight
+	state.Updated_at = time.Now()
+	affected, err := GetDBEngine().Where("coin_symbol=?", coinSymbol).Cols("block_hash", "block_height", "updated_at").Update(&state)
+	if err != nil || affected > 0 {
+		return err
+	}
+	insert, conflict := insertIgnoreClauses("coin_symbol")
+	res, err := GetDBEngine().Exec(insert+" sync_state (coin_symbol, block_hash, block_height, created_at, updated_at) values (?, ?, ?, ?, ?)"+conflict,
+		coinSymbol, blockHash, blockHeight, state.Updated_at, state.Updated_at)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil || inserted > 0 {
+		return err
+	}
+	// inserted by another process since the update
+	_, err = GetDBEngine().Where("coin_symbol=?", coinSymbol).Cols("block_hash", "block_height", "updated_at").Update(&state)
+	return err
+}
+
+const (
+	// signed by us, not yet handed to the node
+	TxStatusSigned    = "signed"
+	TxStatusBroadcast = "broadcast"
+	TxStatusInMempool = "in-mempool"
+	TxStatusConfirmed = "confirmed"
+	// an input was spent by another transaction
+	TxStatusReplaced = "replaced"
+	// rejected by the node or dropped from its mempool
+	TxStatusFailed = "failed"
+)
+
+// statuses of transactions the node does not hold, the only ones a
+// broadcast attempt may move
+var txStatusesNotSent = []string{TxStatusSigned, TxStatusReplaced, TxStatusFailed}
+
+type tx struct {
+	Id           int    `xorm:"pk INTEGER autoincr"`
+	Txid         string `xorm:"VARCHAR(128) NOT NULL unique"`
+	Raw          string `xorm:"TEXT NOT NULL"`
+	Status       string `xorm:"VARCHAR(16) NOT NULL"`
+	Block_hash   string `xorm:"VARCHAR(128) NULL"`
+	Block_height int64  `xorm:"BIGINT NULL"`
+	Replaced_by  string `xorm:"VARCHAR(128) NULL"`
+	// transaction this one was built to replace
+	Replaces   string    `xorm:"VARCHAR(128) NULL"`
+	Error      string    `xorm:"TEXT NULL"`
+	Created_at time.Time `xorm:"created"`
+	Updated_at time.Time `xorm:"DATETIME"`
+}
+
+type tblTxMgr struct {
+	TableName string
+}
+
+func (t *tblTxMgr) Init() {
+	t.TableName = "tx"
+}
+
+// SaveSigned records a signed transaction. A transaction already known,
+// possibly recorded meanwhile by another signer, is only reset to signed
+// when it failed before.
+func (t *tblTxMgr) SaveSigned(txId string, raw string) error {
+	now := time.Now()
+	insert, conflict := insertIgnoreClauses("txid")
+	res, err := GetDBEngine().Exec(insert+" tx (txid, raw, status, error, created_at, updated_at) values (?, ?, ?, ?, ?, ?)"+conflict,
+		txId, raw, TxStatusSigned, "", now, now)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil || inserted > 0 {
+		return err
+	}
+	var record tx
+	record.Raw = raw
+	record.Status = TxStatusSigned
+	record.Error = ""
+	record.Updated_at = now
+	_, err = GetDBEngine().Where("txid=? and status=?", txId, TxStatusFailed).
+		Cols("raw", "status", "error", "updated_at").Update(&record)
+	return err
+}
+
+// SetReplaces records that txId was built to replace replacedTxId.
+func (t *tblTxMgr) SetReplaces(txId string, replacedTxId string) error {
+	var record tx
+	record.Replaces = replacedTxId
+	record.Updated_at = time.Now()
+	_, err := GetDBEngine().Where("txid=?", txId).Cols("replaces", "updated_at").Update(&record)
+	return err
+}
+
+func (t *tblTxMgr) GetTx(txId string) (tx, bool, error) {
+	var record tx
+	exist, err := GetDBEngine().Where("txid=?", txId).Get(&record)
+	return record, exist, err
+}
+
+func (t *tblTxMgr) ListTxsByStatus(statuses ...string) ([]tx, error) {
+	records := make([]tx, 0)
+	err := GetDBEngine().In("status", statuses).Asc("id").Find(&records)
+	return records, err
+}
+
+// SetStatus moves a transaction that is in one of the statuses from to
+// status, recording errMsg for failures, and reports whether it moved.
+func (t *tblTxMgr) SetStatus(txId string, status string, errMsg string, from ...string) (bool, error) {
+	var record tx
+	record.Status = status
+	record.Error = errMsg
+	record.Updated_at = time.Now()
+	affected, err := GetDBEngine().Where("txid=?", txId).In("status", from).
+		Cols("status", "error", "updated_at").Update(&record)
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		// MySQL does not count rows updated to their current values
+		current, exist, err := t.GetTx(txId)
+		return exist && current.Status == status && current.Error == errMsg, err
+	}
+	return true, nil
+}
+
+func (t *tblTxMgr) SetReplaced(txId string, replacedBy string) error {
+	var record tx
+	record.Status = TxStatusReplaced
+	record.Replaced_by = replacedBy
+	record.Updated_at = time.Now()
+	_, err := GetDBEngine().Where("txid=?", txId).Cols("status", "replaced_by", "updated_at").Update(&record)
+	return err
+}
+
+// MarkConfirmed sets the known transactions among txIds confirmed in the
+// given block.
+func (t *tblTxMgr) MarkConfirmed(txIds []string, blockHash string, blockHeight int64) (int64, error) {
+	if len(txIds) == 0 {
+		return 0, nil
+	}
+	var record tx
+	record.Status = TxStatusConfirmed
+	record.Block_hash = blockHash
+	record.Block_height = blockHeight
+	record.Error = ""
+	record.Updated_at = time.Now()
+	return GetDBEngine().In("txid", txIds).
+		Cols("status", "block_hash", "block_height", "error", "updated_at").Update(&record)
+}
+
+// RollbackAbove returns the transactions confirmed in blocks above height
+// to the broadcast state, after those blocks were orphaned.
+func (t *tblTxMgr) RollbackAbove(height int64) (int64, error) {
+	var record tx
+	record.Status = TxStatusBroadcast
+	record.Updated_at = time.Now()
+	return GetDBEngine().Where("status=? and block_height>?", TxStatusConfirmed, height).
+		Cols("status", "block_hash", "block_height", "updated_at").Update(&record)
+}
+
+const (
+	LedgerEntryReceive = "receive"
+	LedgerEntrySpend   = "spend"
+)
+
+// ledger records an output of our addresses received or spent by Txid.
+// Amount is positive for received outputs and negative for spent ones.
+type ledger struct {
+	Id         int    `xorm:"pk INTEGER autoincr"`
+	Txid       string `xorm:"VARCHAR(128) NOT NULL index"`
+	Address    string `xorm:"VARCHAR(128) NOT NULL index"`
+	Entry_type string `xorm:"VARCHAR(16) NOT NULL unique(ledger_utxo)"`
+	Utxo_txid  string `xorm:"VARCHAR(128) NOT NULL unique(ledger_utxo)"`
+	Utxo_vout  int    `xorm:"INT NOT NULL unique(ledger_utxo)"`
+	Amount     int64  `xorm:"BIGINT NOT NULL"`
+	// fee of Txid, 0 unless all of its inputs were ours
+	Fee          int64     `xorm:"BIGINT NULL"`
+	Coin_symbol  string    `xorm:"VARCHAR(128) NOT NULL"`
+	Block_hash   string    `xorm:"VARCHAR(128) NULL"`
+	Block_height int64     `xorm:"BIGINT NULL"`
+	Block_time   int64     `xorm:"BIGINT NULL index"`
+	Created_at   time.Time `xorm:"created"`
+}
+
+type tblLedgerMgr struct {
+	TableName string
+}
+
+func (t *tblLedgerMgr) Init() {
+	t.TableName = "ledger"
+}
+
+// AddEntry records e; for an entry already recorded only its block and fee
+// are updated.
+func (t *tblLedgerMgr) AddEntry(e ledger) error {
+	session := GetDBEngine().NewSession()
+	defer session.Close()
+	return addLedgerEntry(session, e)
+}
+
+func addLedgerEntry(session *xorm.Session, e ledger) error {
+	exist, err := session.Where("utxo_txid=? and utxo_vout=? and entry_type=?", e.Utxo_txid, e.Utxo_vout, e.Entry_type).
+		Exist(new(ledger))
+	if err != nil {
+		return err
+	}
+	if exist {
+		_, err = session.Where("utxo_txid=? and utxo_vout=? and entry_type=?", e.Utxo_txid, e.Utxo_vout, e.Entry_type).
+			Cols("fee", "block_hash", "block_height", "block_time").Update(&e)
+		return err
+	}
+	_, err = session.InsertOne(e)
+	return err
+}
+
+// DeleteAbove forgets the entries of blocks above height, after those blocks
+// were orphaned.
+func (t *tblLedgerMgr) DeleteAbove(height int64) (int64, error) {
+	return GetDBEngine().Where("block_height>?", height).Delete(new(ledger))
+}
+
+// LedgerQuery selects the transactions of an address or of the addresses of
+// an account, newest first.
+type LedgerQuery struct {
+	Address string
+	Account string
+	// block times in unix seconds, Until excluded; 0 does not filter
+	Since int64
+	Until int64
+	// position after which the page starts, returned with the previous page
+	Cursor string
+	Limit  int
+}
+
+// LedgerTx sums the entries of a transaction within the queried addresses.
+type LedgerTx struct {
+	Txid         string
+	Received     Satoshi
+	Sent         Satoshi
+	Net          Satoshi
+	Fee          Satoshi
+	Block_hash   string
+	Block_height int64
+	Block_time   int64
+	Entries      []ledger
+}
+
+// ListTransactions returns a page of the transactions matching q, summed
+// by the database, and the cursor of the next page, empty after the last
+// page.
+func (t *tblLedgerMgr) ListTransactions(q LedgerQuery) ([]LedgerTx, string, error) {
+	where := "address=?"
+	scope := q.Address
+	if q.Account != "" {
+		where = "address in (select address from address where account=?)"
+		scope = q.Account
+	}
+	args := []interface{}{scope}
+	if q.Since > 0 {
+		where += " and block_time>=?"
+		args = append(args, q.Since)
+	}
+	if q.Until > 0 {
+		where += " and block_time<?"
+		args = append(args, q.Until)
+	}
+	whereArgs := args
+	sql := "select txid, min(id) as first_id, sum(amount) as net, " +
+		"sum(case when amount>0 then amount else 0 end) as received, " +
+		"sum(case when amount<0 then 0-amount else 0 end) as sent, " +
+		"max(fee) as fee, max(block_hash) as block_hash, max(block_height) as block_height, max(block_time) as block_time " +
+		"from ledger where " + where + " group by txid"
+	if q.Cursor != "" {
+		firstId, err := strconv.Atoi(q.Cursor)
+		if err != nil {
+			return nil, "", errors.New("invalid cursor")
+		}
+		sql += " having min(id)<?"
+		args = append(append([]interface{}{}, whereArgs...), firstId)
+	}
+	sql += " order by first_id desc"
+	if q.Limit > 0 {
+		sql += fmt.Sprintf(" limit %d", q.Limit)
+	}
+	rows, err := GetDBEngine().QueryString(append([]interface{}{sql}, args...)...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	txs := make([]LedgerTx, 0, len(rows))
+	txIds := make([]string, 0, len(rows))
+	index := make(map[string]int)
+	for _, row := range rows {
+		var lt LedgerTx
+		lt.Txid = row["txid"]
+		lt.Block_hash = row["block_hash"]
+		for col, sum := range map[string]*int64{
+			"net":          (*int64)(&lt.Net),
+			"received":     (*int64)(&lt.Received),
+			"sent":         (*int64)(&lt.Sent),
+			"fee":          (*int64)(&lt.Fee),
+			"block_height": &lt.Block_height,
+			"block_time":   &lt.Block_time,
+		} {
+			if row[col] == "" {
+				continue
+			}
+			*sum, err = strconv.ParseInt(row[col], 10, 64)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid %s sum %s", col, row[col])
+			}
+		}
+		index[lt.Txid] = len(txs)
+		txs = append(txs, lt)
+		txIds = append(txIds, lt.Txid)
+	}
+	if len(txs) == 0 {
+		return txs, "", nil
+	}
+
+	entries := make([]ledger, 0)
+	err = GetDBEngine().Where(where, whereArgs...).In("txid", txIds).Asc("id").Find(&entries)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, e := range entries {
+		txs[index[e.Txid]].Entries = append(txs[index[e.Txid]].Entries, e)
+	}
+	cursor := ""
+	if q.Limit > 0 && len(rows) == q.Limit {
+		cursor = rows[len(rows)-1]["first_id"]
+	}
+	return txs, cursor, nil
+}
+
+// BackfillFromUtxos records the entries of the outputs tracked before the
+// ledger existed, dated by when they were stored.
+func (t *tblLedgerMgr) BackfillFromUtxos() error {
+	utxos := make([]utxo, 0)
+	err := GetDBEngine().Asc("id").Find(&utxos)
+	if err != nil {
+		return err
+	}
+	for _, u := range utxos {
+		err = t.AddEntry(ledger{Txid: u.Txid, Address: u.Address, Entry_type: LedgerEntryReceive, Utxo_txid: u.Txid,
+			Utxo_vout: u.Vout, Amount: int64(u.Amount), Coin_symbol: u.Coin_symbol, Block_hash: u.Block_hash,
+			Block_height: u.Block_height, Block_time: u.Created_at.Unix()})
+		if err != nil {
+			return err
+		}
+		if u.Used == 1 && u.Spent_txid != "" {
+			err = t.AddEntry(ledger{Txid: u.Spent_txid, Address: u.Address, Entry_type: LedgerEntrySpend, Utxo_txid: u.Txid,
+				Utxo_vout: u.Vout, Amount: -int64(u.Amount), Coin_symbol: u.Coin_symbol, Block_hash: u.Spent_block_hash,
+				Block_height: u.Spent_block_height, Block_time: u.Updated_at.Unix()})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type schemaVersion struct {
+	Id         int       `xorm:"pk INTEGER autoincr"`
+	Version    int       `xorm:"INT NOT NULL unique"`
+	Name       string    `xorm:"VARCHAR(128) NOT NULL"`
+	Applied_at time.Time `xorm:"created"`
+}
+
+type tblSchemaVersionMgr struct {
+	TableName string
+}
+
+func (t *tblSchemaVersionMgr) Init() {
+	t.TableName = "schema_version"
+}
+
+// CurrentVersion returns the latest applied schema version, 0 when none is.
+func (t *tblSchemaVersionMgr) CurrentVersion() (int, error) {
+	var v schemaVersion
+	exist, err := GetDBEngine().Desc("version").Get(&v)
+	if err != nil || !exist {
+		return 0, err
+	}
+	return v.Version, nil
+}
 
-	u.Pending = pending
-	_, err = GetDBEngine().Where("txid=?", txId).And("vout=?", vout).Cols("pending").Update(&u)
+func (t *tblSchemaVersionMgr) AddVersion(version int, name string) error {
+	_, err := GetDBEngine().InsertOne(&schemaVersion{Version: version, Name: name})
 	return err
 }
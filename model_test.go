@@ -2,21 +2,570 @@ package main
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestAddNewAddresses(t *testing.T) {
-	LoadConf()
-	fmt.Println("")
-	InitDB(GlobalConfig.DbConfig.DbType, GlobalConfig.DbConfig.DbSource)
-	GlobalDBMgr.TblAddressMgr.AddNewAddresses([]string{"13K4uYefwJ19t4NgYDgRyHfQfnwh5qULka",
-		"14K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"})
+	testInitDB(t)
+
+	added, existing, err := GlobalDBMgr.TblAddressMgr.AddNewAddresses([]address{{Address: "13K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"},
+		{Address: "14K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"}, {Address: "13K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"}})
+	if err != nil || len(added) != 2 || len(existing) != 0 {
+		t.Fatal("unexpected import", added, existing, err)
+	}
+	added, existing, err = GlobalDBMgr.TblAddressMgr.AddNewAddresses([]address{{Address: "15K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"},
+		{Address: "13K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"}})
+	if err != nil || len(added) != 1 || added[0] != "15K4uYefwJ19t4NgYDgRyHfQfnwh5qULka" ||
+		len(existing) != 1 || existing[0] != "13K4uYefwJ19t4NgYDgRyHfQfnwh5qULka" {
+		t.Fatal("unexpected import", added, existing, err)
+	}
+	addrs, err := GlobalDBMgr.TblAddressMgr.ListAddresses()
+	if err != nil || len(addrs) != 3 {
+		t.Fatal("unexpected addresses", addrs, err)
+	}
+
+	// several batches, half of them stored already
+	addresses := make([]address, 0)
+	for i := 0; i < 5*AddressInsertBatchSize/2; i++ {
+		addresses = append(addresses, address{Address: fmt.Sprintf("addr%d", i)})
+	}
+	if _, _, err = GlobalDBMgr.TblAddressMgr.AddNewAddresses(addresses[:len(addresses)/2]); err != nil {
+		t.Fatal(err)
+	}
+	added, existing, err = GlobalDBMgr.TblAddressMgr.AddNewAddresses(addresses)
+	if err != nil || len(added) != len(addresses)-len(addresses)/2 || len(existing) != len(addresses)/2 {
+		t.Fatal("unexpected import", len(added), len(existing), err)
+	}
+	if addrs, _ = GlobalDBMgr.TblAddressMgr.ListAddresses(); len(addrs) != 3+len(addresses) {
+		t.Fatal("unexpected addresses", len(addrs))
+	}
+
+	// addresses stored by another process are skipped and not counted
+	session := GetDBEngine().NewSession()
+	defer session.Close()
+	inserted, err := insertAddressesIgnore(session, []address{{Address: "addr0"}, {Address: "16K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"}})
+	if err != nil || inserted != 1 {
+		t.Fatal("unexpected insert", inserted, err)
+	}
 }
 
 func TestListAddrUtxos(t *testing.T) {
-	LoadConf()
-	fmt.Println("")
-	InitDB(GlobalConfig.DbConfig.DbType, GlobalConfig.DbConfig.DbSource)
-	utxos, _ := GlobalDBMgr.TblUtxoMgr.ListAddrUtxos("13K4uYefwJ19t4NgYDgRyHfQfnwh5qULka")
-	fmt.Println("utxos:", utxos)
+	testInitDB(t)
+
+	for _, u := range []utxo{
+		{Txid: "unspent", Address: "13K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"},
+		{Txid: "spent", Address: "13K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"},
+		{Txid: "pending", Address: "13K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"},
+		{Txid: "other", Address: "14K4uYefwJ19t4NgYDgRyHfQfnwh5qULka"},
+	} {
+		u.Amount = 10000
+		err := GlobalDBMgr.TblUtxoMgr.AddUtxo(u, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := GlobalDBMgr.TblUtxoMgr.MarkUtxoSpent("spent", 0, "s", "h1", 1, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := GlobalDBMgr.TblUtxoMgr.ReserveUtxo("pending", 0, "p", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	utxos, err := GlobalDBMgr.TblUtxoMgr.ListAddrUtxos("13K4uYefwJ19t4NgYDgRyHfQfnwh5qULka")
+	if err != nil || len(utxos) != 1 || utxos[0].Txid != "unspent" || utxos[0].Amount != 10000 {
+		t.Fatal("unexpected utxos", utxos, err)
+	}
+}
+
+func TestListSpendableUtxos(t *testing.T) {
+	testInitDB(t)
+
+	for _, u := range []utxo{
+		{Txid: "deposit-deep", Block_height: 95},
+		{Txid: "deposit-shallow", Block_height: 99},
+		{Txid: "change-shallow", Block_height: 100, Is_change: 1},
+		{Txid: "unconfirmed"},
+	} {
+		u.Address = "addr"
+		u.Amount = 10000000
+		err := GlobalDBMgr.TblUtxoMgr.AddUtxo(u, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		minDepositConf, minChangeConf int64
+		expected                      int
+	}{
+		{0, 0, 4},
+		{1, 1, 3},
+		{6, 1, 2},
+		{6, 2, 1},
+	} {
+		utxos, err := GlobalDBMgr.TblUtxoMgr.ListSpendableUtxos("addr", 100, c.minDepositConf, c.minChangeConf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(utxos) != c.expected {
+			t.Error("unexpected spendable count", c, utxos)
+		}
+	}
+
+	// without a tip the thresholds do not apply
+	if utxos, _ := GlobalDBMgr.TblUtxoMgr.ListSpendableUtxos("addr", 0, 6, 2); len(utxos) != 4 {
+		t.Error("unexpected spendable count without tip", utxos)
+	}
+
+	u, _ := testTrackedUtxo(t, "deposit-deep", 0)
+	if u.Confirmations(100) != 6 || u.Confirmations(90) != 0 {
+		t.Error("unexpected confirmations")
+	}
+	u, _ = testTrackedUtxo(t, "unconfirmed", 0)
+	if u.Confirmations(100) != 0 {
+		t.Error("unexpected confirmations of unconfirmed output")
+	}
+}
+
+func TestMigrateAmounts(t *testing.T) {
+	testInitDB(t)
+
+	// the VARCHAR amount column of earlier versions
+	for _, ddl := range []string{"alter table utxo rename column amount to amount_sat", "alter table utxo add column amount VARCHAR(128)"} {
+		if _, err := GetDBEngine().Exec(ddl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, amount := range []string{"0.50000000", "1.0", "12345", "1"} {
+		_, err := GetDBEngine().Exec("insert into utxo (txid, vout, amount, amount_sat, used, address, scriptpubkey, coin_symbol, pending) values (?, 0, ?, 0, 0, 'addr', '', 'BTC', 0)",
+			testTxid(byte(i)), amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// interrupted while filling the satoshi column
+	for _, ddl := range []string{"alter table utxo add amount_satoshi BIGINT NOT NULL DEFAULT 0", "update utxo set amount_satoshi=7"} {
+		if _, err := GetDBEngine().Exec(ddl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	migrated, err := GlobalDBMgr.TblUtxoMgr.MigrateAmounts()
+	if err != nil || migrated != 4 {
+		t.Fatal("unexpected migration", migrated, err)
+	}
+	for i, expected := range []Satoshi{50000000, 100000000, 1234500000000, 100000000} {
+		if u, _ := testTrackedUtxo(t, testTxid(byte(i)), 0); u.Amount != expected {
+			t.Error("unexpected amount", u.Amount, expected)
+		}
+	}
+	migrated, err = GlobalDBMgr.TblUtxoMgr.MigrateAmounts()
+	if err != nil || migrated != 0 {
+		t.Fatal("amounts migrated twice", migrated, err)
+	}
+
+	// interrupted after the text column was dropped
+	if _, err = GetDBEngine().Exec("alter table utxo rename column amount to amount_satoshi"); err != nil {
+		t.Fatal(err)
+	}
+	if migrated, err = GlobalDBMgr.TblUtxoMgr.MigrateAmounts(); err != nil || migrated != 0 {
+		t.Fatal("unexpected migration", migrated, err)
+	}
+	if u, _ := testTrackedUtxo(t, testTxid(0), 0); u.Amount != 50000000 {
+		t.Error("unexpected amount after swapping columns", u.Amount)
+	}
+}
+
+func TestReserveUtxos(t *testing.T) {
+	testInitDB(t)
+
+	for vout := 0; vout < 3; vout++ {
+		err := GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: "a", Vout: vout, Amount: 10000, Address: "addr"}, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expireAt := time.Now().Add(time.Hour)
+	err := GlobalDBMgr.TblUtxoMgr.ReserveUtxos([]OutPoint{{"a", 0}, {"a", 1}}, "first", expireAt, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reserving again for the same transaction is a no-op
+	if err = GlobalDBMgr.TblUtxoMgr.ReserveUtxo("a", 0, "first", expireAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// a conflicting reservation reserves nothing
+	err = GlobalDBMgr.TblUtxoMgr.ReserveUtxos([]OutPoint{{"a", 2}, {"a", 1}}, "second", expireAt, "")
+	if err == nil {
+		t.Fatal("reserved an output pending for another transaction")
+	}
+	if u, _ := testTrackedUtxo(t, "a", 2); u.Pending != 0 {
+		t.Fatal("partial reservation kept", u)
+	}
+	if err = GlobalDBMgr.TblUtxoMgr.ReserveUtxos([]OutPoint{{"a", 2}, {"b", 0}}, "second", expireAt, ""); err == nil {
+		t.Fatal("reserved an untracked output")
+	}
+
+	// a replacement takes over the inputs of the replaced transaction
+	err = GlobalDBMgr.TblUtxoMgr.ReserveUtxos([]OutPoint{{"a", 0}, {"a", 1}, {"a", 2}}, "replacement", expireAt, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for vout := 0; vout < 3; vout++ {
+		if u, _ := testTrackedUtxo(t, "a", vout); u.Pending != 1 || u.Pending_txid != "replacement" {
+			t.Fatal("input not reserved for the replacement", u)
+		}
+	}
+
+	if _, err = GlobalDBMgr.TblUtxoMgr.MarkUtxoSpent("a", 0, "replacement", "h1", 1, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = GlobalDBMgr.TblUtxoMgr.ReserveUtxo("a", 0, "replacement", expireAt); err == nil {
+		t.Fatal("reserved a spent output")
+	}
+	if err = GlobalDBMgr.TblUtxoMgr.ReserveUtxo("b", 0, "replacement", expireAt); err == nil {
+		t.Fatal("reserved an untracked output")
+	}
+}
+
+func TestReserveUtxoConcurrently(t *testing.T) {
+	testInitDB(t)
+
+	err := GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: "a", Amount: 10000, Address: "addr"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	reserved := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(pendingTxId string) {
+			defer wg.Done()
+			if GlobalDBMgr.TblUtxoMgr.ReserveUtxo("a", 0, pendingTxId, time.Now().Add(time.Hour)) == nil {
+				reserved <- pendingTxId
+			}
+		}(fmt.Sprintf("tx%d", i))
+	}
+	wg.Wait()
+	close(reserved)
+	winners := make([]string, 0)
+	for pendingTxId := range reserved {
+		winners = append(winners, pendingTxId)
+	}
+	if u, _ := testTrackedUtxo(t, "a", 0); len(winners) != 1 || u.Pending_txid != winners[0] {
+		t.Fatal("output reserved several times", winners, u)
+	}
+}
+
+func TestAddressMetadata(t *testing.T) {
+	testInitDB(t)
+
+	addresses := []address{
+		{Address: "1GJ23Q56cMqfVuGskN5gUKj2YYkmbtNVnL", Purpose: AddressPurposeDeposit, Has_key: 1},
+		{Address: "3MDSq8EZGz71f9BCLy1tpndHjvbXH8Wj4V", Purpose: AddressPurposeMultisig, Redeem_script: "5221"},
+	}
+	for i := 0; i < 5; i++ {
+		addresses = append(addresses, address{Address: fmt.Sprintf("addr%d", i), Account: "alice", Derive_path: fmt.Sprintf("0/%d", i)})
+	}
+	if _, _, err := GlobalDBMgr.TblAddressMgr.AddNewAddresses(addresses); err != nil {
+		t.Fatal(err)
+	}
+	a, exist, err := GlobalDBMgr.TblAddressMgr.GetAddress("1GJ23Q56cMqfVuGskN5gUKj2YYkmbtNVnL")
+	if err != nil || !exist || a.Script_type != "pubkeyhash" || a.Purpose != AddressPurposeDeposit || a.Has_key != 1 {
+		t.Fatal("unexpected address", a, err)
+	}
+	if a, _, _ = GlobalDBMgr.TblAddressMgr.GetAddress("3MDSq8EZGz71f9BCLy1tpndHjvbXH8Wj4V"); a.Script_type != "scripthash" || a.Redeem_script != "5221" {
+		t.Fatal("unexpected address", a)
+	}
+
+	exist, err = GlobalDBMgr.TblAddressMgr.SetMetadata("addr3", map[string]interface{}{"label": "savings", "purpose": AddressPurposeCold})
+	if err != nil || !exist {
+		t.Fatal("label not set", err)
+	}
+	if a, _, _ = GlobalDBMgr.TblAddressMgr.GetAddress("addr3"); a.Label != "savings" || a.Purpose != AddressPurposeCold || a.Account != "alice" {
+		t.Fatal("unexpected address", a)
+	}
+	if exist, err = GlobalDBMgr.TblAddressMgr.SetMetadata("addr9", map[string]interface{}{"label": "x"}); err != nil || exist {
+		t.Fatal("labelled an unknown address", err)
+	}
+
+	page, err := GlobalDBMgr.TblAddressMgr.ListAddressesPage(AddressFilter{Account: "alice"}, 0, 3)
+	if err != nil || len(page) != 3 || page[0].Address != "addr0" {
+		t.Fatal("unexpected page", page, err)
+	}
+	page, err = GlobalDBMgr.TblAddressMgr.ListAddressesPage(AddressFilter{Account: "alice"}, page[2].Id, 3)
+	if err != nil || len(page) != 2 || page[0].Address != "addr3" {
+		t.Fatal("unexpected page", page, err)
+	}
+	for filter, expected := range map[AddressFilter]int{
+		{Label: "savings"}:                     1,
+		{Purpose: AddressPurposeMultisig}:      1,
+		{Has_key: "1"}:                         1,
+		{Has_key: "0"}:                         6,
+		{Script_type: "pubkeyhash"}:            1,
+		{Account: "alice", Purpose: "deposit"}: 0,
+	} {
+		page, err = GlobalDBMgr.TblAddressMgr.ListAddressesPage(filter, 0, 100)
+		if err != nil || len(page) != expected {
+			t.Error("unexpected addresses of", filter, len(page), err)
+		}
+	}
+}
+
+func TestGetBalance(t *testing.T) {
+	testInitDB(t)
+
+	if _, _, err := GlobalDBMgr.TblAddressMgr.AddNewAddresses([]address{{Address: "addr1", Account: "alice"},
+		{Address: "addr2", Account: "alice"}, {Address: "addr3"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []utxo{
+		{Txid: "confirmed", Address: "addr1", Amount: 1000, Block_height: 10},
+		{Txid: "unconfirmed", Address: "addr1", Amount: 200},
+		{Txid: "pending", Address: "addr2", Amount: 30, Block_height: 11},
+		{Txid: "spent", Address: "addr2", Amount: 4, Block_height: 11},
+		{Txid: "other", Address: "addr3", Amount: 50000, Block_height: 12},
+	} {
+		if err := GlobalDBMgr.TblUtxoMgr.AddUtxo(u, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := GlobalDBMgr.TblUtxoMgr.ReserveUtxo("pending", 0, "tx", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GlobalDBMgr.TblUtxoMgr.MarkUtxoSpent("spent", 0, "tx", "h12", 12, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		addr, account string
+		expected      UtxoBalance
+	}{
+		{"", "", UtxoBalance{Utxos: 4, Confirmed: 51000, Unconfirmed: 200, Pending: 30, Total: 51230}},
+		{"addr1", "", UtxoBalance{Utxos: 2, Confirmed: 1000, Unconfirmed: 200, Total: 1200}},
+		{"", "alice", UtxoBalance{Utxos: 3, Confirmed: 1000, Unconfirmed: 200, Pending: 30, Total: 1230}},
+		{"", "bob", UtxoBalance{}},
+	} {
+		balance, err := GlobalDBMgr.TblUtxoMgr.GetBalance(c.addr, c.account)
+		if err != nil || balance != c.expected {
+			t.Error("unexpected balance of", c.addr, c.account, balance, err)
+		}
+	}
+}
+
+func TestQueryUtxos(t *testing.T) {
+	testInitDB(t)
+
+	if _, _, err := GlobalDBMgr.TblAddressMgr.AddNewAddresses([]address{{Address: "addr1", Account: "alice"},
+		{Address: "addr2"}}); err != nil {
+		t.Fatal(err)
+	}
+	for i, amount := range []Satoshi{500, 100, 300, 100, 200, 400} {
+		u := utxo{Txid: fmt.Sprintf("tx%d", i), Address: "addr1", Amount: amount, Block_height: int64(95 + i)}
+		if i%2 == 1 {
+			u.Address = "addr2"
+		}
+		if err := GlobalDBMgr.TblUtxoMgr.AddUtxo(u, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: "mempool", Address: "addr2", Amount: 600}, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := GlobalDBMgr.TblUtxoMgr.ReserveUtxo("tx5", 0, "tx", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	txIds := func(utxos []utxo) string {
+		ids := make([]string, 0, len(utxos))
+		for _, u := range utxos {
+			ids = append(ids, u.Txid)
+		}
+		return strings.Join(ids, ",")
+	}
+	for _, c := range []struct {
+		q        UtxoQuery
+		expected string
+	}{
+		{UtxoQuery{MaxConf: -1}, "tx0,tx1,tx2,tx3,tx4,mempool"},
+		{UtxoQuery{MaxConf: -1, IncludePending: true}, "tx0,tx1,tx2,tx3,tx4,tx5,mempool"},
+		{UtxoQuery{Addresses: []string{"addr2"}, MaxConf: -1}, "tx1,tx3,mempool"},
+		{UtxoQuery{Account: "alice", MaxConf: -1, IncludePending: true}, "tx0,tx2,tx4"},
+		{UtxoQuery{MinAmount: 200, MaxAmount: 400, MaxConf: -1}, "tx2,tx4"},
+		// tip 100: tx0 has 6 confirmations, tx4 2
+		{UtxoQuery{MinDepositConf: 3, MinChangeConf: 3, MaxConf: -1}, "tx0,tx1,tx2,tx3"},
+		{UtxoQuery{MaxConf: 2}, "tx4,mempool"},
+		{UtxoQuery{MaxConf: 0}, "mempool"},
+		{UtxoQuery{SortByAmount: true, MaxConf: -1}, "tx1,tx3,tx4,tx2,tx0,mempool"},
+		{UtxoQuery{SortByAmount: true, Desc: true, MaxConf: -1}, "mempool,tx0,tx2,tx4,tx3,tx1"},
+	} {
+		utxos, cursor, err := GlobalDBMgr.TblUtxoMgr.QueryUtxos(c.q, 100)
+		if err != nil || txIds(utxos) != c.expected || cursor != "" {
+			t.Error("unexpected utxos of", c.q, txIds(utxos), cursor, err)
+		}
+	}
+
+	// pages of two, the amount ties broken by id
+	for _, q := range []UtxoQuery{
+		{SortByAmount: true, MaxConf: -1, Limit: 2},
+		{SortByAmount: true, Desc: true, MaxConf: -1, Limit: 2},
+		{Desc: true, MaxConf: -1, Limit: 2},
+	} {
+		all := q
+		all.Limit = 0
+		expected, _, _ := GlobalDBMgr.TblUtxoMgr.QueryUtxos(all, 100)
+		paged := make([]utxo, 0)
+		for page := 0; page < 4; page++ {
+			utxos, cursor, err := GlobalDBMgr.TblUtxoMgr.QueryUtxos(q, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paged = append(paged, utxos...)
+			if cursor == "" {
+				break
+			}
+			q.Cursor = cursor
+		}
+		if txIds(paged) != txIds(expected) {
+			t.Error("unexpected pages", txIds(paged), txIds(expected))
+		}
+	}
+
+	if _, _, err := GlobalDBMgr.TblUtxoMgr.QueryUtxos(UtxoQuery{SortByAmount: true, Cursor: "12"}, 100); err == nil {
+		t.Error("invalid cursor accepted")
+	}
+}
+
+func TestReserveIndexes(t *testing.T) {
+	testInitDB(t)
+	err := GlobalDBMgr.TblXpubAccountMgr.AddAccount("acct", "xpub", XpubScriptTypeP2WPKH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = GlobalDBMgr.TblXpubAccountMgr.AddAccount("acct", "xpub", XpubScriptTypeP2WPKH); err == nil {
+		t.Fatal("account added twice")
+	}
+	if _, err = GlobalDBMgr.TblXpubAccountMgr.ReserveIndexes("unknown", false, 1); err == nil {
+		t.Fatal("indexes of an unknown account reserved")
+	}
+
+	var wg sync.WaitGroup
+	firsts := make([]int, 10)
+	for i := range firsts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			firsts[i], err = GlobalDBMgr.TblXpubAccountMgr.ReserveIndexes("acct", false, 2)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	handedOut := make(map[int]bool)
+	for _, first := range firsts {
+		if handedOut[first] || first%2 != 0 {
+			t.Fatal("index handed out twice", firsts)
+		}
+		handedOut[first] = true
+	}
+	account, _ := GlobalDBMgr.TblXpubAccountMgr.GetAccount("acct")
+	if account.Next_receive_index != 20 || account.Next_change_index != 0 {
+		t.Fatal("unexpected indexes", account.Next_receive_index, account.Next_change_index)
+	}
+}
+
+func TestMarkIndexUsed(t *testing.T) {
+	testInitDB(t)
+	err := GlobalDBMgr.TblXpubAccountMgr.AddAccount("acct", "xpub", XpubScriptTypeP2WPKH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := GlobalDBMgr.TblXpubAccountMgr.MarkIndexUsed("acct", true, 4)
+	if err != nil || next != 5 {
+		t.Fatal("unexpected next change index", next, err)
+	}
+	if next, _ = GlobalDBMgr.TblXpubAccountMgr.MarkIndexUsed("acct", true, 2); next != 5 {
+		t.Error("next change index moved back", next)
+	}
+	account, err := GlobalDBMgr.TblXpubAccountMgr.GetAccount("acct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Next_change_index != 5 || account.Next_receive_index != 0 {
+		t.Error("unexpected indexes", account.Next_receive_index, account.Next_change_index)
+	}
+}
+
+func TestUtxoLedgerEntries(t *testing.T) {
+	testInitDB(t)
+
+	err := GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: "a", Amount: 10000, Address: "addr", Coin_symbol: "BTC"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// confirmed in a block after being seen
+	err = GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: "a", Amount: 10000, Address: "addr", Coin_symbol: "BTC",
+		Block_hash: "h1", Block_height: 1}, 1000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := GlobalDBMgr.TblUtxoMgr.MarkUtxoSpent("a", 0, "b", "h2", 2, 2000, 500)
+	if err != nil || !ok {
+		t.Fatal("spend not marked", ok, err)
+	}
+	if ok, err = GlobalDBMgr.TblUtxoMgr.MarkUtxoSpent("untracked", 0, "b", "h2", 2, 2000, 500); err != nil || ok {
+		t.Fatal("untracked output spent", ok, err)
+	}
+
+	txs, _, err := GlobalDBMgr.TblLedgerMgr.ListTransactions(LedgerQuery{Address: "addr"})
+	if err != nil || len(txs) != 2 {
+		t.Fatal("unexpected ledger", txs, err)
+	}
+	if txs[0].Txid != "b" || txs[0].Net != -10000 || txs[0].Fee != 500 || txs[0].Block_height != 2 {
+		t.Error("unexpected spend", txs[0])
+	}
+	if txs[1].Txid != "a" || txs[1].Net != 10000 || txs[1].Block_hash != "h1" || txs[1].Block_time != 1000 {
+		t.Error("unexpected receive", txs[1])
+	}
+}
+
+func TestSaveSigned(t *testing.T) {
+	testInitDB(t)
+
+	for _, raw := range []string{"00", "01"} {
+		if err := GlobalDBMgr.TblTxMgr.SaveSigned("a", raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, _ := GetDBEngine().Count(new(tx)); count != 1 {
+		t.Fatal("transaction recorded twice", count)
+	}
+	record, _, _ := GlobalDBMgr.TblTxMgr.GetTx("a")
+	if record.Raw != "00" || record.Status != TxStatusSigned {
+		t.Fatal("unexpected transaction", record)
+	}
+
+	if _, err := GlobalDBMgr.TblTxMgr.SetStatus("a", TxStatusFailed, "rejected", TxStatusSigned); err != nil {
+		t.Fatal(err)
+	}
+	if err := GlobalDBMgr.TblTxMgr.SaveSigned("a", "01"); err != nil {
+		t.Fatal(err)
+	}
+	if record, _, _ = GlobalDBMgr.TblTxMgr.GetTx("a"); record.Raw != "01" || record.Status != TxStatusSigned || record.Error != "" {
+		t.Fatal("failed transaction not reset", record)
+	}
+
+	for height := int64(1); height <= 2; height++ {
+		if err := GlobalDBMgr.TblSyncStateMgr.SetLastBlock("BTC", fmt.Sprintf("h%d", height), height); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, _, _ := GlobalDBMgr.TblSyncStateMgr.GetLastBlock("BTC")
+	if count, _ := GetDBEngine().Count(new(syncState)); count != 1 || state.Block_hash != "h2" || state.Block_height != 2 {
+		t.Fatal("unexpected sync state", count, state)
+	}
 }
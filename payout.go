@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+type PayoutEntry struct {
+	Address   string
+	Amount    Satoshi
+	Reference string
+}
+
+// PayoutOutput locates the output paying one payout entry.
+type PayoutOutput struct {
+	TxId string `json:"txid"`
+	Vout int    `json:"vout"`
+}
+
+type PayoutTx struct {
+	TxId   string `json:"txid"`
+	Hex    string `json:"hex"`
+	Payees int    `json:"payees"`
+	Fee    int64  `json:"fee"`
+	VSize  int64  `json:"vsize"`
+	// -1 when the transaction has no change
+	ChangeVout int `json:"changeVout"`
+}
+
+type BatchPayoutResult struct {
+	Transactions []PayoutTx `json:"transactions"`
+	// by client reference
+	Payouts map[string]PayoutOutput `json:"payouts"`
+}
+
+// ValidatePayoutEntries checks that every entry pays at least the dust limit
+// to an address of the configured network and that the references are
+// unique, and returns the scriptPubKeys of the entries.
+func ValidatePayoutEntries(entries []PayoutEntry) ([][]byte, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("no payout entries")
+	}
+	scriptPubKeys := make([][]byte, 0, len(entries))
+	references := make(map[string]bool)
+	for i, entry := range entries {
+		if entry.Reference == "" {
+			return nil, fmt.Errorf("entry %d without reference", i)
+		}
+		if references[entry.Reference] {
+			return nil, fmt.Errorf("duplicate reference %s", entry.Reference)
+		}
+		references[entry.Reference] = true
+		scriptPubKey, err := BTCScriptPubKeyFromAddress(entry.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s of %s: %s", entry.Address, entry.Reference, err.Error())
+		}
+		if int64(entry.Amount) < DustLimit {
+			return nil, fmt.Errorf("amount %d of %s below dust limit", entry.Amount, entry.Reference)
+		}
+		scriptPubKeys = append(scriptPubKeys, scriptPubKey)
+	}
+	return scriptPubKeys, nil
+}
+
+// BTCBatchPayout pays entries at feeRate sat/vB from the spendable outputs
+// of our addresses owned by privKeyStrs, with one output per entry and the
+// change to changeAddress. Entries beyond the configured maximum of outputs
+// per transaction go to further transactions. All transactions are signed,
+// or none is: when one cannot be funded or signed the ones before are
+// abandoned.
+func BTCBatchPayout(entries []PayoutEntry, feeRate float64, privKeyStrs []string, changeAddress string) (*BatchPayoutResult, error) {
+	scriptPubKeys, err := ValidatePayoutEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+	changeScript, err := BTCScriptPubKeyFromAddress(changeAddress)
+	if err != nil {
+		return nil, errors.New("invalid change address: " + err.Error())
+	}
+	candidates, err := spendableUtxoDetails(nil, privKeyStrs, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BatchPayoutResult{Transactions: make([]PayoutTx, 0), Payouts: make(map[string]PayoutOutput)}
+	signedTxIds := make([]string, 0)
+	batchSize := GlobalConfig.TxConfig.PayoutBatchSize()
+	for start := 0; start < len(entries); start += batchSize {
+		end := start + batchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		tx, remaining, err := payoutBatch(entries[start:end], scriptPubKeys[start:end], candidates, changeScript, feeRate, privKeyStrs)
+		if err != nil {
+			abandonSignedTransactions(signedTxIds, "batch payout aborted")
+			return nil, fmt.Errorf("payout of entries %d to %d fail: %s", start, end-1, err.Error())
+		}
+		candidates = remaining
+		signedTxIds = append(signedTxIds, tx.TxId)
+		result.Transactions = append(result.Transactions, *tx)
+		for i, entry := range entries[start:end] {
+			result.Payouts[entry.Reference] = PayoutOutput{TxId: tx.TxId, Vout: i}
+		}
+	}
+	Info.Printf("paid %d payout entries in %d transactions", len(entries), len(result.Transactions))
+	return result, nil
+}
+
+// payoutBatch funds and signs one payout transaction, the outputs in the
+// order of entries, and returns the candidates it left unspent.
+func payoutBatch(entries []PayoutEntry, scriptPubKeys [][]byte, candidates []UTXODetail, changeScript []byte, feeRate float64, privKeyStrs []string) (*PayoutTx, []UTXODetail, error) {
+	outputs := make([]TxBuildOutput, 0, len(entries))
+	for i, entry := range entries {
+		outputs = append(outputs, TxBuildOutput{ScriptPubKey: scriptPubKeys[i], Amount: int64(entry.Amount)})
+	}
+	funded, err := BTCFundTransaction(nil, candidates, outputs, changeScript, FeeTarget{FeeRate: feeRate})
+	if err != nil {
+		return nil, nil, err
+	}
+	if funded.VSize > MaxStandardTxVSize {
+		return nil, nil, fmt.Errorf("transaction of %d vbytes above the standard size limit", funded.VSize)
+	}
+	txId, trxSigStr, err := signFundedTransaction(funded, privKeyStrs, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spent := make(map[string]bool)
+	for _, input := range funded.Inputs {
+		spent[outPointKey(input.TxId, input.Vout)] = true
+	}
+	remaining := make([]UTXODetail, 0, len(candidates))
+	for _, candidate := range candidates {
+		if !spent[outPointKey(candidate.TxId, candidate.Vout)] {
+			remaining = append(remaining, candidate)
+		}
+	}
+	return &PayoutTx{TxId: txId, Hex: trxSigStr, Payees: len(entries), Fee: funded.Fee, VSize: funded.VSize,
+		ChangeVout: funded.ChangeIndex}, remaining, nil
+}
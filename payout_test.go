@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBTCBatchPayout(t *testing.T) {
+	testInitDB(t)
+	keyHex := testPrivKeyHex(61)
+	ourScript := testFundedAddress(t, keyHex, 100000000, 50000000)
+	changeAddr, _ := BTCAddressFromScriptPubKey(ourScript)
+	payees := make([]string, 3)
+	for i := range payees {
+		payees[i], _ = BTCAddressFromScriptPubKey(BTCGetWitnessScriptPubKey(0, bytes.Repeat([]byte{byte(0x30 + i)}, 20)))
+	}
+	testnetAddr, _ := BTCEncodeSegwitAddress("tb", 0, bytes.Repeat([]byte{0x33}, 20))
+
+	maxOutputs := GlobalConfig.TxConfig.MaxPayoutOutputs
+	GlobalConfig.TxConfig.MaxPayoutOutputs = 2
+	defer func() { GlobalConfig.TxConfig.MaxPayoutOutputs = maxOutputs }()
+
+	invalid := [][]PayoutEntry{
+		{{Address: testnetAddr, Amount: 10000, Reference: "w1"}},
+		{{Address: payees[0], Amount: 10000, Reference: "w1"}, {Address: payees[1], Amount: 10000, Reference: "w1"}},
+		{{Address: payees[0], Amount: 100, Reference: "w1"}},
+		{{Address: payees[0], Amount: 10000}},
+	}
+	for _, entries := range invalid {
+		if _, err := BTCBatchPayout(entries, 2, []string{keyHex}, changeAddr); err == nil {
+			t.Error("invalid payout accepted", entries)
+		}
+	}
+
+	entries := []PayoutEntry{
+		{Address: payees[0], Amount: 60000000, Reference: "w1"},
+		{Address: payees[1], Amount: 20000000, Reference: "w2"},
+		{Address: payees[2], Amount: 90000000, Reference: "w3"},
+	}
+	// the second batch cannot be funded, the first is abandoned
+	_, err := BTCBatchPayout(entries, 2, []string{keyHex}, changeAddr)
+	if err == nil {
+		t.Fatal("payout without funds accepted")
+	}
+	pending, err := GlobalDBMgr.TblUtxoMgr.ListPendingUtxos(changeAddr)
+	if err != nil || len(pending) != 0 {
+		t.Fatal("inputs of the abandoned batch kept", pending, err)
+	}
+
+	entries[2].Amount = 30000000
+	result, err := BTCBatchPayout(entries, 2, []string{keyHex}, changeAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Transactions) != 2 || result.Transactions[0].Payees != 2 || result.Transactions[1].Payees != 1 {
+		t.Fatal("unexpected batches", result.Transactions)
+	}
+	for _, entry := range entries {
+		payout, ok := result.Payouts[entry.Reference]
+		if !ok {
+			t.Fatal("payout missing", entry.Reference)
+		}
+		var hex string
+		for _, tx := range result.Transactions {
+			if tx.TxId == payout.TxId {
+				hex = tx.Hex
+			}
+		}
+		decoded, _ := BTCDecodeRawTransaction(hex, nil)
+		if decoded == nil || decoded.Vout[payout.Vout].Address != entry.Address || decoded.Vout[payout.Vout].Amount != int64(entry.Amount) {
+			t.Fatal("payout not at its output", entry, payout)
+		}
+	}
+	first, _ := BTCDecodeRawTransaction(result.Transactions[0].Hex, nil)
+	second, _ := BTCDecodeRawTransaction(result.Transactions[1].Hex, nil)
+	if first.Vin[0].TxId == second.Vin[0].TxId || first.Vout[result.Transactions[0].ChangeVout].Address != changeAddr {
+		t.Fatal("unexpected inputs or change", first, second)
+	}
+}
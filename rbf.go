@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+type BumpFeeResult struct {
+	TxId     string `json:"txid"`
+	Hex      string `json:"hex"`
+	OrigTxId string `json:"origTxid"`
+	OrigFee  int64  `json:"origFee"`
+	Fee      int64  `json:"fee"`
+	VSize    int64  `json:"vsize"`
+}
+
+// BTCBumpFee builds a BIP125 replacement of the tracked transaction
+// origTxId paying feeRate sat/vB. The payments are kept; the fee comes out
+// of the change output, and confirmed outputs of the input addresses are
+// added when the change does not cover it. The change output pays to our
+// only output address, or to changeAddress when given. The replacement is
+// signed with privKeyStrs, recorded as replacing origTxId and its inputs
+// are reserved for it; broadcasting it marks the original replaced.
+func BTCBumpFee(origTxId string, feeRate float64, privKeyStrs []string, changeAddress string) (*BumpFeeResult, error) {
+	record, exist, err := GlobalDBMgr.TblTxMgr.GetTx(origTxId)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, errors.New("transaction not tracked")
+	}
+	if record.Status != TxStatusSigned && record.Status != TxStatusBroadcast && record.Status != TxStatusInMempool {
+		return nil, fmt.Errorf("transaction is %s, cannot replace it", record.Status)
+	}
+	trx, err := BTCUnPackRawTransaction(record.Raw)
+	if err != nil {
+		return nil, err
+	}
+	if !SignalsRBF(trx) {
+		return nil, errors.New("transaction does not signal BIP125 replaceability")
+	}
+
+	inputs := make([]UTXODetail, 0, len(trx.Vin))
+	inputAddrs := make([]string, 0, len(trx.Vin))
+	spent := make(map[string]bool)
+	totalIn := int64(0)
+	for i, vin := range trx.Vin {
+		u, exist, err := GlobalDBMgr.TblUtxoMgr.GetUtxo(vin.PrevOut.Hash.GetHex(), int(vin.PrevOut.N))
+		if err != nil {
+			return nil, err
+		}
+		if !exist {
+			return nil, fmt.Errorf("input %d is not a tracked utxo", i)
+		}
+		detail, err := utxoDetail(u)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, detail)
+		inputAddrs = append(inputAddrs, u.Address)
+		spent[outPointKey(u.Txid, u.Vout)] = true
+		totalIn += int64(detail.Amount)
+	}
+
+	outputAddrs := make([]string, len(trx.Vout))
+	totalOut := int64(0)
+	for i, vout := range trx.Vout {
+		outputAddrs[i], _ = BTCAddressFromScriptPubKey(vout.ScriptPubKey.GetScriptBytes())
+		totalOut += vout.Value
+	}
+	origFee := totalIn - totalOut
+
+	changeIndex := -1
+	if changeAddress != "" {
+		for i, addr := range outputAddrs {
+			if addr == changeAddress {
+				changeIndex = i
+				break
+			}
+		}
+	} else {
+		ourAddrs, err := GlobalDBMgr.TblAddressMgr.FilterExistAddresses(outputAddrs)
+		if err != nil {
+			return nil, err
+		}
+		for i, addr := range outputAddrs {
+			if addr == "" || !ourAddrs[addr] {
+				continue
+			}
+			if changeIndex >= 0 {
+				return nil, errors.New("several outputs pay to our addresses, change address needed")
+			}
+			changeIndex = i
+		}
+	}
+
+	var changeScript []byte
+	if changeIndex >= 0 {
+		changeScript = trx.Vout[changeIndex].ScriptPubKey.GetScriptBytes()
+	} else if changeAddress != "" {
+		changeScript, err = BTCScriptPubKeyFromAddress(changeAddress)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		changeScript, err = utxoScriptPubKey(&inputs[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	outputs := make([]TxBuildOutput, 0, len(trx.Vout))
+	for i, vout := range trx.Vout {
+		if i != changeIndex {
+			outputs = append(outputs, TxBuildOutput{ScriptPubKey: vout.ScriptPubKey.GetScriptBytes(), Amount: vout.Value})
+		}
+	}
+
+	// BIP125 forbids new unconfirmed inputs
+	candidates, err := spendableUtxoDetails(inputAddrs, privKeyStrs, spent, true)
+	if err != nil {
+		return nil, err
+	}
+	funded, err := BTCFundTransaction(inputs, candidates, outputs, changeScript, FeeTarget{FeeRate: feeRate, ReplacedFee: origFee})
+	if err != nil {
+		return nil, err
+	}
+	// keep the change where it was
+	if changeIndex >= 0 && funded.ChangeIndex >= 0 && changeIndex < funded.ChangeIndex {
+		change := funded.Outputs[funded.ChangeIndex]
+		copy(funded.Outputs[changeIndex+1:], funded.Outputs[changeIndex:funded.ChangeIndex])
+		funded.Outputs[changeIndex] = change
+		funded.ChangeIndex = changeIndex
+	}
+
+	txId, trxSigStr, err := signFundedTransaction(funded, privKeyStrs, origTxId)
+	if err != nil {
+		return nil, err
+	}
+	Info.Printf("transaction %s bumped by %s, fee %d -> %d", origTxId, txId, origFee, funded.Fee)
+	return &BumpFeeResult{TxId: txId, Hex: trxSigStr, OrigTxId: origTxId, OrigFee: origFee, Fee: funded.Fee, VSize: funded.VSize}, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// testFundedAddress tracks a p2wpkh address of key with confirmed outputs of
+// the given amounts and returns its scriptPubKey.
+func testFundedAddress(t *testing.T, keyHex string, amounts ...Satoshi) []byte {
+	keyBytes, _ := hex.DecodeString(keyHex)
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+	addr, _ := BTCAddressFromScriptPubKey(scriptPubKeys[1])
+	_, _, err := GlobalDBMgr.TblAddressMgr.AddNewAddresses([]address{{Address: addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, amount := range amounts {
+		err = GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: testTxid(byte(0xa0 + i)), Amount: amount, Address: addr,
+			Scriptpubkey: hex.EncodeToString(scriptPubKeys[1]), Block_height: 1}, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = GlobalDBMgr.TblSyncStateMgr.SetLastBlock("BTC", "tip", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scriptPubKeys[1]
+}
+
+func TestBTCBumpFee(t *testing.T) {
+	testInitDB(t)
+	keyHex := testPrivKeyHex(31)
+	ourScript := testFundedAddress(t, keyHex, 100000000, 50000000)
+	payee := BTCGetWitnessScriptPubKey(0, bytes.Repeat([]byte{0x11}, 20))
+
+	u, _ := testTrackedUtxo(t, testTxid(0xa0), 0)
+	input, _ := utxoDetail(u)
+	funded, err := BTCFundTransaction([]UTXODetail{input}, nil, []TxBuildOutput{{ScriptPubKey: payee, Amount: 90000000}}, ourScript, FeeTarget{FeeRate: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	origTxId, _, err := signFundedTransaction(funded, []string{keyHex}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fee comes out of the change
+	bumped, err := BTCBumpFee(origTxId, 50, []string{keyHex}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := BTCDecodeRawTransaction(bumped.Hex, nil)
+	if bumped.OrigFee != funded.Fee || bumped.Fee < 50*int64(decoded.VSize) || len(decoded.Vin) != 1 {
+		t.Fatal("unexpected replacement", bumped, decoded.VSize)
+	}
+	if decoded.Vout[0].Amount != 90000000 || decoded.Vout[1].Amount != 100000000-90000000-bumped.Fee {
+		t.Fatal("unexpected outputs", decoded.Vout)
+	}
+	record := testTxStatus(t, bumped.TxId)
+	if record.Status != TxStatusSigned || record.Replaces != origTxId {
+		t.Fatal("replacement not recorded", record)
+	}
+	if u, _ = testTrackedUtxo(t, testTxid(0xa0), 0); u.Pending != 1 || u.Pending_txid != bumped.TxId {
+		t.Fatal("input not reserved for the replacement", u)
+	}
+
+	// the change cannot cover the fee, the other output is added
+	rebumped, err := BTCBumpFee(bumped.TxId, 100000, []string{keyHex}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ = BTCDecodeRawTransaction(rebumped.Hex, nil)
+	if len(decoded.Vin) != 2 || decoded.Vout[0].Amount != 90000000 || rebumped.Fee < 100000*int64(decoded.VSize) {
+		t.Fatal("unexpected replacement", rebumped, decoded)
+	}
+	if u, _ = testTrackedUtxo(t, testTxid(0xa1), 0); u.Pending != 1 || u.Pending_txid != rebumped.TxId {
+		t.Fatal("added input not reserved", u)
+	}
+
+	// broadcasting the replacement retires the replaced transaction
+	node := newFakeChainNode()
+	if _, err = BroadcastTransaction(node, rebumped.Hex); err != nil {
+		t.Fatal(err)
+	}
+	if record = testTxStatus(t, bumped.TxId); record.Status != TxStatusReplaced || record.Replaced_by != rebumped.TxId {
+		t.Fatal("replaced transaction not retired", record)
+	}
+	if _, err = BTCBumpFee(bumped.TxId, 200, []string{keyHex}, ""); err == nil {
+		t.Fatal("replaced transaction bumped")
+	}
+
+	// a rejected replacement hands the inputs back
+	node.sendErr = errTestRejected
+	replacement, err := BTCBumpFee(rebumped.TxId, 200000, []string{keyHex}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = BroadcastTransaction(node, replacement.Hex); err == nil {
+		t.Fatal("rejected replacement broadcast")
+	}
+	for i := 0; i < 2; i++ {
+		if u, _ = testTrackedUtxo(t, testTxid(byte(0xa0+i)), 0); u.Pending != 1 || u.Pending_txid != rebumped.TxId {
+			t.Fatal("input not reserved for the original", u)
+		}
+	}
+}
+
+func TestBTCBumpFeeRequiresSignal(t *testing.T) {
+	testInitDB(t)
+	keyHex := testPrivKeyHex(31)
+	ourScript := testFundedAddress(t, keyHex, 100000000)
+
+	u, _ := testTrackedUtxo(t, testTxid(0xa0), 0)
+	input, _ := utxoDetail(u)
+	rawTrx, _ := BTCBuildRawTransaction([]UTXODetail{input}, []TxBuildOutput{{ScriptPubKey: ourScript, Amount: 99990000}}, 0xffffffff)
+	signedHex, _, err := BTCSignRawTransactionWithKeys(rawTrx, []string{keyHex}, []UTXODetail{input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := BTCDecodeRawTransaction(signedHex, nil)
+	err = GlobalDBMgr.TblTxMgr.SaveSigned(decoded.TxId, signedHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = BTCBumpFee(decoded.TxId, 10, []string{keyHex}, ""); err == nil {
+		t.Fatal("non-signalling transaction bumped")
+	}
+	if _, err = BTCBumpFee(testTxid(1), 10, []string{keyHex}, ""); err == nil {
+		t.Fatal("untracked transaction bumped")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"time"
+)
+
+// PendingReaper releases the reservations of outputs whose reserving
+// transaction was never broadcast or was dropped from the node's mempool.
+type PendingReaper struct {
+	Node ChainNode
+	Now  func() time.Time
+}
+
+func NewPendingReaper(node ChainNode) *PendingReaper {
+	return &PendingReaper{Node: node, Now: time.Now}
+}
+
+// ReapOnce releases the expired reservations and returns their number. A
+// reservation is kept while its transaction is in the mempool or while the
+// node reports the output spent, which the scanner records once confirmed.
+// An output of a transaction the node does not know is not spent, its
+// reservation is released.
+func (r *PendingReaper) ReapOnce() (int, error) {
+	utxos, err := GlobalDBMgr.TblUtxoMgr.ListPendingUtxos("")
+	if err != nil {
+		return 0, err
+	}
+
+	now := r.Now()
+	inMempool := make(map[string]bool)
+	released := 0
+	for _, u := range utxos {
+		if u.Pending_expire_at.After(now) {
+			continue
+		}
+		if u.Pending_txid != "" {
+			found, checked := inMempool[u.Pending_txid]
+			if !checked {
+				found, err = r.Node.IsInMempool(u.Pending_txid)
+				if err != nil {
+					return released, err
+				}
+				inMempool[u.Pending_txid] = found
+			}
+			if found {
+				continue
+			}
+		}
+		unspent, err := r.Node.IsOutputUnspent(u.Txid, u.Vout)
+		if err != nil {
+			return released, err
+		}
+		if !unspent {
+			// gettxout returns null for unknown transactions as well
+			known, err := r.Node.IsTransactionKnown(u.Txid, u.Block_hash)
+			if err != nil {
+				return released, err
+			}
+			if known {
+				continue
+			}
+		}
+		ok, err := GlobalDBMgr.TblUtxoMgr.ReleaseUtxo(u.Txid, u.Vout)
+		if err != nil {
+			return released, err
+		}
+		if ok {
+			released++
+			Info.Printf("utxo %s:%d released, reserving transaction %s not found", u.Txid, u.Vout, u.Pending_txid)
+		}
+	}
+	return released, nil
+}
+
+// Run reaps every interval until stop is closed.
+func (r *PendingReaper) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		released, err := r.ReapOnce()
+		if err != nil {
+			Error.Println("PendingReaper ReapOnce fail:", err.Error())
+		} else if released > 0 {
+			Info.Printf("PendingReaper released %d utxos", released)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingReaper(t *testing.T) {
+	testInitDB(t)
+
+	node := newFakeChainNode()
+	node.extend(0, "h0", testChainTx("a", nil, "51", "51", "51", "51"))
+	for vout := 0; vout < 4; vout++ {
+		err := GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: "a", Vout: vout, Amount: 50000000, Address: "addr", Block_height: 0}, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	reservations := []struct {
+		pendingTxId string
+		expireAt    time.Time
+	}{
+		// broadcast and waiting in the mempool
+		{"in-mempool", expired},
+		// never broadcast
+		{"dropped", expired},
+		// not expired yet
+		{"fresh", now.Add(time.Hour)},
+		// confirmed in a block the scanner has not processed yet
+		{"confirmed", expired},
+	}
+	for vout, r := range reservations {
+		err := GlobalDBMgr.TblUtxoMgr.ReserveUtxo("a", vout, r.pendingTxId, r.expireAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	node.mempool = append(node.mempool, testChainTx("in-mempool", []string{"a 0"}, "51"))
+	node.extend(1, "h1", testChainTx("confirmed", []string{"a 3"}, "51"))
+
+	reaper := NewPendingReaper(node)
+	reaper.Now = func() time.Time { return now }
+	released, err := reaper.ReapOnce()
+	if err != nil || released != 1 {
+		t.Fatal("unexpected reap result", released, err)
+	}
+	u, _ := testTrackedUtxo(t, "a", 1)
+	if u.Pending != 0 || u.Pending_txid != "" {
+		t.Fatal("reservation of a dropped transaction kept", u)
+	}
+	pending, err := GlobalDBMgr.TblUtxoMgr.ListPendingUtxos("addr")
+	if err != nil || len(pending) != 3 {
+		t.Fatal("unexpected pending utxos", pending, err)
+	}
+
+	// evicted from the mempool
+	node.mempool = nil
+	released, err = reaper.ReapOnce()
+	if err != nil || released != 1 {
+		t.Fatal("unexpected reap result", released, err)
+	}
+	if u, _ = testTrackedUtxo(t, "a", 0); u.Pending != 0 {
+		t.Fatal("reservation of an evicted transaction kept", u)
+	}
+
+	// the funding transaction is unknown to the node
+	err = GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: "unknown", Amount: 50000000, Address: "addr"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GlobalDBMgr.TblUtxoMgr.ReserveUtxo("unknown", 0, "never-broadcast", expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	released, err = reaper.ReapOnce()
+	if err != nil || released != 1 {
+		t.Fatal("unexpected reap result", released, err)
+	}
+	if u, _ = testTrackedUtxo(t, "unknown", 0); u.Pending != 0 {
+		t.Fatal("reservation of an output of an unknown transaction kept", u)
+	}
+
+	ok, err := GlobalDBMgr.TblUtxoMgr.ReleaseUtxo("a", 2)
+	if err != nil || !ok {
+		t.Fatal("release of a fresh reservation fail", err)
+	}
+	ok, err = GlobalDBMgr.TblUtxoMgr.ReleaseUtxo("a", 2)
+	if err != nil || ok {
+		t.Fatal("released an output not pending", err)
+	}
+}
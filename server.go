@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type JsonRpcRequest struct {
@@ -39,9 +40,10 @@ type GenerateAddressResponse struct {
 }
 
 type SignTransactionResponse struct {
-	Id     interface{} `json:"id"`
-	Result *string     `json:"result"`
-	Error  *Err        `json:"error"`
+	Id           interface{}         `json:"id"`
+	Result       *string             `json:"result"`
+	Verification []InputVerification `json:"verification,omitempty"`
+	Error        *Err                `json:"error"`
 }
 
 type MultiSigAddressRes struct {
@@ -56,28 +58,265 @@ type GenerateMultiAddressResponse struct {
 }
 
 type MultiSignTransactionResponse struct {
+	Id           interface{}         `json:"id"`
+	Result       *string             `json:"result"`
+	Verification []InputVerification `json:"verification,omitempty"`
+	Error        *Err                `json:"error"`
+}
+
+type ImportAddressesRes struct {
+	Added    []string `json:"added"`
+	Existing []string `json:"existing"`
+}
+
+type ImportAddressesResponse struct {
+	Id     interface{}         `json:"id"`
+	Result *ImportAddressesRes `json:"result"`
+	Error  *Err                `json:"error"`
+}
+
+type AddressInfoRes struct {
+	Address      string `json:"address"`
+	Label        string `json:"label"`
+	Account      string `json:"account"`
+	Purpose      string `json:"purpose"`
+	ScriptType   string `json:"scriptType"`
+	Descriptor   string `json:"descriptor,omitempty"`
+	DeriveIndex  *int   `json:"deriveIndex,omitempty"`
+	DerivePath   string `json:"derivePath,omitempty"`
+	RedeemScript string `json:"redeemScript,omitempty"`
+	HasKey       bool   `json:"hasKey"`
+	CreatedAt    int64  `json:"createdAt"`
+}
+
+type GetAddressInfoResponse struct {
+	Id     interface{}     `json:"id"`
+	Result *AddressInfoRes `json:"result"`
+	Error  *Err            `json:"error"`
+}
+
+type SetAddressLabelResponse struct {
+	Id     interface{}     `json:"id"`
+	Result *AddressInfoRes `json:"result"`
+	Error  *Err            `json:"error"`
+}
+
+type ListAddressesRes struct {
+	Addresses []AddressInfoRes `json:"addresses"`
+	// pass as the cursor option to get the next page, 0 after the last page
+	Cursor int `json:"cursor"`
+}
+
+type ListAddressesResponse struct {
+	Id     interface{}       `json:"id"`
+	Result *ListAddressesRes `json:"result"`
+	Error  *Err              `json:"error"`
+}
+
+type BalanceRes struct {
+	Address     string  `json:"address,omitempty"`
+	Account     string  `json:"account,omitempty"`
+	Utxos       int64   `json:"utxos"`
+	Confirmed   Satoshi `json:"confirmed"`
+	Unconfirmed Satoshi `json:"unconfirmed"`
+	Pending     Satoshi `json:"pending"`
+	Total       Satoshi `json:"total"`
+}
+
+type GetBalanceResponse struct {
+	Id     interface{} `json:"id"`
+	Result *BalanceRes `json:"result"`
+	Error  *Err        `json:"error"`
+}
+
+type LedgerEntryRes struct {
+	Type    string  `json:"type"`
+	Address string  `json:"address"`
+	Txid    string  `json:"txid"`
+	Vout    int     `json:"vout"`
+	Amount  Satoshi `json:"amount"`
+}
+
+type LedgerTxRes struct {
+	Txid          string           `json:"txid"`
+	Received      Satoshi          `json:"received"`
+	Sent          Satoshi          `json:"sent"`
+	Net           Satoshi          `json:"net"`
+	Fee           Satoshi          `json:"fee,omitempty"`
+	BlockHash     string           `json:"blockHash"`
+	BlockHeight   int64            `json:"blockHeight"`
+	BlockTime     int64            `json:"blockTime"`
+	Confirmations int64            `json:"confirmations"`
+	Entries       []LedgerEntryRes `json:"entries"`
+}
+
+type ListTransactionsRes struct {
+	Transactions []LedgerTxRes `json:"transactions"`
+	// pass as the cursor option to get the next page, empty after the last page
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type ListTransactionsResponse struct {
+	Id     interface{}          `json:"id"`
+	Result *ListTransactionsRes `json:"result"`
+	Error  *Err                 `json:"error"`
+}
+
+type UtxoRes struct {
+	Address       string  `json:"address"`
+	Txid          string  `json:"txid"`
+	Vout          int     `json:"vout"`
+	Amount        Satoshi `json:"amount"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	Confirmations int64   `json:"confirmations"`
+	IsChange      bool    `json:"isChange"`
+	Pending       bool    `json:"pending"`
+	PendingTxid   string  `json:"pendingTxid,omitempty"`
+	CoinSymbol    string  `json:"coinSymbol"`
+}
+
+type QueryUtxosRes struct {
+	Utxos []UtxoRes `json:"utxos"`
+	// pass as the cursor option to get the next page, empty after the last page
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type QueryUtxosResponse struct {
+	Id interface{} `json:"id"`
+	// *[]UtxoRes when queried by addresses, *QueryUtxosRes when queried with options
+	Result interface{} `json:"result"`
+	Error  *Err        `json:"error"`
+}
+
+type PendingUtxoRes struct {
+	Address     string  `json:"address"`
+	Txid        string  `json:"txid"`
+	Vout        int     `json:"vout"`
+	Amount      Satoshi `json:"amount"`
+	PendingTxid string  `json:"pendingTxid"`
+	// unix time after which the reaper may release the reservation
+	ExpireAt int64 `json:"expireAt"`
+	Expired  bool  `json:"expired"`
+}
+
+type ListPendingResponse struct {
+	Id     interface{}       `json:"id"`
+	Result *[]PendingUtxoRes `json:"result"`
+	Error  *Err              `json:"error"`
+}
+
+type ReleaseUtxosResponse struct {
+	Id     interface{} `json:"id"`
+	Result *[]string   `json:"result"`
+	Error  *Err        `json:"error"`
+}
+
+type BroadcastTransactionResponse struct {
 	Id     interface{} `json:"id"`
 	Result *string     `json:"result"`
 	Error  *Err        `json:"error"`
 }
 
-type ImportAddressesResponse struct {
+type TxStatusRes struct {
+	Txid          string `json:"txid"`
+	Status        string `json:"status"`
+	BlockHash     string `json:"blockHash,omitempty"`
+	BlockHeight   int64  `json:"blockHeight,omitempty"`
+	Confirmations int64  `json:"confirmations"`
+	ReplacedBy    string `json:"replacedBy,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Hex           string `json:"hex"`
+}
+
+type GetTransactionStatusResponse struct {
 	Id     interface{}  `json:"id"`
-	Result *interface{} `json:"result"`
+	Result *TxStatusRes `json:"result"`
 	Error  *Err         `json:"error"`
 }
 
-type UtxoRes struct {
-	Address      string `json:"address"`
-	Txid         string `json:"txid"`
-	Vout         int    `json:"vout"`
-	Amount       string `json:"amount"`
-	ScriptPubKey string `json:"scriptPubKey"`
+type BumpFeeResponse struct {
+	Id     interface{}    `json:"id"`
+	Result *BumpFeeResult `json:"result"`
+	Error  *Err           `json:"error"`
 }
 
-type QueryUtxosResponse struct {
+type CpfpResponse struct {
+	Id     interface{} `json:"id"`
+	Result *CpfpResult `json:"result"`
+	Error  *Err        `json:"error"`
+}
+
+type ConsolidateResponse struct {
+	Id     interface{}        `json:"id"`
+	Result *ConsolidateResult `json:"result"`
+	Error  *Err               `json:"error"`
+}
+
+type BatchPayoutResponse struct {
+	Id     interface{}        `json:"id"`
+	Result *BatchPayoutResult `json:"result"`
+	Error  *Err               `json:"error"`
+}
+
+type DeriveAddressesResponse struct {
+	Id     interface{}       `json:"id"`
+	Result *[]DerivedAddress `json:"result"`
+	Error  *Err              `json:"error"`
+}
+
+type ImportDescriptorRes struct {
+	Descriptor string           `json:"descriptor"`
+	Addresses  []DerivedAddress `json:"addresses"`
+}
+
+type ImportDescriptorResponse struct {
+	Id     interface{}          `json:"id"`
+	Result *ImportDescriptorRes `json:"result"`
+	Error  *Err                 `json:"error"`
+}
+
+type XpubAccountRes struct {
+	Name              string `json:"name"`
+	Xpub              string `json:"xpub"`
+	ScriptType        string `json:"scriptType"`
+	ReceiveDescriptor string `json:"receiveDescriptor"`
+	ChangeDescriptor  string `json:"changeDescriptor"`
+}
+
+type AddXpubAccountResponse struct {
+	Id     interface{}     `json:"id"`
+	Result *XpubAccountRes `json:"result"`
+	Error  *Err            `json:"error"`
+}
+
+type AddressFromXpubRes struct {
+	Account   string           `json:"account"`
+	Change    bool             `json:"change"`
+	Addresses []DerivedAddress `json:"addresses"`
+	NextIndex int              `json:"nextIndex"`
+}
+
+type AddressFromXpubResponse struct {
+	Id     interface{}         `json:"id"`
+	Result *AddressFromXpubRes `json:"result"`
+	Error  *Err                `json:"error"`
+}
+
+type DecodeTransactionResponse struct {
+	Id     interface{}         `json:"id"`
+	Result *DecodedTransaction `json:"result"`
+	Error  *Err                `json:"error"`
+}
+
+type SignMessageResponse struct {
+	Id     interface{} `json:"id"`
+	Result *string     `json:"result"`
+	Error  *Err        `json:"error"`
+}
+
+type VerifyMessageResponse struct {
 	Id     interface{} `json:"id"`
-	Result *[]UtxoRes  `json:"result"`
+	Result *bool       `json:"result"`
 	Error  *Err        `json:"error"`
 }
 
@@ -142,7 +381,7 @@ func GenerateAddressController(ctx iris.Context, jsonRpcBody []byte) {
 	}
 
 	pairs := make([]AddressKeyPair, 0)
-	addresses := make([]string, 0)
+	addresses := make([]address, 0)
 	res.Result = &pairs
 	for i := uint32(0); i < count; i++ {
 		_, privHex, _, addrStr, err := BTCGenerateNewAddress()
@@ -159,10 +398,10 @@ func GenerateAddressController(ctx iris.Context, jsonRpcBody []byte) {
 		}
 		cryptedHex := hex.EncodeToString(cryptedBytes)
 		pairs = append(pairs, AddressKeyPair{Address: addrStr, PrivateKey: cryptedHex, Encrypted: true})
-		addresses = append(addresses, addrStr)
+		addresses = append(addresses, address{Address: addrStr, Purpose: AddressPurposeDeposit, Has_key: 1})
 	}
 
-	err := GlobalDBMgr.TblAddressMgr.AddNewAddresses(addresses)
+	_, _, err := GlobalDBMgr.TblAddressMgr.AddNewAddresses(addresses)
 	if err != nil {
 		res.Error = MakeError(-1, err.Error())
 		ctx.JSON(res)
@@ -173,6 +412,92 @@ func GenerateAddressController(ctx iris.Context, jsonRpcBody []byte) {
 	return
 }
 
+// ParseSigHashTypesParam accepts a sighash type name, a comma separated
+// list with one name per input, or a json array of names.
+func ParseSigHashTypesParam(param interface{}) ([]uint32, error) {
+	names := make([]string, 0)
+	typeStr := reflect.TypeOf(param).String()
+	if typeStr == "string" {
+		names = strings.Split(param.(string), ",")
+	} else if typeStr == "[]interface {}" {
+		for _, e := range param.([]interface{}) {
+			name, ok := e.(string)
+			if !ok {
+				return nil, errors.New("sighash type must be a string")
+			}
+			names = append(names, name)
+		}
+	} else {
+		return nil, errors.New("invalid sighash types")
+	}
+
+	hashTypes := make([]uint32, 0, len(names))
+	for _, name := range names {
+		hashType, err := BTCParseSigHashType(name)
+		if err != nil {
+			return nil, err
+		}
+		hashTypes = append(hashTypes, hashType)
+	}
+	return hashTypes, nil
+}
+
+func ParseUtxosParam(utxosStr string) (UTXOsDetail, error) {
+	var utxos UTXOsDetail
+	if strings.TrimSpace(utxosStr) == "" {
+		return utxos, nil
+	}
+	err := json.Unmarshal([]byte(utxosStr), &utxos)
+	if err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+// ParsePrivKeysParam decrypts a key param, either a single encrypted key
+// or an object mapping addresses to their encrypted keys. Mapped keys must
+// own their address.
+func ParsePrivKeysParam(param interface{}) ([]string, error) {
+	var privKeyEncryptHexStrs map[string]string
+	typeStr := reflect.TypeOf(param).String()
+	if typeStr == "string" {
+		privKeyEncryptHexStrs = map[string]string{"": param.(string)}
+	} else if typeStr == "map[string]interface {}" && len(param.(map[string]interface{})) > 0 {
+		privKeyEncryptHexStrs = make(map[string]string)
+		for addr, v := range param.(map[string]interface{}) {
+			privKeyEncryptHexStr, ok := v.(string)
+			if !ok || addr == "" {
+				return nil, errors.New("invalid encrypted key of " + addr)
+			}
+			privKeyEncryptHexStrs[addr] = privKeyEncryptHexStr
+		}
+	} else {
+		return nil, errors.New("encrypted key must be a string or an object")
+	}
+
+	privKeyHexStrs := make([]string, 0, len(privKeyEncryptHexStrs))
+	for addr, privKeyEncryptHexStr := range privKeyEncryptHexStrs {
+		privKeyEncryptBytes, err := hex.DecodeString(privKeyEncryptHexStr)
+		if err != nil {
+			return nil, errors.New("privKeyEncryptHexStr not hex format string")
+		}
+
+		privKeyHexStr := string(AesDecrypt(privKeyEncryptBytes, []byte(SecurityPassStr)))
+		if len(privKeyHexStr) == 0 {
+			return nil, errors.New("AesDecrypt fail")
+		}
+
+		if addr != "" {
+			owns, err := BTCKeyOwnsAddress(privKeyHexStr, addr)
+			if err != nil || !owns {
+				return nil, errors.New("key does not belong to address " + addr)
+			}
+		}
+		privKeyHexStrs = append(privKeyHexStrs, privKeyHexStr)
+	}
+	return privKeyHexStrs, nil
+}
+
 func SignTransactionController(ctx iris.Context, jsonRpcBody []byte) {
 	var req JsonRpcRequest
 	_ = json.Unmarshal(jsonRpcBody, &req)
@@ -180,13 +505,13 @@ func SignTransactionController(ctx iris.Context, jsonRpcBody []byte) {
 	var res SignTransactionResponse
 	res.Id = req.Id
 
-	if len(req.Params) != 3 {
+	if len(req.Params) != 3 && len(req.Params) != 4 {
 		res.Error = MakeError(-1, "invalid jsonrpc request params length")
 		ctx.JSON(res)
 		return
 	}
 
-	rawTrxStr, privKeyEncryptHexStr, utxosStr := "", "", ""
+	rawTrxStr, utxosStr := "", ""
 	typeStr := reflect.TypeOf(req.Params[0]).String()
 	if typeStr == "string" {
 		rawTrxStr = req.Params[0].(string)
@@ -196,11 +521,9 @@ func SignTransactionController(ctx iris.Context, jsonRpcBody []byte) {
 		return
 	}
 
-	typeStr = reflect.TypeOf(req.Params[1]).String()
-	if typeStr == "string" {
-		privKeyEncryptHexStr = req.Params[1].(string)
-	} else {
-		res.Error = MakeError(-1, "invalid jsonrpc request params[1]")
+	privKeyHexStrs, err := ParsePrivKeysParam(req.Params[1])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1], "+err.Error())
 		ctx.JSON(res)
 		return
 	}
@@ -214,35 +537,35 @@ func SignTransactionController(ctx iris.Context, jsonRpcBody []byte) {
 		return
 	}
 
-	privKeyEncryptBytes, err := hex.DecodeString(privKeyEncryptHexStr)
-	if err != nil {
-		res.Error = MakeError(-1, "invalid jsonrpc request params[1], privKeyEncryptHexStr not hex format string")
-		ctx.JSON(res)
-		return
+	var hashTypes []uint32
+	if len(req.Params) == 4 {
+		hashTypes, err = ParseSigHashTypesParam(req.Params[3])
+		if err != nil {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[3], "+err.Error())
+			ctx.JSON(res)
+			return
+		}
 	}
 
-	privKeyHexStr := string(AesDecrypt(privKeyEncryptBytes, []byte(SecurityPassStr)))
-	if len(privKeyHexStr) == 0 {
-		res.Error = MakeError(-1, "AesDecrypt fail")
+	utxos, err := ParseUtxosParam(utxosStr)
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[2], Unmarshal fail")
 		ctx.JSON(res)
 		return
 	}
 
-	var utxos UTXOsDetail
-	//err = json.Unmarshal([]byte(utxosStr), &utxos)
-	//if err != nil {
-	//	res.Error = MakeError(-1, "invalid jsonrpc request params[2], Unmarshal fail")
-	//	ctx.JSON(res)
-	//	return
-	//}
-	_ = utxosStr
-
-	trxSigStr, err := BTCSignRawTransaction(rawTrxStr, privKeyHexStr, utxos)
+	trxSigStr, report, err := BTCSignRawTransactionWithKeys(rawTrxStr, privKeyHexStrs, utxos, hashTypes...)
+	res.Verification = report
 	if err != nil {
-		res.Error = MakeError(-1, "sign raw transaction fail")
+		Error.Println("BTCSignRawTransactionWithKeys fail:", err.Error())
+		res.Error = MakeError(-1, "sign raw transaction fail: "+err.Error())
 		ctx.JSON(res)
 		return
 	}
+	err = RecordSignedTransaction(trxSigStr, report)
+	if err != nil {
+		Error.Println("RecordSignedTransaction fail:", err.Error())
+	}
 
 	res.Result = &trxSigStr
 	ctx.JSON(res)
@@ -256,7 +579,7 @@ func GenerateMultiAddressController(ctx iris.Context, jsonRpcBody []byte) {
 	var res GenerateMultiAddressResponse
 	res.Id = req.Id
 
-	if len(req.Params) != 2 {
+	if len(req.Params) != 2 && len(req.Params) != 3 {
 		res.Error = MakeError(-1, "invalid jsonrpc request params length")
 		ctx.JSON(res)
 		return
@@ -325,6 +648,28 @@ func GenerateMultiAddressController(ctx iris.Context, jsonRpcBody []byte) {
 		return
 	}
 
+	// params[2] optionally stores the address so that the scanner tracks
+	// its outputs
+	track := false
+	if len(req.Params) == 3 {
+		var ok bool
+		track, ok = req.Params[2].(bool)
+		if !ok {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[2]")
+			ctx.JSON(res)
+			return
+		}
+	}
+	if track {
+		_, _, err = GlobalDBMgr.TblAddressMgr.AddNewAddresses([]address{{Address: multiSigAddr,
+			Purpose: AddressPurposeMultisig, Redeem_script: redeemScript}})
+		if err != nil {
+			res.Error = MakeError(-1, err.Error())
+			ctx.JSON(res)
+			return
+		}
+	}
+
 	res.Result = new(MultiSigAddressRes)
 	res.Result.RedeemScript = redeemScript
 	res.Result.MultiSigAddress = multiSigAddr
@@ -340,7 +685,7 @@ func MultiSignTransactionController(ctx iris.Context, jsonRpcBody []byte) {
 	var res MultiSignTransactionResponse
 	res.Id = req.Id
 
-	if len(req.Params) != 4 {
+	if len(req.Params) != 4 && len(req.Params) != 5 {
 		res.Error = MakeError(-1, "invalid jsonrpc request params length")
 		ctx.JSON(res)
 		return
@@ -383,6 +728,17 @@ func MultiSignTransactionController(ctx iris.Context, jsonRpcBody []byte) {
 		return
 	}
 
+	var hashTypes []uint32
+	if len(req.Params) == 5 {
+		var err error
+		hashTypes, err = ParseSigHashTypesParam(req.Params[4])
+		if err != nil {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[4], "+err.Error())
+			ctx.JSON(res)
+			return
+		}
+	}
+
 	privKeyHexStrList := make([]string, 0)
 	privKeyHexStrSet := make(map[string]struct{})
 	l := strings.Split(multiPrivKeyEncryptHexStr, ",")
@@ -411,19 +767,18 @@ func MultiSignTransactionController(ctx iris.Context, jsonRpcBody []byte) {
 		return
 	}
 
-	var utxos UTXOsDetail
-	//err := json.Unmarshal([]byte(utxosStr), &utxos)
-	//if err != nil {
-	//	res.Error = MakeError(-1, "invalid jsonrpc request params[3], Unmarshal fail")
-	//	ctx.JSON(res)
-	//	return
-	//}
-	_ = utxosStr
+	utxos, err := ParseUtxosParam(utxosStr)
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[3], Unmarshal fail")
+		ctx.JSON(res)
+		return
+	}
 
 	trxSigStrList := make([]string, 0)
 	for _, key := range privKeyHexStrList {
-		trxSigStr, err := BTCMultiSignRawTransaction(rawTrxStr, redeemScriptStr, key, utxos)
+		trxSigStr, report, err := BTCMultiSignRawTransaction(rawTrxStr, redeemScriptStr, key, utxos, hashTypes...)
 		if err != nil {
+			res.Verification = report
 			res.Error = MakeError(-1, fmt.Sprintf("multi sign raw transaction fail: %s", err.Error()))
 			ctx.JSON(res)
 			return
@@ -444,7 +799,7 @@ func MultiSignTransactionController(ctx iris.Context, jsonRpcBody []byte) {
 	}
 
 	if rpcResponse.Error != nil {
-		Error.Println("combinerawtransaction rpcResponse: %s", rpcResponse.Error.Error())
+		Error.Printf("combinerawtransaction rpcResponse: %s", rpcResponse.Error.Error())
 		res.Error = MakeError(-1, "rpc combinerawtransaction fail: rpcResponse.Error not nil")
 		ctx.JSON(res)
 		return
@@ -460,24 +815,49 @@ func MultiSignTransactionController(ctx iris.Context, jsonRpcBody []byte) {
 	trxSigStr := rpcResponse.Result.(string)
 	Info.Println("rawTrxCombinedStr:", trxSigStr)
 
-	// set trx utxos state to pending
-	trx, err := BTCUnPackRawTransaction(rawTrxStr)
+	report, err := BTCVerifyMultiSignTransaction(trxSigStr, redeemScriptStr, utxos)
+	if err != nil {
+		res.Error = MakeError(-1, "verify combined transaction fail: "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+	res.Verification = report
+	err = VerificationError(report)
+	if err != nil {
+		res.Error = MakeError(-1, "verify combined transaction fail: "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	// reserve trx utxos for the combined transaction
+	trx, err := BTCUnPackRawTransaction(trxSigStr)
+	if err != nil {
+		Error.Println("BTCUnPackRawTransaction trxSig fail:", err.Error())
+		res.Error = MakeError(-1, "BTCUnPackRawTransaction trxSig fail")
+		ctx.JSON(res)
+		return
+	}
+	pendingTxId, err := trx.CalcTrxId()
 	if err != nil {
-		Error.Println("BTCUnPackRawTransaction rawTrx fail:", err.Error())
-		res.Error = MakeError(-1, "BTCUnPackRawTransaction rawTrx fail")
+		res.Error = MakeError(-1, "CalcTrxId fail")
 		ctx.JSON(res)
 		return
 	}
+	outPoints := make([]OutPoint, 0, len(trx.Vin))
 	for _, vin := range trx.Vin {
-		txId := vin.PrevOut.Hash.GetHex()
-		vout := vin.PrevOut.N
-		err = GlobalDBMgr.TblUtxoMgr.UpdateUtxoPendingState(txId, int(vout), 1)
-		if err != nil {
-			Error.Printf("UpdateUtxoPendingState [%s/%d] fail: %s", txId, int(vout), err.Error())
-			res.Error = MakeError(-1, "UpdateUtxoPendingState fail")
-			ctx.JSON(res)
-			return
-		}
+		outPoints = append(outPoints, OutPoint{Txid: vin.PrevOut.Hash.GetHex(), Vout: int(vin.PrevOut.N)})
+	}
+	expireAt := time.Now().Add(GlobalConfig.UtxoConfig.PendingExpiry())
+	err = GlobalDBMgr.TblUtxoMgr.ReserveUtxos(outPoints, pendingTxId.GetHex(), expireAt, "")
+	if err != nil {
+		Error.Printf("ReserveUtxos %s fail: %s", pendingTxId.GetHex(), err.Error())
+		res.Error = MakeError(-1, "ReserveUtxo fail")
+		ctx.JSON(res)
+		return
+	}
+	err = RecordSignedTransaction(trxSigStr, report)
+	if err != nil {
+		Error.Println("RecordSignedTransaction fail:", err.Error())
 	}
 
 	res.Result = &trxSigStr
@@ -485,6 +865,29 @@ func MultiSignTransactionController(ctx iris.Context, jsonRpcBody []byte) {
 	return
 }
 
+// ParseImportAddressesParams accepts the addresses as string params or as
+// one json array of strings, and checks that they are addresses of the
+// configured network.
+func ParseImportAddressesParams(params []interface{}) ([]string, error) {
+	if len(params) == 1 {
+		if list, ok := params[0].([]interface{}); ok {
+			params = list
+		}
+	}
+	addrs := make([]string, 0, len(params))
+	for i, param := range params {
+		addr, ok := param.(string)
+		if !ok {
+			return nil, fmt.Errorf("address %d must be a string", i)
+		}
+		if _, err := BTCScriptPubKeyFromAddress(addr); err != nil {
+			return nil, fmt.Errorf("invalid address %d %s: %s", i, addr, err.Error())
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
 func ImportAddressesController(ctx iris.Context, jsonRpcBody []byte) {
 	var req JsonRpcRequest
 	_ = json.Unmarshal(jsonRpcBody, &req)
@@ -492,38 +895,50 @@ func ImportAddressesController(ctx iris.Context, jsonRpcBody []byte) {
 	var res ImportAddressesResponse
 	res.Id = req.Id
 
-	addresses := make([]string, 0)
-	for _, param := range req.Params {
-		typeStr := reflect.TypeOf(param).String()
-		if typeStr == "string" {
-			addresses = append(addresses, param.(string))
-		} else {
-			res.Error = MakeError(-1, "invalid jsonrpc request params")
-			ctx.JSON(res)
-			return
-		}
+	addrs, err := ParseImportAddressesParams(req.Params)
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params, "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+	addresses := make([]address, 0, len(addrs))
+	for _, addr := range addrs {
+		addresses = append(addresses, address{Address: addr})
 	}
 
-	err := GlobalDBMgr.TblAddressMgr.AddNewAddresses(addresses)
+	added, existing, err := GlobalDBMgr.TblAddressMgr.AddNewAddresses(addresses)
 	if err != nil {
 		res.Error = MakeError(-1, err.Error())
 		ctx.JSON(res)
 		return
 	}
 
-	res.Result = nil
+	res.Result = &ImportAddressesRes{Added: added, Existing: existing}
 	ctx.JSON(res)
 	return
 }
 
-func QueryUtxosController(ctx iris.Context, jsonRpcBody []byte) {
+func addressInfo(a address) AddressInfoRes {
+	info := AddressInfoRes{Address: a.Address, Label: a.Label, Account: a.Account, Purpose: a.Purpose,
+		ScriptType: a.Script_type, Descriptor: a.Descriptor, DerivePath: a.Derive_path,
+		RedeemScript: a.Redeem_script, HasKey: a.Has_key == 1, CreatedAt: a.Created_at.Unix()}
+	if a.Descriptor != "" {
+		index := a.Derive_index
+		info.DeriveIndex = &index
+	}
+	return info
+}
+
+// SetAddressLabelController sets the label of a stored address and, from the
+// optional options object, its account and purpose.
+func SetAddressLabelController(ctx iris.Context, jsonRpcBody []byte) {
 	var req JsonRpcRequest
 	_ = json.Unmarshal(jsonRpcBody, &req)
 
-	var res QueryUtxosResponse
+	var res SetAddressLabelResponse
 	res.Id = req.Id
 
-	if len(req.Params) != 1 {
+	if len(req.Params) != 2 && len(req.Params) != 3 {
 		res.Error = MakeError(-1, "invalid jsonrpc request params length")
 		ctx.JSON(res)
 		return
@@ -539,25 +954,1653 @@ func QueryUtxosController(ctx iris.Context, jsonRpcBody []byte) {
 		return
 	}
 
-	utxos, err := GlobalDBMgr.TblUtxoMgr.ListAddrUtxos(addr)
+	cols := make(map[string]interface{})
+	typeStr = reflect.TypeOf(req.Params[1]).String()
+	if typeStr == "string" && len(req.Params[1].(string)) <= 256 {
+		cols["label"] = req.Params[1].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1]")
+		ctx.JSON(res)
+		return
+	}
+
+	if len(req.Params) == 3 {
+		optsMap, ok := req.Params[2].(map[string]interface{})
+		if !ok {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[2], options must be an object")
+			ctx.JSON(res)
+			return
+		}
+		for key, value := range optsMap {
+			valueStr, ok := value.(string)
+			if !ok {
+				res.Error = MakeError(-1, "invalid jsonrpc request params[2], "+key+" must be a string")
+				ctx.JSON(res)
+				return
+			}
+			if key == "account" && len(valueStr) <= 128 {
+				cols["account"] = valueStr
+			} else if key == "purpose" && (valueStr == "" || IsAddressPurpose(valueStr)) {
+				cols["purpose"] = valueStr
+			} else {
+				res.Error = MakeError(-1, "invalid jsonrpc request params[2], invalid option "+key)
+				ctx.JSON(res)
+				return
+			}
+		}
+	}
+
+	exist, err := GlobalDBMgr.TblAddressMgr.SetMetadata(addr, cols)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+	if !exist {
+		res.Error = MakeError(-1, "address not found")
+		ctx.JSON(res)
+		return
+	}
+
+	stored, _, err := GlobalDBMgr.TblAddressMgr.GetAddress(addr)
 	if err != nil {
 		res.Error = MakeError(-1, err.Error())
 		ctx.JSON(res)
 		return
 	}
+	info := addressInfo(stored)
+	res.Result = &info
+	ctx.JSON(res)
+	return
+}
 
-	utxosRes := make([]UtxoRes, 0)
-	for _, utxo := range utxos {
-		amountFloat, _ := strconv.ParseFloat(utxo.Amount, 64)
-		utxoRes := UtxoRes{Txid: utxo.Txid,
-			Address:      utxo.Address,
-			Amount:       fmt.Sprintf("%.08f", amountFloat),
-			ScriptPubKey: utxo.Scriptpubkey,
-			Vout:         utxo.Vout}
-		utxosRes = append(utxosRes, utxoRes)
+func GetAddressInfoController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res GetAddressInfoResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 1 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	addr := ""
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" {
+		addr = req.Params[0].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+		ctx.JSON(res)
+		return
+	}
+
+	stored, exist, err := GlobalDBMgr.TblAddressMgr.GetAddress(addr)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+	if !exist {
+		res.Error = MakeError(-1, "address not found")
+		ctx.JSON(res)
+		return
+	}
+	info := addressInfo(stored)
+	res.Result = &info
+	ctx.JSON(res)
+	return
+}
+
+const (
+	ListAddressesDefaultLimit = 100
+	ListAddressesMaxLimit     = 1000
+)
+
+// ParseListAddressesOptionsParam reads the filters and the page of
+// list_addresses.
+func ParseListAddressesOptionsParam(param interface{}) (AddressFilter, int, int, error) {
+	var filter AddressFilter
+	cursor, limit := 0, ListAddressesDefaultLimit
+	optsMap, ok := param.(map[string]interface{})
+	if !ok {
+		return filter, 0, 0, errors.New("options must be an object")
+	}
+	for key, value := range optsMap {
+		switch key {
+		case "label", "account", "purpose", "scriptType":
+			valueStr, ok := value.(string)
+			if !ok {
+				return filter, 0, 0, errors.New(key + " must be a string")
+			}
+			if key == "label" {
+				filter.Label = valueStr
+			} else if key == "account" {
+				filter.Account = valueStr
+			} else if key == "purpose" {
+				filter.Purpose = valueStr
+			} else {
+				filter.Script_type = valueStr
+			}
+		case "hasKey":
+			hasKey, ok := value.(bool)
+			if !ok {
+				return filter, 0, 0, errors.New("hasKey must be a bool")
+			}
+			filter.Has_key = "0"
+			if hasKey {
+				filter.Has_key = "1"
+			}
+		case "limit", "cursor":
+			n, ok := value.(float64)
+			if !ok || n < 0 || n != float64(int(n)) {
+				return filter, 0, 0, errors.New(key + " must be a non negative integer")
+			}
+			if key == "cursor" {
+				cursor = int(n)
+			} else if n < 1 || n > ListAddressesMaxLimit {
+				return filter, 0, 0, fmt.Errorf("limit must be between 1 and %d", ListAddressesMaxLimit)
+			} else {
+				limit = int(n)
+			}
+		default:
+			return filter, 0, 0, errors.New("unknown option " + key)
+		}
+	}
+	return filter, cursor, limit, nil
+}
+
+// ListAddressesController lists the stored addresses matching the optional
+// filters a page at a time.
+func ListAddressesController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res ListAddressesResponse
+	res.Id = req.Id
+
+	if len(req.Params) > 1 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	var filter AddressFilter
+	cursor, limit := 0, ListAddressesDefaultLimit
+	if len(req.Params) == 1 {
+		var err error
+		filter, cursor, limit, err = ParseListAddressesOptionsParam(req.Params[0])
+		if err != nil {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[0], "+err.Error())
+			ctx.JSON(res)
+			return
+		}
+	}
+
+	addresses, err := GlobalDBMgr.TblAddressMgr.ListAddressesPage(filter, cursor, limit)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	result := ListAddressesRes{Addresses: make([]AddressInfoRes, 0, len(addresses))}
+	for _, a := range addresses {
+		result.Addresses = append(result.Addresses, addressInfo(a))
+	}
+	if len(addresses) == limit {
+		result.Cursor = addresses[len(addresses)-1].Id
+	}
+	res.Result = &result
+	ctx.JSON(res)
+	return
+}
+
+// GetBalanceController returns the balance of the whole wallet, or of the
+// address or account given in the optional options object.
+func GetBalanceController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res GetBalanceResponse
+	res.Id = req.Id
+
+	if len(req.Params) > 1 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	addr, account := "", ""
+	if len(req.Params) == 1 {
+		optsMap, ok := req.Params[0].(map[string]interface{})
+		if !ok {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[0], options must be an object")
+			ctx.JSON(res)
+			return
+		}
+		for key, value := range optsMap {
+			valueStr, ok := value.(string)
+			if !ok || (key != "address" && key != "account") {
+				res.Error = MakeError(-1, "invalid jsonrpc request params[0], invalid option "+key)
+				ctx.JSON(res)
+				return
+			}
+			if key == "address" {
+				addr = valueStr
+			} else {
+				account = valueStr
+			}
+		}
+		if addr != "" && account != "" {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[0], address and account are exclusive")
+			ctx.JSON(res)
+			return
+		}
+	}
+
+	balance, err := GlobalDBMgr.TblUtxoMgr.GetBalance(addr, account)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = &BalanceRes{Address: addr, Account: account, Utxos: balance.Utxos, Confirmed: balance.Confirmed,
+		Unconfirmed: balance.Unconfirmed, Pending: balance.Pending, Total: balance.Total}
+	ctx.JSON(res)
+	return
+}
+
+const (
+	QueryUtxosDefaultLimit = 100
+	QueryUtxosMaxLimit     = 1000
+	QueryUtxosMaxAddresses = 1000
+)
+
+// ParseQueryUtxosOptionsParam reads the filters, order and page of
+// query_utxos called with an options object.
+func ParseQueryUtxosOptionsParam(param interface{}) (UtxoQuery, error) {
+	q := UtxoQuery{MinDepositConf: GlobalConfig.UtxoConfig.MinDepositConfirmations,
+		MinChangeConf: GlobalConfig.UtxoConfig.MinChangeConfirmations, MaxConf: -1, Limit: QueryUtxosDefaultLimit}
+	optsMap, ok := param.(map[string]interface{})
+	if !ok {
+		return q, errors.New("options must be an object")
+	}
+	for key, value := range optsMap {
+		switch key {
+		case "addresses":
+			addrs, ok := value.([]interface{})
+			if !ok || len(addrs) > QueryUtxosMaxAddresses {
+				return q, fmt.Errorf("addresses must be an array of at most %d addresses", QueryUtxosMaxAddresses)
+			}
+			for _, addr := range addrs {
+				addrStr, ok := addr.(string)
+				if !ok {
+					return q, errors.New("addresses must be strings")
+				}
+				q.Addresses = append(q.Addresses, addrStr)
+			}
+		case "account", "cursor":
+			valueStr, ok := value.(string)
+			if !ok {
+				return q, errors.New(key + " must be a string")
+			}
+			if key == "account" {
+				q.Account = valueStr
+			} else {
+				q.Cursor = valueStr
+			}
+		case "minAmount", "maxAmount":
+			amount, err := ParseSatoshi(value)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s, %s", key, err.Error())
+			}
+			if key == "minAmount" {
+				q.MinAmount = amount
+			} else {
+				q.MaxAmount = amount
+			}
+		case "minConf", "maxConf", "limit":
+			n, ok := value.(float64)
+			if !ok || n < 0 || n != float64(int64(n)) {
+				return q, errors.New(key + " must be a non negative integer")
+			}
+			if key == "minConf" {
+				// overrides both configured thresholds
+				q.MinDepositConf, q.MinChangeConf = int64(n), int64(n)
+			} else if key == "maxConf" {
+				q.MaxConf = int64(n)
+			} else if n < 1 || n > QueryUtxosMaxLimit {
+				return q, fmt.Errorf("limit must be between 1 and %d", QueryUtxosMaxLimit)
+			} else {
+				q.Limit = int(n)
+			}
+		case "includePending":
+			includePending, ok := value.(bool)
+			if !ok {
+				return q, errors.New("includePending must be a bool")
+			}
+			q.IncludePending = includePending
+		case "sort":
+			if value != "age" && value != "amount" {
+				return q, errors.New("sort must be age or amount")
+			}
+			q.SortByAmount = value == "amount"
+		case "order":
+			if value != "asc" && value != "desc" {
+				return q, errors.New("order must be asc or desc")
+			}
+			q.Desc = value == "desc"
+		default:
+			return q, errors.New("unknown option " + key)
+		}
+	}
+	if len(q.Addresses) > 0 && q.Account != "" {
+		return q, errors.New("addresses and account are exclusive")
+	}
+	if q.MaxAmount > 0 && q.MaxAmount < q.MinAmount {
+		return q, errors.New("maxAmount below minAmount")
+	}
+	return q, nil
+}
+
+const (
+	ListTransactionsDefaultLimit = 100
+	ListTransactionsMaxLimit     = 1000
+)
+
+// ParseListTransactionsOptionsParam reads the address or account, the block
+// time window and the page of list_transactions.
+func ParseListTransactionsOptionsParam(param interface{}) (LedgerQuery, error) {
+	q := LedgerQuery{Limit: ListTransactionsDefaultLimit}
+	optsMap, ok := param.(map[string]interface{})
+	if !ok {
+		return q, errors.New("options must be an object")
+	}
+	for key, value := range optsMap {
+		switch key {
+		case "address", "account", "cursor":
+			valueStr, ok := value.(string)
+			if !ok {
+				return q, errors.New(key + " must be a string")
+			}
+			if key == "address" {
+				q.Address = valueStr
+			} else if key == "account" {
+				q.Account = valueStr
+			} else {
+				q.Cursor = valueStr
+			}
+		case "since", "until", "limit":
+			n, ok := value.(float64)
+			if !ok || n < 0 || n != float64(int64(n)) {
+				return q, errors.New(key + " must be a non negative integer")
+			}
+			if key == "since" {
+				q.Since = int64(n)
+			} else if key == "until" {
+				q.Until = int64(n)
+			} else if n < 1 || n > ListTransactionsMaxLimit {
+				return q, fmt.Errorf("limit must be between 1 and %d", ListTransactionsMaxLimit)
+			} else {
+				q.Limit = int(n)
+			}
+		default:
+			return q, errors.New("unknown option " + key)
+		}
+	}
+	if (q.Address == "") == (q.Account == "") {
+		return q, errors.New("either address or account is required")
+	}
+	return q, nil
+}
+
+// ListTransactionsController lists the transactions that received or spent
+// outputs of an address or account, newest first, with their net amount.
+func ListTransactionsController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res ListTransactionsResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 1 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	q, err := ParseListTransactionsOptionsParam(req.Params[0])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	tipHeight, err := GlobalDBMgr.TblSyncStateMgr.GetTipHeight("BTC")
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	txs, cursor, err := GlobalDBMgr.TblLedgerMgr.ListTransactions(q)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	result := ListTransactionsRes{Transactions: make([]LedgerTxRes, 0, len(txs)), Cursor: cursor}
+	for _, t := range txs {
+		txRes := LedgerTxRes{Txid: t.Txid, Received: t.Received, Sent: t.Sent, Net: t.Net, Fee: t.Fee,
+			BlockHash: t.Block_hash, BlockHeight: t.Block_height, BlockTime: t.Block_time,
+			Confirmations: utxo{Block_height: t.Block_height}.Confirmations(tipHeight),
+			Entries:       make([]LedgerEntryRes, 0, len(t.Entries))}
+		for _, e := range t.Entries {
+			txRes.Entries = append(txRes.Entries, LedgerEntryRes{Type: e.Entry_type, Address: e.Address,
+				Txid: e.Utxo_txid, Vout: e.Utxo_vout, Amount: Satoshi(e.Amount)})
+		}
+		result.Transactions = append(result.Transactions, txRes)
+	}
+	res.Result = &result
+	ctx.JSON(res)
+	return
+}
+
+// QueryUtxosController lists unspent outputs. It takes an address or an
+// array of addresses with optional minimum confirmations, returning all
+// matching outputs, or an options object selecting a page of them.
+func QueryUtxosController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res QueryUtxosResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 1 && len(req.Params) != 2 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	// an explicit minimum confirmations overrides both configured thresholds
+	q := UtxoQuery{MinDepositConf: GlobalConfig.UtxoConfig.MinDepositConfirmations,
+		MinChangeConf: GlobalConfig.UtxoConfig.MinChangeConfirmations, MaxConf: -1}
+	withOptions := false
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" {
+		q.Addresses = []string{req.Params[0].(string)}
+	} else if typeStr == "[]interface {}" && len(req.Params[0].([]interface{})) <= QueryUtxosMaxAddresses {
+		for _, addr := range req.Params[0].([]interface{}) {
+			addrStr, ok := addr.(string)
+			if !ok {
+				res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+				ctx.JSON(res)
+				return
+			}
+			q.Addresses = append(q.Addresses, addrStr)
+		}
+		if len(q.Addresses) == 0 {
+			res.Result = &[]UtxoRes{}
+			ctx.JSON(res)
+			return
+		}
+	} else if typeStr == "map[string]interface {}" && len(req.Params) == 1 {
+		var err error
+		withOptions = true
+		q, err = ParseQueryUtxosOptionsParam(req.Params[0])
+		if err != nil {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[0], "+err.Error())
+			ctx.JSON(res)
+			return
+		}
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+		ctx.JSON(res)
+		return
+	}
+
+	if len(req.Params) == 2 {
+		minConf, ok := req.Params[1].(float64)
+		if !ok || minConf < 0 || minConf != float64(int64(minConf)) {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[1]")
+			ctx.JSON(res)
+			return
+		}
+		q.MinDepositConf, q.MinChangeConf = int64(minConf), int64(minConf)
+	}
+
+	tipHeight, err := ConfirmationTipHeight()
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	utxos, cursor, err := GlobalDBMgr.TblUtxoMgr.QueryUtxos(q, tipHeight)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	utxosRes := make([]UtxoRes, 0)
+	for _, utxo := range utxos {
+		utxoRes := UtxoRes{Txid: utxo.Txid,
+			Address:       utxo.Address,
+			Amount:        utxo.Amount,
+			ScriptPubKey:  utxo.Scriptpubkey,
+			Vout:          utxo.Vout,
+			Confirmations: utxo.Confirmations(tipHeight),
+			IsChange:      utxo.Is_change == 1,
+			Pending:       utxo.Pending == 1,
+			PendingTxid:   utxo.Pending_txid,
+			CoinSymbol:    utxo.Coin_symbol}
+		utxosRes = append(utxosRes, utxoRes)
+	}
+
+	if withOptions {
+		res.Result = &QueryUtxosRes{Utxos: utxosRes, Cursor: cursor}
+	} else {
+		res.Result = &utxosRes
+	}
+	ctx.JSON(res)
+	return
+}
+
+func ListPendingController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res ListPendingResponse
+	res.Id = req.Id
+
+	if len(req.Params) > 1 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	addr := ""
+	if len(req.Params) == 1 {
+		typeStr := reflect.TypeOf(req.Params[0]).String()
+		if typeStr == "string" {
+			addr = req.Params[0].(string)
+		} else {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+			ctx.JSON(res)
+			return
+		}
+	}
+
+	utxos, err := GlobalDBMgr.TblUtxoMgr.ListPendingUtxos(addr)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	now := time.Now()
+	pendingRes := make([]PendingUtxoRes, 0)
+	for _, utxo := range utxos {
+		pending := PendingUtxoRes{Txid: utxo.Txid,
+			Address:     utxo.Address,
+			Amount:      utxo.Amount,
+			Vout:        utxo.Vout,
+			PendingTxid: utxo.Pending_txid,
+			Expired:     !utxo.Pending_expire_at.After(now)}
+		if !utxo.Pending_expire_at.IsZero() {
+			pending.ExpireAt = utxo.Pending_expire_at.Unix()
+		}
+		pendingRes = append(pendingRes, pending)
+	}
+
+	res.Result = &pendingRes
+	ctx.JSON(res)
+	return
+}
+
+// ReleaseUtxosController clears reservations regardless of their expiry.
+// Each param is either an outpoint "txid:vout" or the txid of a reserving
+// transaction, releasing all outputs reserved by it.
+func ReleaseUtxosController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res ReleaseUtxosResponse
+	res.Id = req.Id
+
+	if len(req.Params) == 0 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	outPoints := make(map[string]bool)
+	pendingTxIds := make(map[string]bool)
+	for i, param := range req.Params {
+		paramStr, ok := param.(string)
+		if !ok || paramStr == "" {
+			res.Error = MakeError(-1, fmt.Sprintf("invalid jsonrpc request params[%d]", i))
+			ctx.JSON(res)
+			return
+		}
+		if strings.Contains(paramStr, ":") {
+			_, _, err := ParseOutPoint(paramStr)
+			if err != nil {
+				res.Error = MakeError(-1, fmt.Sprintf("invalid jsonrpc request params[%d]", i))
+				ctx.JSON(res)
+				return
+			}
+			outPoints[paramStr] = true
+		} else {
+			pendingTxIds[paramStr] = true
+		}
+	}
+
+	utxos, err := GlobalDBMgr.TblUtxoMgr.ListPendingUtxos("")
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	released := make([]string, 0)
+	for _, utxo := range utxos {
+		outPoint := fmt.Sprintf("%s:%d", utxo.Txid, utxo.Vout)
+		if !outPoints[outPoint] && !pendingTxIds[utxo.Pending_txid] {
+			continue
+		}
+		ok, err := GlobalDBMgr.TblUtxoMgr.ReleaseUtxo(utxo.Txid, utxo.Vout)
+		if err != nil {
+			res.Error = MakeError(-1, err.Error())
+			ctx.JSON(res)
+			return
+		}
+		if ok {
+			Info.Printf("utxo %s released manually", outPoint)
+			released = append(released, outPoint)
+		}
+	}
+
+	res.Result = &released
+	ctx.JSON(res)
+	return
+}
+
+func BroadcastTransactionController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res BroadcastTransactionResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 1 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	rawTrxStr := ""
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" {
+		rawTrxStr = req.Params[0].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+		ctx.JSON(res)
+		return
+	}
+
+	Info.Println(fmt.Sprintf("rpcClient to [%s] for broadcasting raw transaction", GlobalConfig.ServerUrl))
+	txId, err := BroadcastTransaction(NewRpcChainNode(GlobalConfig.ServerUrl), rawTrxStr)
+	if err != nil {
+		res.Error = MakeError(-1, "broadcast transaction fail: "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = &txId
+	ctx.JSON(res)
+	return
+}
+
+func GetTransactionStatusController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res GetTransactionStatusResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 1 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	txId := ""
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" {
+		txId = req.Params[0].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+		ctx.JSON(res)
+		return
+	}
+
+	record, exist, err := GlobalDBMgr.TblTxMgr.GetTx(txId)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+	if !exist {
+		res.Error = MakeError(-1, "transaction not found")
+		ctx.JSON(res)
+		return
+	}
+
+	statusRes := TxStatusRes{Txid: record.Txid,
+		Status:     record.Status,
+		ReplacedBy: record.Replaced_by,
+		Error:      record.Error,
+		Hex:        record.Raw}
+	if record.Status == TxStatusConfirmed {
+		tipHeight, err := GlobalDBMgr.TblSyncStateMgr.GetTipHeight("BTC")
+		if err != nil {
+			res.Error = MakeError(-1, err.Error())
+			ctx.JSON(res)
+			return
+		}
+		statusRes.BlockHash = record.Block_hash
+		statusRes.BlockHeight = record.Block_height
+		statusRes.Confirmations = tipHeight - record.Block_height + 1
+	}
+
+	res.Result = &statusRes
+	ctx.JSON(res)
+	return
+}
+
+// ParseFeeRateParam accepts a positive fee rate in sat/vB.
+func ParseFeeRateParam(param interface{}) (float64, error) {
+	feeRate, ok := param.(float64)
+	if !ok || feeRate < MinRelayFeeRate {
+		return 0, fmt.Errorf("fee rate must be a number of at least %v sat/vB", MinRelayFeeRate)
+	}
+	return feeRate, nil
+}
+
+func BumpFeeController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res BumpFeeResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 3 && len(req.Params) != 4 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	txId, changeAddress := "", ""
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" {
+		txId = req.Params[0].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+		ctx.JSON(res)
+		return
+	}
+
+	feeRate, err := ParseFeeRateParam(req.Params[1])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	privKeyHexStrs, err := ParsePrivKeysParam(req.Params[2])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[2], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	if len(req.Params) == 4 {
+		typeStr = reflect.TypeOf(req.Params[3]).String()
+		if typeStr == "string" {
+			changeAddress = req.Params[3].(string)
+		} else {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[3]")
+			ctx.JSON(res)
+			return
+		}
+	}
+
+	result, err := BTCBumpFee(txId, feeRate, privKeyHexStrs, changeAddress)
+	if err != nil {
+		Error.Println("BTCBumpFee fail:", err.Error())
+		res.Error = MakeError(-1, "bump fee fail: "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = result
+	ctx.JSON(res)
+	return
+}
+
+// ParseCpfpOptionsParam reads the optional {"vout", "parentVSize",
+// "parentFee", "address"} object of cpfp.
+func ParseCpfpOptionsParam(param interface{}) (CpfpOptions, error) {
+	opts := CpfpOptions{Vout: -1}
+	optsMap, ok := param.(map[string]interface{})
+	if !ok {
+		return opts, errors.New("options must be an object")
+	}
+	for key, value := range optsMap {
+		if key == "address" {
+			addr, ok := value.(string)
+			if !ok {
+				return opts, errors.New("address must be a string")
+			}
+			opts.Address = addr
+			continue
+		}
+		number, ok := value.(float64)
+		if !ok || number < 0 || number != float64(int64(number)) {
+			return opts, errors.New(key + " must be a non-negative integer")
+		}
+		switch key {
+		case "vout":
+			opts.Vout = int(number)
+		case "parentVSize":
+			opts.ParentVSize = int64(number)
+		case "parentFee":
+			opts.ParentFee = int64(number)
+		default:
+			return opts, errors.New("unknown option " + key)
+		}
+	}
+	return opts, nil
+}
+
+func CpfpController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res CpfpResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 3 && len(req.Params) != 4 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	parentTxId := ""
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" {
+		parentTxId = req.Params[0].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+		ctx.JSON(res)
+		return
+	}
+
+	feeRate, err := ParseFeeRateParam(req.Params[1])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	privKeyHexStrs, err := ParsePrivKeysParam(req.Params[2])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[2], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	opts := CpfpOptions{Vout: -1}
+	if len(req.Params) == 4 {
+		opts, err = ParseCpfpOptionsParam(req.Params[3])
+		if err != nil {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[3], "+err.Error())
+			ctx.JSON(res)
+			return
+		}
+	}
+
+	result, err := BTCCpfp(NewRpcChainNode(GlobalConfig.ServerUrl), parentTxId, feeRate, privKeyHexStrs, opts)
+	if err != nil {
+		Error.Println("BTCCpfp fail:", err.Error())
+		res.Error = MakeError(-1, "cpfp fail: "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = result
+	ctx.JSON(res)
+	return
+}
+
+// ParseConsolidateOptionsParam reads the optional {"maxAmount",
+// "addresses"} object of consolidate.
+func ParseConsolidateOptionsParam(param interface{}) (ConsolidateOptions, error) {
+	var opts ConsolidateOptions
+	optsMap, ok := param.(map[string]interface{})
+	if !ok {
+		return opts, errors.New("options must be an object")
+	}
+	for key, value := range optsMap {
+		switch key {
+		case "maxAmount":
+			maxAmount, err := ParseSatoshi(value)
+			if err != nil {
+				return opts, errors.New("invalid maxAmount, " + err.Error())
+			}
+			opts.MaxAmount = maxAmount
+		case "addresses":
+			addrs, ok := value.([]interface{})
+			if !ok {
+				return opts, errors.New("addresses must be an array")
+			}
+			for _, addr := range addrs {
+				addrStr, ok := addr.(string)
+				if !ok {
+					return opts, errors.New("addresses must be strings")
+				}
+				opts.Addresses = append(opts.Addresses, addrStr)
+			}
+		default:
+			return opts, errors.New("unknown option " + key)
+		}
+	}
+	return opts, nil
+}
+
+func ConsolidateController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res ConsolidateResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 3 && len(req.Params) != 4 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	maxFeeRate, err := ParseFeeRateParam(req.Params[0])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	targetAddress := ""
+	typeStr := reflect.TypeOf(req.Params[1]).String()
+	if typeStr == "string" {
+		targetAddress = req.Params[1].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1]")
+		ctx.JSON(res)
+		return
+	}
+
+	privKeyHexStrs, err := ParsePrivKeysParam(req.Params[2])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[2], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	var opts ConsolidateOptions
+	if len(req.Params) == 4 {
+		opts, err = ParseConsolidateOptionsParam(req.Params[3])
+		if err != nil {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[3], "+err.Error())
+			ctx.JSON(res)
+			return
+		}
+	}
+
+	result, err := BTCConsolidate(NewRpcChainNode(GlobalConfig.ServerUrl), maxFeeRate, targetAddress, privKeyHexStrs, opts)
+	if err != nil {
+		Error.Println("BTCConsolidate fail:", err.Error())
+		res.Error = MakeError(-1, "consolidate fail: "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = result
+	ctx.JSON(res)
+	return
+}
+
+func SweepAddressController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res ConsolidateResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 4 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	addr, targetAddress := "", ""
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" {
+		addr = req.Params[0].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+		ctx.JSON(res)
+		return
+	}
+
+	typeStr = reflect.TypeOf(req.Params[1]).String()
+	if typeStr == "string" {
+		targetAddress = req.Params[1].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1]")
+		ctx.JSON(res)
+		return
+	}
+
+	feeRate, err := ParseFeeRateParam(req.Params[2])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[2], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	privKeyHexStrs, err := ParsePrivKeysParam(req.Params[3])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[3], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	result, err := BTCSweepAddress(addr, targetAddress, feeRate, privKeyHexStrs)
+	if err != nil {
+		Error.Println("BTCSweepAddress fail:", err.Error())
+		res.Error = MakeError(-1, "sweep address fail: "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = result
+	ctx.JSON(res)
+	return
+}
+
+// ParsePayoutEntriesParam reads the [{"address", "amount", "reference"}]
+// array of batch_payout.
+func ParsePayoutEntriesParam(param interface{}) ([]PayoutEntry, error) {
+	entryList, ok := param.([]interface{})
+	if !ok {
+		return nil, errors.New("payouts must be an array")
+	}
+	entries := make([]PayoutEntry, 0, len(entryList))
+	for i, item := range entryList {
+		entryMap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("payout %d must be an object", i)
+		}
+		addr, ok1 := entryMap["address"].(string)
+		reference, ok2 := entryMap["reference"].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("payout %d needs a string address and reference", i)
+		}
+		amount, err := ParseSatoshi(entryMap["amount"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount of payout %d, %s", i, err.Error())
+		}
+		entries = append(entries, PayoutEntry{Address: addr, Amount: amount, Reference: reference})
+	}
+	return entries, nil
+}
+
+func BatchPayoutController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res BatchPayoutResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 4 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	entries, err := ParsePayoutEntriesParam(req.Params[0])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	feeRate, err := ParseFeeRateParam(req.Params[1])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	privKeyHexStrs, err := ParsePrivKeysParam(req.Params[2])
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[2], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	changeAddress := ""
+	typeStr := reflect.TypeOf(req.Params[3]).String()
+	if typeStr == "string" {
+		changeAddress = req.Params[3].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[3]")
+		ctx.JSON(res)
+		return
+	}
+
+	result, err := BTCBatchPayout(entries, feeRate, privKeyHexStrs, changeAddress)
+	if err != nil {
+		Error.Println("BTCBatchPayout fail:", err.Error())
+		res.Error = MakeError(-1, "batch payout fail: "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = result
+	ctx.JSON(res)
+	return
+}
+
+// ParseOutPoint splits an outpoint of the form "txid:vout".
+func ParseOutPoint(outPoint string) (string, int, error) {
+	parts := strings.Split(outPoint, ":")
+	if len(parts) != 2 || len(parts[0]) != 64 {
+		return "", 0, errors.New("invalid outpoint")
+	}
+	if _, err := hex.DecodeString(parts[0]); err != nil {
+		return "", 0, errors.New("invalid outpoint")
+	}
+	vout, err := strconv.ParseUint(parts[1], 10, 31)
+	if err != nil {
+		return "", 0, errors.New("invalid outpoint")
+	}
+	return parts[0], int(vout), nil
+}
+
+// ParseRangeParam accepts either an end index n (meaning [0, n]) or a
+// [begin, end] pair, as bitcoind's deriveaddresses does.
+func ParseRangeParam(param interface{}) (uint32, uint32, error) {
+	typeStr := reflect.TypeOf(param).String()
+	if typeStr == "float64" {
+		end := param.(float64)
+		if end < 0 || end != float64(uint32(end)) {
+			return 0, 0, errors.New("invalid range")
+		}
+		return 0, uint32(end), nil
+	} else if typeStr == "[]interface {}" {
+		pair := param.([]interface{})
+		if len(pair) != 2 {
+			return 0, 0, errors.New("invalid range, expect [begin, end]")
+		}
+		bounds := make([]uint32, 2)
+		for i, e := range pair {
+			f, ok := e.(float64)
+			if !ok || f < 0 || f != float64(uint32(f)) {
+				return 0, 0, errors.New("invalid range")
+			}
+			bounds[i] = uint32(f)
+		}
+		return bounds[0], bounds[1], nil
+	}
+	return 0, 0, errors.New("invalid range")
+}
+
+func parseDescriptorParams(req JsonRpcRequest, requireChecksum bool) (*Descriptor, uint32, uint32, *Err) {
+	if len(req.Params) != 1 && len(req.Params) != 2 {
+		return nil, 0, 0, MakeError(-1, "invalid jsonrpc request params length")
+	}
+
+	descStr := ""
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" {
+		descStr = req.Params[0].(string)
+	} else {
+		return nil, 0, 0, MakeError(-1, "invalid jsonrpc request params[0]")
+	}
+
+	desc, err := BTCParseDescriptor(descStr, requireChecksum)
+	if err != nil {
+		return nil, 0, 0, MakeError(-1, err.Error())
+	}
+
+	var begin, end uint32
+	if len(req.Params) == 2 {
+		begin, end, err = ParseRangeParam(req.Params[1])
+		if err != nil {
+			return nil, 0, 0, MakeError(-1, "invalid jsonrpc request params[1], "+err.Error())
+		}
+	} else if desc.IsRange() {
+		return nil, 0, 0, MakeError(-1, "range must be specified for a ranged descriptor")
+	}
+	return desc, begin, end, nil
+}
+
+func DeriveAddressesController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res DeriveAddressesResponse
+	res.Id = req.Id
+
+	desc, begin, end, rpcErr := parseDescriptorParams(req, false)
+	if rpcErr != nil {
+		res.Error = rpcErr
+		ctx.JSON(res)
+		return
+	}
+
+	derived, err := desc.DeriveAddresses(begin, end)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = &derived
+	ctx.JSON(res)
+	return
+}
+
+func ImportDescriptorController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res ImportDescriptorResponse
+	res.Id = req.Id
+
+	desc, begin, end, rpcErr := parseDescriptorParams(req, true)
+	if rpcErr != nil {
+		res.Error = rpcErr
+		ctx.JSON(res)
+		return
+	}
+
+	derived, err := desc.DeriveAddresses(begin, end)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	addresses := make([]address, 0, len(derived))
+	for _, d := range derived {
+		addresses = append(addresses, address{Address: d.Address, Descriptor: desc.String(), Derive_index: d.Index})
+	}
+	_, _, err = GlobalDBMgr.TblAddressMgr.AddNewAddresses(addresses)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = &ImportDescriptorRes{Descriptor: desc.String(), Addresses: derived}
+	ctx.JSON(res)
+	return
+}
+
+func AddXpubAccountController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res AddXpubAccountResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 2 && len(req.Params) != 3 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	name, extKeyStr, scriptType := "", "", ""
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" && req.Params[0].(string) != "" {
+		name = req.Params[0].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+		ctx.JSON(res)
+		return
+	}
+
+	typeStr = reflect.TypeOf(req.Params[1]).String()
+	if typeStr == "string" {
+		extKeyStr = req.Params[1].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1]")
+		ctx.JSON(res)
+		return
+	}
+
+	xpub, scriptType, err := BTCNormalizeExtendedPubKey(extKeyStr)
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1], "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	// the script type can only be overridden for plain xpub/tpub keys
+	if len(req.Params) == 3 {
+		typeStr = reflect.TypeOf(req.Params[2]).String()
+		if typeStr != "string" {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[2]")
+			ctx.JSON(res)
+			return
+		}
+		if xpub != extKeyStr && req.Params[2].(string) != scriptType {
+			res.Error = MakeError(-1, "script type conflicts with extended key version")
+			ctx.JSON(res)
+			return
+		}
+		scriptType = req.Params[2].(string)
+	}
+
+	receiveDesc, err := BTCXpubChainDescriptor(xpub, scriptType, false)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+	changeDesc, err := BTCXpubChainDescriptor(xpub, scriptType, true)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	err = GlobalDBMgr.TblXpubAccountMgr.AddAccount(name, xpub, scriptType)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = &XpubAccountRes{Name: name, Xpub: xpub, ScriptType: scriptType,
+		ReceiveDescriptor: receiveDesc.String(), ChangeDescriptor: changeDesc.String()}
+	ctx.JSON(res)
+	return
+}
+
+func AddressFromXpubController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res AddressFromXpubResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 3 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	name := ""
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" {
+		name = req.Params[0].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+		ctx.JSON(res)
+		return
+	}
+
+	change := false
+	typeStr = reflect.TypeOf(req.Params[1]).String()
+	if typeStr == "bool" {
+		change = req.Params[1].(bool)
+	} else if typeStr == "float64" && (req.Params[1].(float64) == 0 || req.Params[1].(float64) == 1) {
+		change = req.Params[1].(float64) == 1
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1]")
+		ctx.JSON(res)
+		return
+	}
+
+	account, err := GlobalDBMgr.TblXpubAccountMgr.GetAccount(name)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	desc, err := BTCXpubChainDescriptor(account.Xpub, account.Script_type, change)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	// a count hands out fresh addresses, a [begin, end] pair re-derives a range
+	var begin, end uint32
+	typeStr = reflect.TypeOf(req.Params[2]).String()
+	if typeStr == "float64" {
+		count := int(req.Params[2].(float64))
+		if count <= 0 || count > maxDescriptorRangeSize || float64(count) != req.Params[2].(float64) {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[2], invalid count")
+			ctx.JSON(res)
+			return
+		}
+		first, err := GlobalDBMgr.TblXpubAccountMgr.ReserveIndexes(name, change, count)
+		if err != nil {
+			res.Error = MakeError(-1, err.Error())
+			ctx.JSON(res)
+			return
+		}
+		begin, end = uint32(first), uint32(first+count-1)
+	} else {
+		begin, end, err = ParseRangeParam(req.Params[2])
+		if err != nil {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[2], "+err.Error())
+			ctx.JSON(res)
+			return
+		}
+	}
+
+	derived, err := desc.DeriveAddresses(begin, end)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	chain, purpose := 0, AddressPurposeDeposit
+	if change {
+		chain, purpose = 1, AddressPurposeChange
+	}
+	addresses := make([]address, 0, len(derived))
+	for _, d := range derived {
+		addresses = append(addresses, address{Address: d.Address, Descriptor: desc.String(), Derive_index: d.Index,
+			Account: name, Purpose: purpose, Derive_path: fmt.Sprintf("%d/%d", chain, d.Index)})
+	}
+	_, _, err = GlobalDBMgr.TblAddressMgr.AddNewAddresses(addresses)
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	nextIndex, err := GlobalDBMgr.TblXpubAccountMgr.MarkIndexUsed(name, change, int(end))
+	if err != nil {
+		res.Error = MakeError(-1, err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = &AddressFromXpubRes{Account: name, Change: change, Addresses: derived, NextIndex: nextIndex}
+	ctx.JSON(res)
+	return
+}
+
+func DecodeTransactionController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res DecodeTransactionResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 1 && len(req.Params) != 2 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	rawTrxStr := ""
+	typeStr := reflect.TypeOf(req.Params[0]).String()
+	if typeStr == "string" {
+		rawTrxStr = req.Params[0].(string)
+	} else {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[0]")
+		ctx.JSON(res)
+		return
+	}
+
+	var utxos UTXOsDetail
+	if len(req.Params) == 2 {
+		typeStr = reflect.TypeOf(req.Params[1]).String()
+		if typeStr != "string" {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[1]")
+			ctx.JSON(res)
+			return
+		}
+		var err error
+		utxos, err = ParseUtxosParam(req.Params[1].(string))
+		if err != nil {
+			res.Error = MakeError(-1, "invalid jsonrpc request params[1], Unmarshal fail")
+			ctx.JSON(res)
+			return
+		}
+	}
+
+	decoded, err := BTCDecodeRawTransaction(rawTrxStr, utxos)
+	if err != nil {
+		res.Error = MakeError(-1, "decode raw transaction fail: "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = decoded
+	ctx.JSON(res)
+	return
+}
+
+func SignMessageController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res SignMessageResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 3 && len(req.Params) != 4 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	strParams := make([]string, 0, len(req.Params))
+	for i, param := range req.Params {
+		typeStr := reflect.TypeOf(param).String()
+		if typeStr != "string" {
+			res.Error = MakeError(-1, fmt.Sprintf("invalid jsonrpc request params[%d]", i))
+			ctx.JSON(res)
+			return
+		}
+		strParams = append(strParams, param.(string))
+	}
+	addr, privKeyEncryptHexStr, message, format := strParams[0], strParams[1], strParams[2], ""
+	if len(strParams) == 4 {
+		format = strParams[3]
+	}
+
+	privKeyEncryptBytes, err := hex.DecodeString(privKeyEncryptHexStr)
+	if err != nil {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1], privKeyEncryptHexStr not hex format string")
+		ctx.JSON(res)
+		return
+	}
+
+	privKeyHexStr := string(AesDecrypt(privKeyEncryptBytes, []byte(SecurityPassStr)))
+	if len(privKeyHexStr) == 0 {
+		res.Error = MakeError(-1, "AesDecrypt fail")
+		ctx.JSON(res)
+		return
+	}
+
+	owns, err := BTCKeyOwnsAddress(privKeyHexStr, addr)
+	if err != nil || !owns {
+		res.Error = MakeError(-1, "invalid jsonrpc request params[1], key does not belong to address "+addr)
+		ctx.JSON(res)
+		return
+	}
+
+	signature, err := BTCSignMessage(privKeyHexStr, addr, message, format)
+	if err != nil {
+		res.Error = MakeError(-1, "sign message fail: "+err.Error())
+		ctx.JSON(res)
+		return
+	}
+
+	res.Result = &signature
+	ctx.JSON(res)
+	return
+}
+
+func VerifyMessageController(ctx iris.Context, jsonRpcBody []byte) {
+	var req JsonRpcRequest
+	_ = json.Unmarshal(jsonRpcBody, &req)
+
+	var res VerifyMessageResponse
+	res.Id = req.Id
+
+	if len(req.Params) != 3 {
+		res.Error = MakeError(-1, "invalid jsonrpc request params length")
+		ctx.JSON(res)
+		return
+	}
+
+	strParams := make([]string, 0, len(req.Params))
+	for i, param := range req.Params {
+		typeStr := reflect.TypeOf(param).String()
+		if typeStr != "string" {
+			res.Error = MakeError(-1, fmt.Sprintf("invalid jsonrpc request params[%d]", i))
+			ctx.JSON(res)
+			return
+		}
+		strParams = append(strParams, param.(string))
+	}
+
+	verified, err := BTCVerifyMessage(strParams[0], strParams[1], strParams[2])
+	if err != nil {
+		res.Error = MakeError(-1, "verify message fail: "+err.Error())
+		ctx.JSON(res)
+		return
 	}
 
-	res.Result = &utxosRes
+	res.Result = &verified
 	ctx.JSON(res)
 	return
 }
@@ -582,6 +2625,48 @@ func Controller(ctx iris.Context) {
 		ImportAddressesController(ctx, jsonRpcBody)
 	} else if funcName == "query_utxos" {
 		QueryUtxosController(ctx, jsonRpcBody)
+	} else if funcName == "derive_addresses" {
+		DeriveAddressesController(ctx, jsonRpcBody)
+	} else if funcName == "import_descriptor" {
+		ImportDescriptorController(ctx, jsonRpcBody)
+	} else if funcName == "add_xpub_account" {
+		AddXpubAccountController(ctx, jsonRpcBody)
+	} else if funcName == "address_from_xpub" {
+		AddressFromXpubController(ctx, jsonRpcBody)
+	} else if funcName == "decode_transaction" {
+		DecodeTransactionController(ctx, jsonRpcBody)
+	} else if funcName == "sign_message" {
+		SignMessageController(ctx, jsonRpcBody)
+	} else if funcName == "verify_message" {
+		VerifyMessageController(ctx, jsonRpcBody)
+	} else if funcName == "list_pending" {
+		ListPendingController(ctx, jsonRpcBody)
+	} else if funcName == "release_utxos" {
+		ReleaseUtxosController(ctx, jsonRpcBody)
+	} else if funcName == "broadcast_transaction" {
+		BroadcastTransactionController(ctx, jsonRpcBody)
+	} else if funcName == "get_transaction_status" {
+		GetTransactionStatusController(ctx, jsonRpcBody)
+	} else if funcName == "bump_fee" {
+		BumpFeeController(ctx, jsonRpcBody)
+	} else if funcName == "cpfp" {
+		CpfpController(ctx, jsonRpcBody)
+	} else if funcName == "consolidate" {
+		ConsolidateController(ctx, jsonRpcBody)
+	} else if funcName == "sweep_address" {
+		SweepAddressController(ctx, jsonRpcBody)
+	} else if funcName == "batch_payout" {
+		BatchPayoutController(ctx, jsonRpcBody)
+	} else if funcName == "set_address_label" {
+		SetAddressLabelController(ctx, jsonRpcBody)
+	} else if funcName == "list_addresses" {
+		ListAddressesController(ctx, jsonRpcBody)
+	} else if funcName == "get_address_info" {
+		GetAddressInfoController(ctx, jsonRpcBody)
+	} else if funcName == "get_balance" {
+		GetBalanceController(ctx, jsonRpcBody)
+	} else if funcName == "list_transactions" {
+		ListTransactionsController(ctx, jsonRpcBody)
 	} else {
 		var res JsonRpcResponse
 		res.Id = id
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseImportAddressesParams(t *testing.T) {
+	addr := "1GJ23Q56cMqfVuGskN5gUKj2YYkmbtNVnL"
+	for _, params := range [][]interface{}{
+		{addr, addr},
+		{[]interface{}{addr, addr}},
+	} {
+		addrs, err := ParseImportAddressesParams(params)
+		if err != nil || len(addrs) != 2 {
+			t.Error("unexpected addresses", addrs, err)
+		}
+	}
+	for _, params := range [][]interface{}{
+		{addr, "1GJ23Q56cMqfVuGskN5gUKj2YYkmbtNVnM"},
+		{[]interface{}{addr, 1.0}},
+		{""},
+	} {
+		if _, err := ParseImportAddressesParams(params); err == nil {
+			t.Error("invalid addresses accepted", params)
+		}
+	}
+}
+
+func TestParseQueryUtxosOptionsParam(t *testing.T) {
+	q, err := ParseQueryUtxosOptionsParam(map[string]interface{}{"addresses": []interface{}{"addr1", "addr2"},
+		"minAmount": "0.00001", "maxConf": 6.0, "sort": "amount", "order": "desc", "limit": 10.0, "includePending": true})
+	if err != nil || len(q.Addresses) != 2 || q.MinAmount != 1000 || q.MaxConf != 6 || !q.SortByAmount || !q.Desc ||
+		q.Limit != 10 || !q.IncludePending || q.MinDepositConf != GlobalConfig.UtxoConfig.MinDepositConfirmations {
+		t.Fatal("unexpected query", q, err)
+	}
+	if q, _ = ParseQueryUtxosOptionsParam(map[string]interface{}{"minConf": 0.0}); q.MinChangeConf != 0 || q.MaxConf != -1 ||
+		q.Limit != QueryUtxosDefaultLimit {
+		t.Fatal("unexpected query", q)
+	}
+	for _, invalid := range []map[string]interface{}{
+		{"addresses": []interface{}{"addr1"}, "account": "alice"}, {"addresses": "addr1"}, {"limit": 0.0},
+		{"limit": 1001.0}, {"minConf": -1.0}, {"sort": "size"}, {"order": "up"}, {"minAmount": 500.0, "maxAmount": 100.0},
+		{"unknown": 1.0},
+	} {
+		if _, err = ParseQueryUtxosOptionsParam(invalid); err == nil {
+			t.Error("invalid options accepted", invalid)
+		}
+	}
+}
+
+func TestParseListAddressesOptionsParam(t *testing.T) {
+	filter, cursor, limit, err := ParseListAddressesOptionsParam(map[string]interface{}{
+		"account": "alice", "hasKey": false, "cursor": 12.0, "limit": 50.0})
+	if err != nil || filter.Account != "alice" || filter.Has_key != "0" || cursor != 12 || limit != 50 {
+		t.Fatal("unexpected options", filter, cursor, limit, err)
+	}
+	for _, invalid := range []map[string]interface{}{
+		{"limit": 0.0}, {"limit": 1001.0}, {"cursor": 1.5}, {"hasKey": 1.0}, {"label": 1.0}, {"unknown": ""},
+	} {
+		if _, _, _, err = ParseListAddressesOptionsParam(invalid); err == nil {
+			t.Error("invalid options accepted", invalid)
+		}
+	}
+}
+
+func TestParseListTransactionsOptionsParam(t *testing.T) {
+	q, err := ParseListTransactionsOptionsParam(map[string]interface{}{"account": "alice", "since": 1000.0, "until": 2000.0,
+		"limit": 10.0, "cursor": "12"})
+	if err != nil || q.Account != "alice" || q.Since != 1000 || q.Until != 2000 || q.Limit != 10 || q.Cursor != "12" {
+		t.Fatal("unexpected query", q, err)
+	}
+	for _, invalid := range []map[string]interface{}{
+		{}, {"address": "addr1", "account": "alice"}, {"address": 1.0}, {"address": "addr1", "since": -1.0},
+		{"address": "addr1", "limit": 0.0}, {"address": "addr1", "unknown": 1.0},
+	} {
+		if _, err = ParseListTransactionsOptionsParam(invalid); err == nil {
+			t.Error("invalid options accepted", invalid)
+		}
+	}
+}
+
+func TestParseOutPoint(t *testing.T) {
+	txId := "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+	parsedTxId, vout, err := ParseOutPoint(txId + ":1")
+	if err != nil || parsedTxId != txId || vout != 1 {
+		t.Fatal("unexpected outpoint", parsedTxId, vout, err)
+	}
+	for _, invalid := range []string{txId, txId + ":", txId + ":-1", "abcd:0", txId + ":1:2"} {
+		if _, _, err = ParseOutPoint(invalid); err == nil {
+			t.Error("invalid outpoint accepted:", invalid)
+		}
+	}
+}
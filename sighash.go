@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/mutalisk999/bitcoin-lib/src/script"
+	"github.com/mutalisk999/bitcoin-lib/src/serialize"
+	"github.com/mutalisk999/bitcoin-lib/src/transaction"
+	"github.com/mutalisk999/bitcoin-lib/src/utility"
+	"io"
+	"strings"
+)
+
+const (
+	SIGHASH_DEFAULT      = uint32(0x0)
+	SIGHASH_ALL          = uint32(0x1)
+	SIGHASH_NONE         = uint32(0x2)
+	SIGHASH_SINGLE       = uint32(0x3)
+	SIGHASH_ANYONECANPAY = uint32(0x80)
+
+	sigHashOutputMask = uint32(0x1f)
+)
+
+// BTCParseSigHashType parses bitcoind style names such as "ALL",
+// "SINGLE|ANYONECANPAY" or "DEFAULT" (taproot only).
+func BTCParseSigHashType(hashTypeStr string) (uint32, error) {
+	switch strings.ToUpper(strings.TrimSpace(hashTypeStr)) {
+	case "DEFAULT":
+		return SIGHASH_DEFAULT, nil
+	case "ALL":
+		return SIGHASH_ALL, nil
+	case "NONE":
+		return SIGHASH_NONE, nil
+	case "SINGLE":
+		return SIGHASH_SINGLE, nil
+	case "ALL|ANYONECANPAY":
+		return SIGHASH_ALL | SIGHASH_ANYONECANPAY, nil
+	case "NONE|ANYONECANPAY":
+		return SIGHASH_NONE | SIGHASH_ANYONECANPAY, nil
+	case "SINGLE|ANYONECANPAY":
+		return SIGHASH_SINGLE | SIGHASH_ANYONECANPAY, nil
+	}
+	return 0, fmt.Errorf("invalid sighash type: %s", hashTypeStr)
+}
+
+func isValidLegacySigHashType(hashType uint32) bool {
+	base := hashType &^ SIGHASH_ANYONECANPAY
+	return base >= SIGHASH_ALL && base <= SIGHASH_SINGLE
+}
+
+func isValidTaprootSigHashType(hashType uint32) bool {
+	return hashType == SIGHASH_DEFAULT || isValidLegacySigHashType(hashType)
+}
+
+func copyTransaction(trx *transaction.Transaction) *transaction.Transaction {
+	trxCopy := new(transaction.Transaction)
+	trxCopy.Version = trx.Version
+	trxCopy.LockTime = trx.LockTime
+	trxCopy.Vin = make([]transaction.TxIn, len(trx.Vin))
+	copy(trxCopy.Vin, trx.Vin)
+	trxCopy.Vout = make([]transaction.TxOut, len(trx.Vout))
+	copy(trxCopy.Vout, trx.Vout)
+	return trxCopy
+}
+
+func doubleSha256(data []byte) []byte {
+	return utility.Sha256(utility.Sha256(data))
+}
+
+// BTCCalcLegacySigHash computes the original (pre-segwit) signature hash of
+// input idx. SIGHASH_SINGLE without a matching output signs the value 1, as
+// bitcoind does.
+func BTCCalcLegacySigHash(trx *transaction.Transaction, idx int, scriptCode []byte, hashType uint32) ([]byte, error) {
+	if idx < 0 || idx >= len(trx.Vin) {
+		return nil, errors.New("input index out of range")
+	}
+	if !isValidLegacySigHashType(hashType) {
+		return nil, fmt.Errorf("invalid sighash type: 0x%x", hashType)
+	}
+
+	if hashType&sigHashOutputMask == SIGHASH_SINGLE && idx >= len(trx.Vout) {
+		one := make([]byte, 32)
+		one[0] = 0x1
+		return one, nil
+	}
+
+	trxCopy := copyTransaction(trx)
+	for i := range trxCopy.Vin {
+		if i == idx {
+			trxCopy.Vin[i].ScriptSig.SetScriptBytes(scriptCode)
+		} else {
+			trxCopy.Vin[i].ScriptSig.SetScriptBytes([]byte{})
+		}
+	}
+
+	switch hashType & sigHashOutputMask {
+	case SIGHASH_NONE:
+		trxCopy.Vout = trxCopy.Vout[:0]
+		for i := range trxCopy.Vin {
+			if i != idx {
+				trxCopy.Vin[i].Sequence = 0
+			}
+		}
+	case SIGHASH_SINGLE:
+		trxCopy.Vout = trxCopy.Vout[:idx+1]
+		for i := 0; i < idx; i++ {
+			trxCopy.Vout[i].Value = -1
+			trxCopy.Vout[i].ScriptPubKey.SetScriptBytes([]byte{})
+		}
+		for i := range trxCopy.Vin {
+			if i != idx {
+				trxCopy.Vin[i].Sequence = 0
+			}
+		}
+	}
+
+	if hashType&SIGHASH_ANYONECANPAY != 0 {
+		trxCopy.Vin = trxCopy.Vin[idx : idx+1]
+	}
+
+	bytesBuf := bytes.NewBuffer([]byte{})
+	bufWriter := io.Writer(bytesBuf)
+	err := trxCopy.PackNoWitness(bufWriter)
+	if err != nil {
+		return nil, err
+	}
+	err = serialize.PackUint32(bufWriter, hashType)
+	if err != nil {
+		return nil, err
+	}
+	return doubleSha256(bytesBuf.Bytes()), nil
+}
+
+// BTCCalcWitnessV0SigHash computes the BIP143 signature hash of input idx
+// spending amount satoshis.
+func BTCCalcWitnessV0SigHash(trx *transaction.Transaction, idx int, scriptCode []byte, amount int64, hashType uint32) ([]byte, error) {
+	if idx < 0 || idx >= len(trx.Vin) {
+		return nil, errors.New("input index out of range")
+	}
+	if !isValidLegacySigHashType(hashType) {
+		return nil, fmt.Errorf("invalid sighash type: 0x%x", hashType)
+	}
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+	baseType := hashType & sigHashOutputMask
+
+	hashPrevouts := make([]byte, 32)
+	hashSequence := make([]byte, 32)
+	hashOutputs := make([]byte, 32)
+
+	if !anyoneCanPay {
+		bytesBuf := bytes.NewBuffer([]byte{})
+		for _, vin := range trx.Vin {
+			err := vin.PrevOut.Pack(bytesBuf)
+			if err != nil {
+				return nil, err
+			}
+		}
+		hashPrevouts = doubleSha256(bytesBuf.Bytes())
+	}
+	if !anyoneCanPay && baseType != SIGHASH_SINGLE && baseType != SIGHASH_NONE {
+		bytesBuf := bytes.NewBuffer([]byte{})
+		for _, vin := range trx.Vin {
+			err := serialize.PackUint32(bytesBuf, vin.Sequence)
+			if err != nil {
+				return nil, err
+			}
+		}
+		hashSequence = doubleSha256(bytesBuf.Bytes())
+	}
+	if baseType != SIGHASH_SINGLE && baseType != SIGHASH_NONE {
+		bytesBuf := bytes.NewBuffer([]byte{})
+		for _, vout := range trx.Vout {
+			err := vout.Pack(bytesBuf)
+			if err != nil {
+				return nil, err
+			}
+		}
+		hashOutputs = doubleSha256(bytesBuf.Bytes())
+	} else if baseType == SIGHASH_SINGLE && idx < len(trx.Vout) {
+		bytesBuf := bytes.NewBuffer([]byte{})
+		err := trx.Vout[idx].Pack(bytesBuf)
+		if err != nil {
+			return nil, err
+		}
+		hashOutputs = doubleSha256(bytesBuf.Bytes())
+	}
+
+	bytesBuf := bytes.NewBuffer([]byte{})
+	bufWriter := io.Writer(bytesBuf)
+	_ = serialize.PackInt32(bufWriter, trx.Version)
+	bytesBuf.Write(hashPrevouts)
+	bytesBuf.Write(hashSequence)
+	err := trx.Vin[idx].PrevOut.Pack(bufWriter)
+	if err != nil {
+		return nil, err
+	}
+	codeScript := new(script.Script)
+	codeScript.SetScriptBytes(scriptCode)
+	err = codeScript.Pack(bufWriter)
+	if err != nil {
+		return nil, err
+	}
+	_ = serialize.PackInt64(bufWriter, amount)
+	_ = serialize.PackUint32(bufWriter, trx.Vin[idx].Sequence)
+	bytesBuf.Write(hashOutputs)
+	_ = serialize.PackUint32(bufWriter, trx.LockTime)
+	_ = serialize.PackUint32(bufWriter, hashType)
+	return doubleSha256(bytesBuf.Bytes()), nil
+}
+
+// BTCCalcTaprootSigHash computes the BIP341 key path signature hash of
+// input idx. prevOuts must hold the spent output of every input.
+func BTCCalcTaprootSigHash(trx *transaction.Transaction, idx int, prevOuts []transaction.TxOut, hashType uint32) ([]byte, error) {
+	if idx < 0 || idx >= len(trx.Vin) {
+		return nil, errors.New("input index out of range")
+	}
+	if len(prevOuts) != len(trx.Vin) {
+		return nil, errors.New("taproot signature hash needs the spent outputs of all inputs")
+	}
+	if !isValidTaprootSigHashType(hashType) {
+		return nil, fmt.Errorf("invalid sighash type: 0x%x", hashType)
+	}
+	anyoneCanPay := hashType&SIGHASH_ANYONECANPAY != 0
+	baseType := hashType & 0x3
+	if hashType == SIGHASH_DEFAULT {
+		baseType = SIGHASH_ALL
+	}
+	if baseType == SIGHASH_SINGLE && idx >= len(trx.Vout) {
+		return nil, errors.New("SIGHASH_SINGLE without a corresponding output")
+	}
+
+	bytesBuf := bytes.NewBuffer([]byte{})
+	bufWriter := io.Writer(bytesBuf)
+	// sighash epoch
+	_ = serialize.PackUint8(bufWriter, 0)
+	_ = serialize.PackUint8(bufWriter, uint8(hashType))
+	_ = serialize.PackInt32(bufWriter, trx.Version)
+	_ = serialize.PackUint32(bufWriter, trx.LockTime)
+
+	if !anyoneCanPay {
+		prevoutsBuf := bytes.NewBuffer([]byte{})
+		amountsBuf := bytes.NewBuffer([]byte{})
+		scriptPubKeysBuf := bytes.NewBuffer([]byte{})
+		sequencesBuf := bytes.NewBuffer([]byte{})
+		for i, vin := range trx.Vin {
+			err := vin.PrevOut.Pack(prevoutsBuf)
+			if err != nil {
+				return nil, err
+			}
+			_ = serialize.PackInt64(amountsBuf, prevOuts[i].Value)
+			err = prevOuts[i].ScriptPubKey.Pack(scriptPubKeysBuf)
+			if err != nil {
+				return nil, err
+			}
+			_ = serialize.PackUint32(sequencesBuf, vin.Sequence)
+		}
+		bytesBuf.Write(utility.Sha256(prevoutsBuf.Bytes()))
+		bytesBuf.Write(utility.Sha256(amountsBuf.Bytes()))
+		bytesBuf.Write(utility.Sha256(scriptPubKeysBuf.Bytes()))
+		bytesBuf.Write(utility.Sha256(sequencesBuf.Bytes()))
+	}
+	if baseType != SIGHASH_NONE && baseType != SIGHASH_SINGLE {
+		outputsBuf := bytes.NewBuffer([]byte{})
+		for _, vout := range trx.Vout {
+			err := vout.Pack(outputsBuf)
+			if err != nil {
+				return nil, err
+			}
+		}
+		bytesBuf.Write(utility.Sha256(outputsBuf.Bytes()))
+	}
+
+	// spend type: key path, no annex
+	_ = serialize.PackUint8(bufWriter, 0)
+	if anyoneCanPay {
+		err := trx.Vin[idx].PrevOut.Pack(bufWriter)
+		if err != nil {
+			return nil, err
+		}
+		_ = serialize.PackInt64(bufWriter, prevOuts[idx].Value)
+		err = prevOuts[idx].ScriptPubKey.Pack(bufWriter)
+		if err != nil {
+			return nil, err
+		}
+		_ = serialize.PackUint32(bufWriter, trx.Vin[idx].Sequence)
+	} else {
+		_ = serialize.PackUint32(bufWriter, uint32(idx))
+	}
+	if baseType == SIGHASH_SINGLE {
+		outputBuf := bytes.NewBuffer([]byte{})
+		err := trx.Vout[idx].Pack(outputBuf)
+		if err != nil {
+			return nil, err
+		}
+		bytesBuf.Write(utility.Sha256(outputBuf.Bytes()))
+	}
+	return BTCTaggedHash("TapSighash", bytesBuf.Bytes()), nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/mutalisk999/bitcoin-lib/src/utility"
+	"testing"
+)
+
+func testPrivKeyHex(seed byte) string {
+	privKeyBytes := make([]byte, 32)
+	privKeyBytes[31] = seed
+	return hex.EncodeToString(privKeyBytes)
+}
+
+func testUnsignedTrx(inputCount int, outputCount int) string {
+	msgTx := wire.NewMsgTx(2)
+	for i := 0; i < inputCount; i++ {
+		var hash chainhash.Hash
+		hash[0] = byte(i + 1)
+		msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&hash, uint32(i)), nil, nil))
+	}
+	for i := 0; i < outputCount; i++ {
+		msgTx.AddTxOut(wire.NewTxOut(int64(10000*(i+1)), []byte{0x51}))
+	}
+	var buf bytes.Buffer
+	_ = msgTx.Serialize(&buf)
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func testVerifyTrxWithEngine(t *testing.T, signedHex string, prevScripts [][]byte, amounts []int64) {
+	signedBytes, _ := hex.DecodeString(signedHex)
+	msgTx := wire.NewMsgTx(2)
+	err := msgTx.Deserialize(bytes.NewReader(signedBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigHashes := txscript.NewTxSigHashes(msgTx)
+	for i := range msgTx.TxIn {
+		engine, err := txscript.NewEngine(prevScripts[i], msgTx, i, txscript.StandardVerifyFlags, nil, sigHashes, amounts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = engine.Execute()
+		if err != nil {
+			t.Errorf("input %d verify fail: %s", i, err.Error())
+		}
+	}
+}
+
+func TestBTCSignRawTransactionSigHashTypes(t *testing.T) {
+	privKeyHex := testPrivKeyHex(7)
+	privKeyBytes, _ := hex.DecodeString(privKeyHex)
+	_, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), privKeyBytes)
+	p2pkh, _ := BTCGetP2PKHScriptPubKey(hex.EncodeToString(pubKey.SerializeUncompressed()[1:]))
+	p2wpkh := BTCGetWitnessScriptPubKey(0, utility.Hash160(pubKey.SerializeCompressed()))
+	p2shP2wpkh := BTCGetP2SHScriptPubKey(p2wpkh)
+
+	// three inputs, two outputs: SIGHASH_SINGLE on the third input hits the legacy bug
+	rawTrx := testUnsignedTrx(3, 2)
+	trx, _ := BTCUnPackRawTransaction(rawTrx)
+	prevScripts := [][]byte{p2wpkh, p2shP2wpkh, p2pkh}
+	amounts := []int64{50000, 60000, 70000}
+	utxos := make([]UTXODetail, 0)
+	for i, vin := range trx.Vin {
+		utxos = append(utxos, UTXODetail{TxId: vin.PrevOut.Hash.GetHex(), Vout: int(vin.PrevOut.N),
+			ScriptPubKey: hex.EncodeToString(prevScripts[i]), Amount: Satoshi(amounts[i])})
+	}
+
+	for _, names := range [][]string{
+		{"ALL"},
+		{"NONE"},
+		{"SINGLE|ANYONECANPAY", "NONE|ANYONECANPAY", "ALL|ANYONECANPAY"},
+		{"SINGLE", "SINGLE", "SINGLE"},
+	} {
+		hashTypes := make([]uint32, 0)
+		for _, name := range names {
+			hashType, _ := BTCParseSigHashType(name)
+			hashTypes = append(hashTypes, hashType)
+		}
+		signedHex, err := BTCSignRawTransaction(rawTrx, privKeyHex, utxos, hashTypes...)
+		if err != nil {
+			t.Fatal(names, err)
+		}
+		testVerifyTrxWithEngine(t, signedHex, prevScripts, amounts)
+	}
+
+	_, err := BTCSignRawTransaction(rawTrx, privKeyHex, utxos, SIGHASH_ALL, SIGHASH_ALL)
+	if err == nil {
+		t.Error("mismatched sighash types count accepted")
+	}
+}
+
+func TestBTCCalcLegacySigHashSingleBug(t *testing.T) {
+	trx, _ := BTCUnPackRawTransaction(testUnsignedTrx(2, 1))
+	hashBytes, err := BTCCalcLegacySigHash(trx, 1, []byte{0x51}, SIGHASH_SINGLE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := make([]byte, 32)
+	one[0] = 0x1
+	if !bytes.Equal(hashBytes, one) {
+		t.Error("unexpected SIGHASH_SINGLE out of range hash:", hex.EncodeToString(hashBytes))
+	}
+}
+
+func TestBTCSchnorrSign(t *testing.T) {
+	// BIP340 test vector 0
+	privKeyBytes, _ := hex.DecodeString("0000000000000000000000000000000000000000000000000000000000000003")
+	msg := make([]byte, 32)
+	auxRand := make([]byte, 32)
+	signature, err := BTCSchnorrSign(privKeyBytes, msg, auxRand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "e907831f80848d1069a5371b402410364bdf1c5f8307b0084c55f1ce2dca821525f66a4a85ea8b71e482a74f382d2ce5ebeee8fdb2172f477df4900d310536c0"
+	if hex.EncodeToString(signature) != expected {
+		t.Error("unexpected signature:", hex.EncodeToString(signature))
+	}
+	pubKey, _ := hex.DecodeString("f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9")
+	if !BTCSchnorrVerify(pubKey, msg, signature) {
+		t.Error("verify signature fail")
+	}
+	signature[63] ^= 0x1
+	if BTCSchnorrVerify(pubKey, msg, signature) {
+		t.Error("tampered signature verified")
+	}
+}
+
+func TestBTCSignRawTransactionTaproot(t *testing.T) {
+	privKeyHex := testPrivKeyHex(9)
+	privKeyBytes, _ := hex.DecodeString(privKeyHex)
+	_, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), privKeyBytes)
+	outputKey, _ := BTCTaprootTweakPubKey(BTCXOnlyPubKey(pubKey), nil)
+	p2tr := BTCGetWitnessScriptPubKey(1, outputKey)
+
+	rawTrx := testUnsignedTrx(2, 1)
+	trx, _ := BTCUnPackRawTransaction(rawTrx)
+	utxos := make([]UTXODetail, 0)
+	for _, vin := range trx.Vin {
+		utxos = append(utxos, UTXODetail{TxId: vin.PrevOut.Hash.GetHex(), Vout: int(vin.PrevOut.N),
+			ScriptPubKey: hex.EncodeToString(p2tr), Amount: 30000})
+	}
+
+	for _, hashType := range []uint32{SIGHASH_DEFAULT, SIGHASH_SINGLE | SIGHASH_ANYONECANPAY} {
+		_, err := BTCSignRawTransaction(rawTrx, privKeyHex, utxos[:1], hashType)
+		if err == nil {
+			t.Error("taproot signing without all prevouts accepted")
+		}
+		signedHex, err := BTCSignRawTransaction(rawTrx, privKeyHex, utxos, hashType)
+		if hashType == SIGHASH_SINGLE|SIGHASH_ANYONECANPAY {
+			// input 1 has no corresponding output
+			if err == nil {
+				t.Error("taproot SIGHASH_SINGLE without output accepted")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		signedTrx, _ := BTCUnPackRawTransaction(signedHex)
+		prevOuts := prevOutsFromUtxos(signedTrx, utxos)
+		for i, vin := range signedTrx.Vin {
+			witness := vin.ScriptWitness.GetScriptWitnessBytes()
+			if len(witness) != 1 || len(witness[0]) != 64 {
+				t.Fatal("unexpected taproot witness")
+			}
+			hashBytes, _ := BTCCalcTaprootSigHash(signedTrx, i, prevOuts, hashType)
+			if !BTCSchnorrVerify(outputKey, hashBytes, witness[0]) {
+				t.Error("taproot signature verify fail")
+			}
+		}
+	}
+}
+
+func TestBTCSignRawTransactionWithKeys(t *testing.T) {
+	ownKeyHex, otherKeyHex, foreignKeyHex := testPrivKeyHex(11), testPrivKeyHex(12), testPrivKeyHex(13)
+	scriptPubKeys := make([][]byte, 0)
+	for _, keyHex := range []string{ownKeyHex, otherKeyHex, foreignKeyHex} {
+		keyBytes, _ := hex.DecodeString(keyHex)
+		keyScriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+		// p2wpkh of the key
+		scriptPubKeys = append(scriptPubKeys, keyScriptPubKeys[1])
+	}
+
+	rawTrx := testUnsignedTrx(3, 1)
+	trx, _ := BTCUnPackRawTransaction(rawTrx)
+	amounts := []int64{10000, 20000, 30000}
+	utxos := make([]UTXODetail, 0)
+	for i, vin := range trx.Vin {
+		utxos = append(utxos, UTXODetail{TxId: vin.PrevOut.Hash.GetHex(), Vout: int(vin.PrevOut.N),
+			ScriptPubKey: hex.EncodeToString(scriptPubKeys[i]), Amount: Satoshi(amounts[i])})
+	}
+
+	signedHex, report, err := BTCSignRawTransactionWithKeys(rawTrx, []string{ownKeyHex, otherKeyHex}, utxos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 3 || report[0].Status != InputVerifyStatusVerified || report[1].Status != InputVerifyStatusVerified ||
+		report[2].Status != InputVerifyStatusUnsigned {
+		t.Fatal("unexpected verification report:", report)
+	}
+	signedTrx, _ := BTCUnPackRawTransaction(signedHex)
+	if len(signedTrx.Vin[2].ScriptWitness.GetScriptWitnessBytes()) != 0 || len(signedTrx.Vin[2].ScriptSig.GetScriptBytes()) != 0 {
+		t.Fatal("foreign input was modified")
+	}
+
+	// the partner completes the transaction
+	signedHex, report, err = BTCSignRawTransactionWithKeys(signedHex, []string{foreignKeyHex}, utxos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report[0].Status != InputVerifyStatusUnsigned || report[2].Status != InputVerifyStatusVerified {
+		t.Fatal("unexpected verification report:", report)
+	}
+	testVerifyTrxWithEngine(t, signedHex, scriptPubKeys, amounts)
+
+	// inputs without a known prevout are left untouched
+	signedHex, report, err = BTCSignRawTransactionWithKeys(rawTrx, []string{ownKeyHex}, utxos[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report[0].Status != InputVerifyStatusVerified || report[1].Status != InputVerifyStatusUnsigned {
+		t.Fatal("unexpected verification report:", report)
+	}
+	signedTrx, _ = BTCUnPackRawTransaction(signedHex)
+	if len(signedTrx.Vin[1].ScriptSig.GetScriptBytes()) != 0 || len(signedTrx.Vin[1].ScriptWitness.GetScriptWitnessBytes()) != 0 {
+		t.Fatal("unknown input was modified")
+	}
+	if _, _, err = BTCSignRawTransactionWithKeys(rawTrx, []string{ownKeyHex}, nil); err == nil {
+		t.Error("transaction without signed inputs returned")
+	}
+}
+
+func TestBTCKeyOwnsAddress(t *testing.T) {
+	keyBytes, _ := hex.DecodeString(testPrivKeyHex(11))
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+	for _, scriptPubKey := range scriptPubKeys {
+		addr, err := BTCAddressFromScriptPubKey(scriptPubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		owns, err := BTCKeyOwnsAddress(testPrivKeyHex(11), addr)
+		if err != nil || !owns {
+			t.Error("key does not own", addr)
+		}
+		owns, _ = BTCKeyOwnsAddress(testPrivKeyHex(12), addr)
+		if owns {
+			t.Error("foreign key owns", addr)
+		}
+	}
+}
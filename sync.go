@@ -0,0 +1,536 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ybbus/jsonrpc"
+	"time"
+)
+
+type ChainTxIn struct {
+	Coinbase string `json:"coinbase"`
+	Txid     string `json:"txid"`
+	Vout     int    `json:"vout"`
+}
+
+type ChainScriptPubKey struct {
+	Hex string `json:"hex"`
+}
+
+type ChainTxOut struct {
+	Value        json.Number       `json:"value"`
+	N            int               `json:"n"`
+	ScriptPubKey ChainScriptPubKey `json:"scriptPubKey"`
+}
+
+type ChainTx struct {
+	Txid string       `json:"txid"`
+	Vin  []ChainTxIn  `json:"vin"`
+	Vout []ChainTxOut `json:"vout"`
+}
+
+// ChainBlock is a block as returned by getblock with verbosity 2.
+type ChainBlock struct {
+	Hash              string    `json:"hash"`
+	Height            int64     `json:"height"`
+	PreviousBlockHash string    `json:"previousblockhash"`
+	Time              int64     `json:"time"`
+	Tx                []ChainTx `json:"tx"`
+}
+
+// MempoolEntry is the part of getmempoolentry used for fee bumping.
+type MempoolEntry struct {
+	VSize int64 `json:"vsize"`
+	// fee in satoshis
+	Fee int64 `json:"-"`
+}
+
+// ChainNode is the part of the bitcoind RPC interface used by the scanner
+// and the pending reaper.
+type ChainNode interface {
+	GetBlockCount() (int64, error)
+	GetBlockHash(height int64) (string, error)
+	GetBlock(blockHash string) (*ChainBlock, error)
+	// IsInMempool reports whether the node's mempool holds txId.
+	IsInMempool(txId string) (bool, error)
+	// IsOutputUnspent reports whether the output is neither spent in the
+	// chain nor by a mempool transaction.
+	IsOutputUnspent(txId string, vout int) (bool, error)
+	// IsTransactionKnown reports whether txId is in the node's mempool or
+	// in the chain; confirmed transactions are only found in blockHash, or
+	// anywhere when the node keeps a transaction index.
+	IsTransactionKnown(txId string, blockHash string) (bool, error)
+	// SendRawTransaction submits a signed transaction and returns its txid.
+	SendRawTransaction(rawTrx string) (string, error)
+	// GetMempoolEntry returns nil when txId is not in the mempool.
+	GetMempoolEntry(txId string) (*MempoolEntry, error)
+	// GetRawTransaction returns the hex of a mempool transaction, or of a
+	// confirmed one when the node keeps a transaction index.
+	GetRawTransaction(txId string) (string, error)
+	// EstimateSmartFee returns the economical fee rate in sat/vB for
+	// confirmation within confTarget blocks, 0 when the node has no estimate.
+	EstimateSmartFee(confTarget int) (float64, error)
+}
+
+const (
+	// bitcoind's RPC_INVALID_ADDRESS_OR_KEY, returned for unknown transactions
+	rpcErrInvalidAddressOrKey = -5
+	// bitcoind's RPC_VERIFY_ALREADY_IN_CHAIN
+	rpcErrVerifyAlreadyInChain = -27
+)
+
+type rpcChainNode struct {
+	rpcClient jsonrpc.RPCClient
+}
+
+func NewRpcChainNode(serverUrl string) ChainNode {
+	return &rpcChainNode{rpcClient: jsonrpc.NewClient(serverUrl)}
+}
+
+func (n *rpcChainNode) call(out interface{}, method string, params ...interface{}) error {
+	rpcResponse, err := n.rpcClient.Call(method, params...)
+	if err != nil {
+		return err
+	}
+	if rpcResponse.Error != nil {
+		return rpcResponse.Error
+	}
+	if rpcResponse.Result == nil {
+		return errors.New("rpc " + method + " result is nil")
+	}
+	return rpcResponse.GetObject(out)
+}
+
+func (n *rpcChainNode) GetBlockCount() (int64, error) {
+	var count int64
+	err := n.call(&count, "getblockcount")
+	return count, err
+}
+
+func (n *rpcChainNode) GetBlockHash(height int64) (string, error) {
+	var blockHash string
+	err := n.call(&blockHash, "getblockhash", height)
+	return blockHash, err
+}
+
+func (n *rpcChainNode) GetBlock(blockHash string) (*ChainBlock, error) {
+	block := new(ChainBlock)
+	err := n.call(block, "getblock", blockHash, 2)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (n *rpcChainNode) IsInMempool(txId string) (bool, error) {
+	var entry map[string]interface{}
+	err := n.call(&entry, "getmempoolentry", txId)
+	if rpcErr, ok := err.(*jsonrpc.RPCError); ok && rpcErr.Code == rpcErrInvalidAddressOrKey {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (n *rpcChainNode) IsOutputUnspent(txId string, vout int) (bool, error) {
+	// gettxout returns null for spent or unknown outputs
+	rpcResponse, err := n.rpcClient.Call("gettxout", txId, vout, true)
+	if err != nil {
+		return false, err
+	}
+	if rpcResponse.Error != nil {
+		return false, rpcResponse.Error
+	}
+	return rpcResponse.Result != nil, nil
+}
+
+func (n *rpcChainNode) IsTransactionKnown(txId string, blockHash string) (bool, error) {
+	params := []interface{}{txId, false}
+	if blockHash != "" {
+		params = append(params, blockHash)
+	}
+	var rawTrx string
+	err := n.call(&rawTrx, "getrawtransaction", params...)
+	if rpcErr, ok := err.(*jsonrpc.RPCError); ok && rpcErr.Code == rpcErrInvalidAddressOrKey {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (n *rpcChainNode) SendRawTransaction(rawTrx string) (string, error) {
+	var txId string
+	err := n.call(&txId, "sendrawtransaction", rawTrx)
+	return txId, err
+}
+
+func (n *rpcChainNode) GetMempoolEntry(txId string) (*MempoolEntry, error) {
+	var entry struct {
+		VSize int64 `json:"vsize"`
+		// removed in bitcoind 23 in favor of fees.base
+		Fee  json.Number `json:"fee"`
+		Fees struct {
+			Base json.Number `json:"base"`
+		} `json:"fees"`
+	}
+	err := n.call(&entry, "getmempoolentry", txId)
+	if rpcErr, ok := err.(*jsonrpc.RPCError); ok && rpcErr.Code == rpcErrInvalidAddressOrKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fee := entry.Fees.Base
+	if fee == "" {
+		fee = entry.Fee
+	}
+	feeSatoshi, err := ParseBTCAmount(fee.String())
+	if err != nil {
+		return nil, err
+	}
+	return &MempoolEntry{VSize: entry.VSize, Fee: feeSatoshi}, nil
+}
+
+func (n *rpcChainNode) GetRawTransaction(txId string) (string, error) {
+	var rawTrx string
+	err := n.call(&rawTrx, "getrawtransaction", txId)
+	return rawTrx, err
+}
+
+func (n *rpcChainNode) EstimateSmartFee(confTarget int) (float64, error) {
+	var estimate struct {
+		// BTC/kvB, missing when the node lacks data
+		FeeRate json.Number `json:"feerate"`
+	}
+	err := n.call(&estimate, "estimatesmartfee", confTarget, "ECONOMICAL")
+	if err != nil {
+		return 0, err
+	}
+	if estimate.FeeRate == "" {
+		return 0, nil
+	}
+	satPerKvB, err := ParseBTCAmount(estimate.FeeRate.String())
+	if err != nil {
+		return 0, err
+	}
+	return float64(satPerKvB) / 1000, nil
+}
+
+// ChainScanner follows the node's chain and keeps the utxo table in step
+// with the outputs paying to addresses of the address table.
+type ChainScanner struct {
+	Node        ChainNode
+	CoinSymbol  string
+	StartHeight int64
+}
+
+func NewChainScanner(node ChainNode, startHeight int64) *ChainScanner {
+	return &ChainScanner{Node: node, CoinSymbol: "BTC", StartHeight: startHeight}
+}
+
+// SyncOnce processes all blocks between the last processed block and the
+// node's tip and returns the number of blocks processed. Blocks orphaned by
+// a reorganization are rolled back before the new branch is applied.
+func (s *ChainScanner) SyncOnce() (int, error) {
+	tipHeight, err := s.Node.GetBlockCount()
+	if err != nil {
+		return 0, err
+	}
+
+	state, exist, err := GlobalDBMgr.TblSyncStateMgr.GetLastBlock(s.CoinSymbol)
+	if err != nil {
+		return 0, err
+	}
+	nextHeight := s.StartHeight
+	lastHash := ""
+	if exist {
+		forkHeight, err := s.findForkPoint(state.Block_height, state.Block_hash, tipHeight)
+		if err != nil {
+			return 0, err
+		}
+		if forkHeight < state.Block_height {
+			lastHash, err = s.rollback(forkHeight, state.Block_height)
+			if err != nil {
+				return 0, err
+			}
+		} else {
+			lastHash = state.Block_hash
+		}
+		nextHeight = forkHeight + 1
+	} else if nextHeight <= 0 {
+		nextHeight = tipHeight
+	}
+
+	processed := 0
+	for nextHeight <= tipHeight {
+		blockHash, err := s.Node.GetBlockHash(nextHeight)
+		if err != nil {
+			return processed, err
+		}
+		block, err := s.Node.GetBlock(blockHash)
+		if err != nil {
+			return processed, err
+		}
+
+		// the chain changed while following it
+		if lastHash != "" && block.PreviousBlockHash != lastHash {
+			forkHeight, err := s.findForkPoint(nextHeight-1, lastHash, tipHeight)
+			if err != nil {
+				return processed, err
+			}
+			lastHash, err = s.rollback(forkHeight, nextHeight-1)
+			if err != nil {
+				return processed, err
+			}
+			nextHeight = forkHeight + 1
+			continue
+		}
+
+		err = s.ProcessBlock(block)
+		if err != nil {
+			return processed, err
+		}
+		lastHash = block.Hash
+		nextHeight++
+		processed++
+	}
+	return processed, nil
+}
+
+// findForkPoint returns the height of the last stored block still on the
+// node's chain, starting from the stored block lastHash at lastHeight.
+func (s *ChainScanner) findForkPoint(lastHeight int64, lastHash string, tipHeight int64) (int64, error) {
+	height := lastHeight
+	if height > tipHeight {
+		height = tipHeight
+	}
+	for ; height >= 0; height-- {
+		storedHash := lastHash
+		if height != lastHeight {
+			var err error
+			storedHash, err = GlobalDBMgr.TblSyncBlockMgr.GetBlockHash(s.CoinSymbol, height)
+			if err != nil {
+				return 0, err
+			}
+			if storedHash == "" {
+				return 0, errors.New("chain reorganization deeper than the stored block history")
+			}
+		}
+		nodeHash, err := s.Node.GetBlockHash(height)
+		if err != nil {
+			return 0, err
+		}
+		if nodeHash == storedHash {
+			return height, nil
+		}
+	}
+	return 0, errors.New("no common block with the node's chain")
+}
+
+// rollback undoes the blocks above forkHeight up to lastHeight and returns
+// the hash of the block at forkHeight.
+func (s *ChainScanner) rollback(forkHeight int64, lastHeight int64) (string, error) {
+	Info.Printf("chain reorganization: rolling back blocks %d to %d", forkHeight+1, lastHeight)
+
+	forkHash, err := GlobalDBMgr.TblSyncBlockMgr.GetBlockHash(s.CoinSymbol, forkHeight)
+	if err != nil {
+		return "", err
+	}
+	if forkHash == "" {
+		return "", errors.New("fork block not found in the stored block history")
+	}
+	expireAt := time.Now().Add(GlobalConfig.UtxoConfig.PendingExpiry())
+	removed, restored, err := GlobalDBMgr.TblUtxoMgr.RollbackAbove(forkHeight, expireAt)
+	if err != nil {
+		return "", err
+	}
+	Info.Printf("chain reorganization: %d utxos removed, %d spends reverted", removed, restored)
+	_, err = GlobalDBMgr.TblLedgerMgr.DeleteAbove(forkHeight)
+	if err != nil {
+		return "", err
+	}
+	unconfirmed, err := GlobalDBMgr.TblTxMgr.RollbackAbove(forkHeight)
+	if err != nil {
+		return "", err
+	}
+	Info.Printf("chain reorganization: %d transactions unconfirmed", unconfirmed)
+	err = GlobalDBMgr.TblSyncBlockMgr.DeleteAbove(s.CoinSymbol, forkHeight)
+	if err != nil {
+		return "", err
+	}
+	err = GlobalDBMgr.TblSyncStateMgr.SetLastBlock(s.CoinSymbol, forkHash, forkHeight)
+	if err != nil {
+		return "", err
+	}
+	return forkHash, nil
+}
+
+// ProcessBlock records the outputs of block paying to our addresses, marks
+// the outputs spent by it as used and the tracked transactions in it as
+// confirmed, and stores it as the last processed block.
+func (s *ChainScanner) ProcessBlock(block *ChainBlock) error {
+	outputAddrs := make([][]string, len(block.Tx))
+	allOutputAddrs := make([]string, 0)
+	spentTxids := make([]string, 0)
+	for i, tx := range block.Tx {
+		outputAddrs[i] = make([]string, len(tx.Vout))
+		for j, vout := range tx.Vout {
+			addr, err := scriptPubKeyHexToAddress(vout.ScriptPubKey.Hex)
+			if err == nil {
+				outputAddrs[i][j] = addr
+				allOutputAddrs = append(allOutputAddrs, addr)
+			}
+		}
+		for _, vin := range tx.Vin {
+			if vin.Coinbase == "" {
+				spentTxids = append(spentTxids, vin.Txid)
+			}
+		}
+	}
+
+	ourAddrs, err := GlobalDBMgr.TblAddressMgr.FilterExistAddresses(allOutputAddrs)
+	if err != nil {
+		return err
+	}
+	unspent, err := GlobalDBMgr.TblUtxoMgr.ListUnspentByTxids(spentTxids)
+	if err != nil {
+		return err
+	}
+	tracked := make(map[string]utxo)
+	for _, u := range unspent {
+		tracked[fmt.Sprintf("%s:%d", u.Txid, u.Vout)] = u
+	}
+	blockTime := block.Time
+	if blockTime == 0 {
+		blockTime = time.Now().Unix()
+	}
+
+	for i, tx := range block.Tx {
+		isChange := 0
+		// the fee is known when all inputs are ours
+		ourInputs, inputAmount := 0, int64(0)
+		for _, vin := range tx.Vin {
+			if u, ok := tracked[fmt.Sprintf("%s:%d", vin.Txid, vin.Vout)]; ok && vin.Coinbase == "" {
+				ourInputs++
+				inputAmount += int64(u.Amount)
+			}
+		}
+		fee := int64(0)
+		if ourInputs > 0 && ourInputs == len(tx.Vin) {
+			fee = inputAmount
+			for _, vout := range tx.Vout {
+				amount, err := ParseSatoshi(vout.Value.String())
+				if err != nil {
+					fee = 0
+					break
+				}
+				fee -= int64(amount)
+			}
+			if fee < 0 {
+				fee = 0
+			}
+		}
+
+		for _, vin := range tx.Vin {
+			outPoint := fmt.Sprintf("%s:%d", vin.Txid, vin.Vout)
+			if _, ok := tracked[outPoint]; vin.Coinbase != "" || !ok {
+				continue
+			}
+			isChange = 1
+			_, err := GlobalDBMgr.TblUtxoMgr.MarkUtxoSpent(vin.Txid, vin.Vout, tx.Txid, block.Hash, block.Height, blockTime, fee)
+			if err != nil {
+				return err
+			}
+			Info.Printf("utxo %s spent by %s in block %d", outPoint, tx.Txid, block.Height)
+		}
+		for j, vout := range tx.Vout {
+			addr := outputAddrs[i][j]
+			if addr == "" || !ourAddrs[addr] {
+				continue
+			}
+			amount, err := ParseSatoshi(vout.Value.String())
+			if err != nil {
+				return err
+			}
+			err = GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{
+				Txid:         tx.Txid,
+				Vout:         vout.N,
+				Amount:       amount,
+				Address:      addr,
+				Scriptpubkey: vout.ScriptPubKey.Hex,
+				Coin_symbol:  s.CoinSymbol,
+				Block_hash:   block.Hash,
+				Block_height: block.Height,
+				Is_change:    isChange,
+			}, blockTime, fee)
+			if err != nil {
+				return err
+			}
+			// spendable by later transactions of the same block
+			tracked[fmt.Sprintf("%s:%d", tx.Txid, vout.N)] = utxo{Txid: tx.Txid, Vout: vout.N, Amount: amount, Address: addr}
+			Info.Printf("utxo %s:%d of %s found in block %d", tx.Txid, vout.N, addr, block.Height)
+		}
+	}
+
+	txIds := make([]string, 0, len(block.Tx))
+	for _, tx := range block.Tx {
+		txIds = append(txIds, tx.Txid)
+	}
+	confirmed, err := GlobalDBMgr.TblTxMgr.MarkConfirmed(txIds, block.Hash, block.Height)
+	if err != nil {
+		return err
+	}
+	if confirmed > 0 {
+		Info.Printf("%d tracked transactions confirmed in block %d", confirmed, block.Height)
+	}
+
+	err = GlobalDBMgr.TblSyncBlockMgr.AddBlock(s.CoinSymbol, block.Hash, block.Height, block.PreviousBlockHash)
+	if err != nil {
+		return err
+	}
+	return GlobalDBMgr.TblSyncStateMgr.SetLastBlock(s.CoinSymbol, block.Hash, block.Height)
+}
+
+// Run polls the node every interval until stop is closed.
+func (s *ChainScanner) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		processed, err := s.SyncOnce()
+		if err != nil {
+			Error.Println("ChainScanner SyncOnce fail:", err.Error())
+		} else if processed > 0 {
+			Info.Printf("ChainScanner processed %d blocks", processed)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ConfirmationTipHeight returns the height confirmations are counted
+// against, the last block of the scanner, or 0 when the scanner is
+// disabled: the utxo table is then kept by an external process that records
+// no block heights, and confirmation thresholds do not apply.
+func ConfirmationTipHeight() (int64, error) {
+	if !GlobalConfig.SyncConfig.Enable {
+		return 0, nil
+	}
+	return GlobalDBMgr.TblSyncStateMgr.GetTipHeight("BTC")
+}
+
+func scriptPubKeyHexToAddress(scriptPubKeyHex string) (string, error) {
+	scriptPubKey, err := hex.DecodeString(scriptPubKeyHex)
+	if err != nil {
+		return "", err
+	}
+	return BTCAddressFromScriptPubKey(scriptPubKey)
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeChainNode serves a scripted chain; replacing chain simulates a
+// reorganization.
+type fakeChainNode struct {
+	chain   []*ChainBlock
+	blocks  map[string]*ChainBlock
+	mempool []ChainTx
+	// returned by SendRawTransaction when set
+	sendErr error
+	entries map[string]*MempoolEntry
+	raws    map[string]string
+	// returned by EstimateSmartFee
+	feeRate float64
+}
+
+func newFakeChainNode() *fakeChainNode {
+	return &fakeChainNode{blocks: make(map[string]*ChainBlock), entries: make(map[string]*MempoolEntry), raws: make(map[string]string)}
+}
+
+// extend appends a block with txs on top of the chain truncated to height-1.
+func (n *fakeChainNode) extend(height int64, name string, txs ...ChainTx) *ChainBlock {
+	n.chain = n.chain[:height]
+	block := &ChainBlock{Hash: name, Height: height, Tx: txs}
+	if height > 0 {
+		block.PreviousBlockHash = n.chain[height-1].Hash
+	}
+	n.chain = append(n.chain, block)
+	n.blocks[name] = block
+	return block
+}
+
+func (n *fakeChainNode) GetBlockCount() (int64, error) {
+	return int64(len(n.chain) - 1), nil
+}
+
+func (n *fakeChainNode) GetBlockHash(height int64) (string, error) {
+	if height < 0 || height >= int64(len(n.chain)) {
+		return "", fmt.Errorf("block height %d out of range", height)
+	}
+	return n.chain[height].Hash, nil
+}
+
+func (n *fakeChainNode) GetBlock(blockHash string) (*ChainBlock, error) {
+	block, ok := n.blocks[blockHash]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", blockHash)
+	}
+	return block, nil
+}
+
+func (n *fakeChainNode) IsInMempool(txId string) (bool, error) {
+	for _, tx := range n.mempool {
+		if tx.Txid == txId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (n *fakeChainNode) IsOutputUnspent(txId string, vout int) (bool, error) {
+	txs := append([]ChainTx{}, n.mempool...)
+	for _, block := range n.chain {
+		txs = append(txs, block.Tx...)
+	}
+	exist := false
+	for _, tx := range txs {
+		for _, vin := range tx.Vin {
+			if vin.Txid == txId && vin.Vout == vout {
+				return false, nil
+			}
+		}
+		if tx.Txid == txId && vout < len(tx.Vout) {
+			exist = true
+		}
+	}
+	return exist, nil
+}
+
+func (n *fakeChainNode) IsTransactionKnown(txId string, blockHash string) (bool, error) {
+	txs := append([]ChainTx{}, n.mempool...)
+	for _, block := range n.chain {
+		if blockHash == "" || block.Hash == blockHash {
+			txs = append(txs, block.Tx...)
+		}
+	}
+	for _, tx := range txs {
+		if tx.Txid == txId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (n *fakeChainNode) SendRawTransaction(rawTrx string) (string, error) {
+	if n.sendErr != nil {
+		return "", n.sendErr
+	}
+	decoded, err := BTCDecodeRawTransaction(rawTrx, nil)
+	if err != nil {
+		return "", err
+	}
+	tx := ChainTx{Txid: decoded.TxId}
+	for _, vin := range decoded.Vin {
+		tx.Vin = append(tx.Vin, ChainTxIn{Txid: vin.TxId, Vout: int(vin.Vout)})
+	}
+	for _, vout := range decoded.Vout {
+		tx.Vout = append(tx.Vout, ChainTxOut{N: vout.N, ScriptPubKey: ChainScriptPubKey{Hex: vout.ScriptPubKey}})
+	}
+	n.mempool = append(n.mempool, tx)
+	n.raws[tx.Txid] = rawTrx
+	return tx.Txid, nil
+}
+
+func (n *fakeChainNode) GetMempoolEntry(txId string) (*MempoolEntry, error) {
+	return n.entries[txId], nil
+}
+
+func (n *fakeChainNode) GetRawTransaction(txId string) (string, error) {
+	rawTrx, ok := n.raws[txId]
+	if !ok {
+		return "", fmt.Errorf("transaction %s not found", txId)
+	}
+	return rawTrx, nil
+}
+
+func (n *fakeChainNode) EstimateSmartFee(confTarget int) (float64, error) {
+	return n.feeRate, nil
+}
+
+func testChainTx(txid string, spends []string, payTo ...string) ChainTx {
+	tx := ChainTx{Txid: txid}
+	if len(spends) == 0 {
+		tx.Vin = append(tx.Vin, ChainTxIn{Coinbase: "00"})
+	}
+	for _, spend := range spends {
+		var vin ChainTxIn
+		_, _ = fmt.Sscanf(spend, "%s %d", &vin.Txid, &vin.Vout)
+		tx.Vin = append(tx.Vin, vin)
+	}
+	for i, scriptPubKeyHex := range payTo {
+		tx.Vout = append(tx.Vout, ChainTxOut{Value: "0.50000000", N: i, ScriptPubKey: ChainScriptPubKey{Hex: scriptPubKeyHex}})
+	}
+	return tx
+}
+
+func testTrackedUtxo(t *testing.T, txid string, vout int) (utxo, bool) {
+	var u utxo
+	exist, err := GetDBEngine().Where("txid=? and vout=?", txid, vout).Get(&u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u, exist
+}
+
+func TestRpcChainNode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Id     int           `json:"id"`
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		result := ""
+		switch req.Method {
+		case "getblockcount":
+			result = `101`
+		case "getblockhash":
+			result = `"00000000000000000000000000000000000000000000000000000000000000aa"`
+		case "getmempoolentry":
+			if req.Params[0].(string) != "t1" {
+				_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"error":{"code":-5,"message":"Transaction not in mempool"}}`))
+				return
+			}
+			result = `{"vsize":141,"fees":{"base":0.00001410}}`
+		case "getrawtransaction":
+			result = `"0200"`
+		case "estimatesmartfee":
+			result = `{"feerate":0.00002500,"blocks":144}`
+			if req.Params[0].(float64) == 2 {
+				result = `{"errors":["Insufficient data or no feerate found"],"blocks":0}`
+			}
+		case "gettxout":
+			result = `null`
+			if req.Params[0].(string) == "t0" {
+				result = `{"bestblock":"aa","confirmations":1,"value":0.00010000}`
+			}
+		case "getblock":
+			if req.Params[1].(float64) != 2 {
+				t.Error("getblock not called with verbosity 2")
+			}
+			result = `{"hash":"` + req.Params[0].(string) + `","height":101,"previousblockhash":"99","tx":[` +
+				`{"txid":"t1","vin":[{"coinbase":"03650b0a"}],"vout":[{"value":6.25000000,"n":0,"scriptPubKey":{"hex":"0014aa"}}]},` +
+				`{"txid":"t2","vin":[{"txid":"t0","vout":3}],"vout":[{"value":0.00010000,"n":1,"scriptPubKey":{"hex":"51"}}]}]}`
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":` + result + `}`))
+	}))
+	defer server.Close()
+
+	node := NewRpcChainNode(server.URL)
+	count, err := node.GetBlockCount()
+	if err != nil || count != 101 {
+		t.Fatal("unexpected block count", count, err)
+	}
+	blockHash, err := node.GetBlockHash(101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := node.GetBlock(blockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash != blockHash || block.Height != 101 || len(block.Tx) != 2 {
+		t.Fatal("unexpected block", block)
+	}
+	if block.Tx[0].Vin[0].Coinbase == "" || block.Tx[1].Vin[0].Txid != "t0" || block.Tx[1].Vin[0].Vout != 3 {
+		t.Error("unexpected inputs")
+	}
+	if block.Tx[1].Vout[0].Value.String() != "0.00010000" || block.Tx[1].Vout[0].N != 1 || block.Tx[1].Vout[0].ScriptPubKey.Hex != "51" {
+		t.Error("unexpected outputs", block.Tx[1].Vout[0])
+	}
+
+	inMempool, err := node.IsInMempool("t1")
+	if err != nil || !inMempool {
+		t.Error("t1 not found in mempool", err)
+	}
+	inMempool, err = node.IsInMempool("t2")
+	if err != nil || inMempool {
+		t.Error("t2 found in mempool", err)
+	}
+	entry, err := node.GetMempoolEntry("t1")
+	if err != nil || entry == nil || entry.VSize != 141 || entry.Fee != 1410 {
+		t.Error("unexpected mempool entry", entry, err)
+	}
+	entry, err = node.GetMempoolEntry("t2")
+	if err != nil || entry != nil {
+		t.Error("unexpected mempool entry", entry, err)
+	}
+	rawTrx, err := node.GetRawTransaction("t1")
+	if err != nil || rawTrx != "0200" {
+		t.Error("unexpected raw transaction", rawTrx, err)
+	}
+	feeRate, err := node.EstimateSmartFee(144)
+	if err != nil || feeRate != 2.5 {
+		t.Error("unexpected fee estimate", feeRate, err)
+	}
+	feeRate, err = node.EstimateSmartFee(2)
+	if err != nil || feeRate != 0 {
+		t.Error("unexpected fee estimate", feeRate, err)
+	}
+	unspent, err := node.IsOutputUnspent("t0", 3)
+	if err != nil || !unspent {
+		t.Error("t0:3 not unspent", err)
+	}
+	unspent, err = node.IsOutputUnspent("t1", 0)
+	if err != nil || unspent {
+		t.Error("t1:0 unspent", err)
+	}
+}
+
+func TestChainScannerReorg(t *testing.T) {
+	testInitDB(t)
+
+	keyBytes, _ := hex.DecodeString(testPrivKeyHex(11))
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+	ours := hex.EncodeToString(scriptPubKeys[1])
+	ourAddr, _ := BTCAddressFromScriptPubKey(scriptPubKeys[1])
+	foreign := "0014" + "0000000000000000000000000000000000000000"
+	_, _, err := GlobalDBMgr.TblAddressMgr.AddNewAddresses([]address{{Address: ourAddr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := newFakeChainNode()
+	node.extend(0, "h0")
+	node.extend(1, "h1", testChainTx("a", nil, ours))
+	node.extend(2, "h2", testChainTx("b", []string{"a 0"}, foreign, ours))
+	node.extend(3, "h3", testChainTx("c", nil, ours))
+
+	scanner := NewChainScanner(node, 1)
+	processed, err := scanner.SyncOnce()
+	if err != nil || processed != 3 {
+		t.Fatal("unexpected sync result", processed, err)
+	}
+	a, _ := testTrackedUtxo(t, "a", 0)
+	if a.Used != 1 || a.Spent_txid != "b" || a.Spent_block_hash != "h2" || a.Spent_block_height != 2 {
+		t.Fatal("spend of a:0 not recorded", a)
+	}
+	b, exist := testTrackedUtxo(t, "b", 1)
+	if !exist || b.Used != 0 || b.Block_hash != "h2" || b.Block_height != 2 {
+		t.Fatal("b:1 not recorded", b)
+	}
+	if _, exist = testTrackedUtxo(t, "b", 0); exist {
+		t.Fatal("foreign output recorded")
+	}
+
+	// blocks 2 and 3 are orphaned, b returns to the mempool
+	node.extend(2, "h2'", testChainTx("d", nil, ours))
+	node.extend(3, "h3'")
+	node.extend(4, "h4'")
+	processed, err = scanner.SyncOnce()
+	if err != nil || processed != 3 {
+		t.Fatal("unexpected sync result", processed, err)
+	}
+	a, _ = testTrackedUtxo(t, "a", 0)
+	if a.Used != 0 || a.Spent_txid != "" || a.Spent_block_height != 0 {
+		t.Fatal("spend of a:0 not rolled back", a)
+	}
+	if a.Pending != 1 || a.Pending_txid != "b" || !a.Pending_expire_at.After(time.Now()) {
+		t.Fatal("a:0 not reserved for its spending transaction", a)
+	}
+	for _, orphaned := range []string{"b", "c"} {
+		if _, exist = testTrackedUtxo(t, orphaned, 0); exist {
+			t.Fatal("output of orphaned block kept:", orphaned)
+		}
+	}
+	if _, exist = testTrackedUtxo(t, "b", 1); exist {
+		t.Fatal("output of orphaned block kept: b")
+	}
+	d, exist := testTrackedUtxo(t, "d", 0)
+	if !exist || d.Block_hash != "h2'" || d.Block_height != 2 {
+		t.Fatal("output of the new branch not recorded", d)
+	}
+	state, _, _ := GlobalDBMgr.TblSyncStateMgr.GetLastBlock("BTC")
+	if state.Block_hash != "h4'" || state.Block_height != 4 {
+		t.Fatal("unexpected last block", state)
+	}
+
+	// the node rewinds to a shorter branch that spends a again
+	node.extend(2, "h2\"", testChainTx("e", []string{"a 0"}, foreign))
+	processed, err = scanner.SyncOnce()
+	if err != nil || processed != 1 {
+		t.Fatal("unexpected sync result", processed, err)
+	}
+	a, _ = testTrackedUtxo(t, "a", 0)
+	if a.Used != 1 || a.Spent_txid != "e" || a.Spent_block_hash != "h2\"" {
+		t.Fatal("spend on the new branch not recorded", a)
+	}
+	if _, exist = testTrackedUtxo(t, "d", 0); exist {
+		t.Fatal("output of orphaned block kept: d")
+	}
+}
+
+func TestChainScannerSameBlockSpend(t *testing.T) {
+	testInitDB(t)
+
+	keyBytes, _ := hex.DecodeString(testPrivKeyHex(12))
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+	ours := hex.EncodeToString(scriptPubKeys[0])
+	ourAddr, _ := BTCAddressFromScriptPubKey(scriptPubKeys[0])
+	_, _, _ = GlobalDBMgr.TblAddressMgr.AddNewAddresses([]address{{Address: ourAddr}})
+
+	node := newFakeChainNode()
+	node.extend(0, "h0")
+	node.extend(1, "h1", testChainTx("a", nil, ours), testChainTx("b", []string{"a 0"}, ours))
+	_, err := NewChainScanner(node, 1).SyncOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := testTrackedUtxo(t, "a", 0)
+	b, _ := testTrackedUtxo(t, "b", 0)
+	if a.Used != 1 || a.Spent_txid != "b" || b.Used != 0 || b.Address != ourAddr {
+		t.Fatal("unexpected utxos", a, b)
+	}
+	if a.Is_change != 0 || b.Is_change != 1 {
+		t.Fatal("change not detected", a.Is_change, b.Is_change)
+	}
+}
+
+func TestChainScannerLedger(t *testing.T) {
+	testInitDB(t)
+
+	keyBytes, _ := hex.DecodeString(testPrivKeyHex(13))
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(keyBytes)
+	ours := hex.EncodeToString(scriptPubKeys[1])
+	ourAddr, _ := BTCAddressFromScriptPubKey(scriptPubKeys[1])
+	foreign := "0014" + "0000000000000000000000000000000000000000"
+	_, _, err := GlobalDBMgr.TblAddressMgr.AddNewAddresses([]address{{Address: ourAddr, Account: "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := newFakeChainNode()
+	node.extend(0, "h0")
+	node.extend(1, "h1", testChainTx("a", nil, ours)).Time = 1000
+	spend := testChainTx("b", []string{"a 0"}, foreign, ours)
+	spend.Vout[0].Value, spend.Vout[1].Value = "0.30000000", "0.19990000"
+	node.extend(2, "h2", spend).Time = 2000
+	node.extend(3, "h3", testChainTx("c", nil, ours)).Time = 3000
+	if _, err = NewChainScanner(node, 1).SyncOnce(); err != nil {
+		t.Fatal(err)
+	}
+
+	txs, cursor, err := GlobalDBMgr.TblLedgerMgr.ListTransactions(LedgerQuery{Address: ourAddr})
+	if err != nil || len(txs) != 3 || cursor != "" {
+		t.Fatal("unexpected transactions", txs, cursor, err)
+	}
+	if txs[0].Txid != "c" || txs[0].Net != 50000000 || txs[0].Block_time != 3000 || txs[2].Txid != "a" {
+		t.Fatal("unexpected transactions", txs)
+	}
+	b := txs[1]
+	if b.Txid != "b" || b.Received != 19990000 || b.Sent != 50000000 || b.Net != -30010000 || b.Fee != 10000 ||
+		b.Block_height != 2 || len(b.Entries) != 2 {
+		t.Fatal("unexpected spend", b)
+	}
+	if b.Entries[0].Entry_type != LedgerEntrySpend || b.Entries[0].Utxo_txid != "a" || b.Entries[0].Amount != -50000000 {
+		t.Fatal("unexpected spend entry", b.Entries[0])
+	}
+
+	// pages of two from the account, then a block time window
+	txs, cursor, err = GlobalDBMgr.TblLedgerMgr.ListTransactions(LedgerQuery{Account: "alice", Limit: 2})
+	if err != nil || len(txs) != 2 || cursor == "" {
+		t.Fatal("unexpected page", txs, cursor, err)
+	}
+	txs, cursor, err = GlobalDBMgr.TblLedgerMgr.ListTransactions(LedgerQuery{Account: "alice", Limit: 2, Cursor: cursor})
+	if err != nil || len(txs) != 1 || txs[0].Txid != "a" || cursor != "" {
+		t.Fatal("unexpected page", txs, cursor, err)
+	}
+	txs, _, err = GlobalDBMgr.TblLedgerMgr.ListTransactions(LedgerQuery{Address: ourAddr, Since: 2000, Until: 3000})
+	if err != nil || len(txs) != 1 || txs[0].Txid != "b" {
+		t.Fatal("unexpected window", txs, err)
+	}
+
+	// block 3 is orphaned
+	node.extend(3, "h3'")
+	node.extend(4, "h4'")
+	if _, err = NewChainScanner(node, 1).SyncOnce(); err != nil {
+		t.Fatal(err)
+	}
+	txs, _, err = GlobalDBMgr.TblLedgerMgr.ListTransactions(LedgerQuery{Address: ourAddr})
+	if err != nil || len(txs) != 2 || txs[0].Txid != "b" {
+		t.Fatal("entries of an orphaned block kept", txs, err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/mutalisk999/bitcoin-lib/src/utility"
+	"math/big"
+)
+
+// BTCTaggedHash implements the BIP340 tagged hash
+// sha256(sha256(tag) || sha256(tag) || msg).
+func BTCTaggedHash(tag string, msgs ...[]byte) []byte {
+	tagHash := utility.Sha256([]byte(tag))
+	data := make([]byte, 0, 64)
+	data = append(data, tagHash...)
+	data = append(data, tagHash...)
+	for _, msg := range msgs {
+		data = append(data, msg...)
+	}
+	return utility.Sha256(data)
+}
+
+// BTCLiftX returns the point with the given x coordinate and an even y
+// coordinate, as defined by BIP340.
+func BTCLiftX(xOnlyPubKey []byte) (*btcec.PublicKey, error) {
+	if len(xOnlyPubKey) != 32 {
+		return nil, errors.New("invalid x-only pubkey size")
+	}
+	pubKeyBytes := make([]byte, 0, 33)
+	pubKeyBytes = append(pubKeyBytes, 0x2)
+	pubKeyBytes = append(pubKeyBytes, xOnlyPubKey...)
+	return btcec.ParsePubKey(pubKeyBytes, btcec.S256())
+}
+
+func BTCXOnlyPubKey(pubKey *btcec.PublicKey) []byte {
+	return pubKey.SerializeCompressed()[1:]
+}
+
+func padTo32(b []byte) []byte {
+	if len(b) >= 32 {
+		return b
+	}
+	padded := make([]byte, 32)
+	copy(padded[32-len(b):], b)
+	return padded
+}
+
+// BTCTaprootTweakPubKey computes the BIP341 output key Q = P + H_TapTweak(P || merkleRoot)G
+// for an x-only internal key. An empty merkleRoot means a key-path only output.
+func BTCTaprootTweakPubKey(internalXOnly []byte, merkleRoot []byte) ([]byte, error) {
+	internalKey, err := BTCLiftX(internalXOnly)
+	if err != nil {
+		return nil, err
+	}
+	curve := btcec.S256()
+	tweak := new(big.Int).SetBytes(BTCTaggedHash("TapTweak", internalXOnly, merkleRoot))
+	if tweak.Cmp(curve.N) >= 0 {
+		return nil, errors.New("taproot tweak out of range")
+	}
+	tx, ty := curve.ScalarBaseMult(padTo32(tweak.Bytes()))
+	qx, qy := curve.Add(internalKey.X, internalKey.Y, tx, ty)
+	if qx.Sign() == 0 && qy.Sign() == 0 {
+		return nil, errors.New("taproot tweak results in infinity")
+	}
+	return padTo32(qx.Bytes()), nil
+}
+
+func hasEvenY(y *big.Int) bool {
+	return y.Bit(0) == 0
+}
+
+// BTCTaprootTweakPrivKey returns the private key of the key path output
+// key for internal private key privKeyBytes.
+func BTCTaprootTweakPrivKey(privKeyBytes []byte, merkleRoot []byte) ([]byte, error) {
+	curve := btcec.S256()
+	privKey, pubKey := btcec.PrivKeyFromBytes(curve, privKeyBytes)
+	d := new(big.Int).Set(privKey.D)
+	if !hasEvenY(pubKey.Y) {
+		d.Sub(curve.N, d)
+	}
+	tweak := new(big.Int).SetBytes(BTCTaggedHash("TapTweak", BTCXOnlyPubKey(pubKey), merkleRoot))
+	if tweak.Cmp(curve.N) >= 0 {
+		return nil, errors.New("taproot tweak out of range")
+	}
+	d.Add(d, tweak)
+	d.Mod(d, curve.N)
+	if d.Sign() == 0 {
+		return nil, errors.New("taproot tweak results in zero key")
+	}
+	return padTo32(d.Bytes()), nil
+}
+
+// BTCSchnorrSign creates a BIP340 signature of the 32 byte msg.
+func BTCSchnorrSign(privKeyBytes []byte, msg []byte, auxRand []byte) ([]byte, error) {
+	if len(msg) != 32 {
+		return nil, errors.New("invalid schnorr message size")
+	}
+	if len(auxRand) != 32 {
+		return nil, errors.New("invalid schnorr aux rand size")
+	}
+	curve := btcec.S256()
+	d := new(big.Int).SetBytes(privKeyBytes)
+	if d.Sign() == 0 || d.Cmp(curve.N) >= 0 {
+		return nil, errors.New("invalid private key")
+	}
+	px, py := curve.ScalarBaseMult(padTo32(d.Bytes()))
+	if !hasEvenY(py) {
+		d.Sub(curve.N, d)
+	}
+	pBytes := padTo32(px.Bytes())
+
+	t := padTo32(d.Bytes())
+	auxHash := BTCTaggedHash("BIP0340/aux", auxRand)
+	for i := range t {
+		t[i] ^= auxHash[i]
+	}
+	k := new(big.Int).SetBytes(BTCTaggedHash("BIP0340/nonce", t, pBytes, msg))
+	k.Mod(k, curve.N)
+	if k.Sign() == 0 {
+		return nil, errors.New("schnorr nonce is zero")
+	}
+	rx, ry := curve.ScalarBaseMult(padTo32(k.Bytes()))
+	if !hasEvenY(ry) {
+		k.Sub(curve.N, k)
+	}
+	rBytes := padTo32(rx.Bytes())
+
+	e := new(big.Int).SetBytes(BTCTaggedHash("BIP0340/challenge", rBytes, pBytes, msg))
+	e.Mod(e, curve.N)
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, curve.N)
+
+	signature := make([]byte, 0, 64)
+	signature = append(signature, rBytes...)
+	signature = append(signature, padTo32(s.Bytes())...)
+	return signature, nil
+}
+
+// BTCSchnorrVerify verifies a BIP340 signature against an x-only pubkey.
+func BTCSchnorrVerify(xOnlyPubKey []byte, msg []byte, signature []byte) bool {
+	if len(msg) != 32 || len(signature) != 64 {
+		return false
+	}
+	curve := btcec.S256()
+	pubKey, err := BTCLiftX(xOnlyPubKey)
+	if err != nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[0:32])
+	s := new(big.Int).SetBytes(signature[32:64])
+	if r.Cmp(curve.P) >= 0 || s.Cmp(curve.N) >= 0 {
+		return false
+	}
+	e := new(big.Int).SetBytes(BTCTaggedHash("BIP0340/challenge", signature[0:32], xOnlyPubKey, msg))
+	e.Mod(e, curve.N)
+
+	// R = s*G - e*P
+	sx, sy := curve.ScalarBaseMult(padTo32(s.Bytes()))
+	ex, ey := curve.ScalarMult(pubKey.X, pubKey.Y, padTo32(e.Bytes()))
+	ey = new(big.Int).Sub(curve.P, ey)
+	rx, ry := curve.Add(sx, sy, ex, ey)
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return false
+	}
+	return hasEvenY(ry) && rx.Cmp(r) == 0
+}
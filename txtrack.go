@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mutalisk999/bitcoin-lib/src/transaction"
+	"github.com/ybbus/jsonrpc"
+	"time"
+)
+
+// BroadcastTransaction records rawTrx, submits it to node and reserves its
+// tracked inputs for it until the transaction confirms, is replaced or
+// fails. The txid is returned even when the node rejects the transaction;
+// a rejected replacement hands the inputs back to the original. Only a
+// rejection by the node fails the transaction, and never one the node
+// already holds.
+func BroadcastTransaction(node ChainNode, rawTrx string) (string, error) {
+	trx, err := BTCUnPackRawTransaction(rawTrx)
+	if err != nil {
+		return "", err
+	}
+	trxId, err := trx.CalcTrxId()
+	if err != nil {
+		return "", err
+	}
+	txId := trxId.GetHex()
+
+	err = GlobalDBMgr.TblTxMgr.SaveSigned(txId, rawTrx)
+	if err != nil {
+		return txId, err
+	}
+	record, _, err := GlobalDBMgr.TblTxMgr.GetTx(txId)
+	if err != nil {
+		return txId, err
+	}
+	_, err = node.SendRawTransaction(rawTrx)
+	if rpcErr, ok := err.(*jsonrpc.RPCError); ok && rpcErr.Code == rpcErrVerifyAlreadyInChain {
+		// a retry of a transaction that confirmed meanwhile
+		err = nil
+	}
+	if rpcErr, ok := err.(*jsonrpc.RPCError); ok {
+		Error.Printf("sendrawtransaction %s rejected: %s", txId, rpcErr.Error())
+		failed, _ := GlobalDBMgr.TblTxMgr.SetStatus(txId, TxStatusFailed, rpcErr.Error(), txStatusesNotSent...)
+		if failed {
+			_, _ = GlobalDBMgr.TblUtxoMgr.ReleaseByPendingTxid(txId)
+			if record.Replaces != "" {
+				// the original keeps its inputs
+				_ = reserveOriginalInputs(record.Replaces)
+			}
+		}
+		return txId, err
+	}
+	if err != nil {
+		// the node may have accepted it before the call failed, its inputs
+		// stay reserved until the pending reaper finds it missing
+		Error.Printf("sendrawtransaction %s fail: %s", txId, err.Error())
+		_ = reserveTrackedInputs(trx, txId, record.Replaces)
+		return txId, err
+	}
+	_, err = GlobalDBMgr.TblTxMgr.SetStatus(txId, TxStatusBroadcast, "", txStatusesNotSent...)
+	if err != nil {
+		return txId, err
+	}
+	if record.Replaces != "" {
+		// accepted by the node, so the original left its mempool
+		err = GlobalDBMgr.TblTxMgr.SetReplaced(record.Replaces, txId)
+		if err != nil {
+			return txId, err
+		}
+	}
+	return txId, reserveTrackedInputs(trx, txId, record.Replaces)
+}
+
+// reserveTrackedInputs reserves the unspent tracked outputs spent by trx
+// for txId in one database transaction, taking them over from replacedTxId
+// when txId replaces it.
+func reserveTrackedInputs(trx *transaction.Transaction, txId string, replacedTxId string) error {
+	prevTxIds := make([]string, 0, len(trx.Vin))
+	spends := make(map[string]bool)
+	for _, vin := range trx.Vin {
+		prevTxIds = append(prevTxIds, vin.PrevOut.Hash.GetHex())
+		spends[outPointKey(vin.PrevOut.Hash.GetHex(), int(vin.PrevOut.N))] = true
+	}
+	unspent, err := GlobalDBMgr.TblUtxoMgr.ListUnspentByTxids(prevTxIds)
+	if err != nil {
+		return err
+	}
+	outPoints := make([]OutPoint, 0, len(unspent))
+	for _, u := range unspent {
+		if spends[outPointKey(u.Txid, u.Vout)] {
+			outPoints = append(outPoints, OutPoint{Txid: u.Txid, Vout: u.Vout})
+		}
+	}
+	expireAt := time.Now().Add(GlobalConfig.UtxoConfig.PendingExpiry())
+	return GlobalDBMgr.TblUtxoMgr.ReserveUtxos(outPoints, txId, expireAt, replacedTxId)
+}
+
+func reserveOriginalInputs(txId string) error {
+	record, exist, err := GlobalDBMgr.TblTxMgr.GetTx(txId)
+	if err != nil || !exist {
+		return err
+	}
+	trx, err := BTCUnPackRawTransaction(record.Raw)
+	if err != nil {
+		return err
+	}
+	return reserveTrackedInputs(trx, txId, "")
+}
+
+// RecordSignedTransaction stores rawTrx as signed once every input of it
+// verified, so that it can be tracked after broadcasting.
+func RecordSignedTransaction(rawTrx string, report []InputVerification) error {
+	for _, v := range report {
+		if v.Status != InputVerifyStatusVerified {
+			return nil
+		}
+	}
+	trx, err := BTCUnPackRawTransaction(rawTrx)
+	if err != nil {
+		return err
+	}
+	trxId, err := trx.CalcTrxId()
+	if err != nil {
+		return err
+	}
+	return GlobalDBMgr.TblTxMgr.SaveSigned(trxId.GetHex(), rawTrx)
+}
+
+// abandonSignedTransactions marks transactions signed but not broadcast
+// failed and releases their inputs.
+func abandonSignedTransactions(txIds []string, reason string) {
+	for _, txId := range txIds {
+		failed, _ := GlobalDBMgr.TblTxMgr.SetStatus(txId, TxStatusFailed, reason, TxStatusSigned)
+		if failed {
+			_, _ = GlobalDBMgr.TblUtxoMgr.ReleaseByPendingTxid(txId)
+		}
+	}
+}
+
+func outPointKey(txId string, vout int) string {
+	return fmt.Sprintf("%s:%d", txId, vout)
+}
+
+// TxTracker follows the broadcast transactions in the node's mempool.
+// Confirmations are recorded by the chain scanner.
+type TxTracker struct {
+	Node ChainNode
+}
+
+func NewTxTracker(node ChainNode) *TxTracker {
+	return &TxTracker{Node: node}
+}
+
+// PollOnce updates the status of the broadcast transactions and returns the
+// number of status changes. A transaction missing from the mempool is
+// replaced when one of its inputs was spent by another transaction, and
+// failed when all of its inputs are still unspent; otherwise it is left to
+// the scanner to confirm.
+func (t *TxTracker) PollOnce() (int, error) {
+	records, err := GlobalDBMgr.TblTxMgr.ListTxsByStatus(TxStatusBroadcast, TxStatusInMempool)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, record := range records {
+		status, replacedBy, err := t.checkTx(record)
+		if err != nil {
+			return changed, err
+		}
+		if status == record.Status {
+			continue
+		}
+		moved := true
+		switch status {
+		case TxStatusReplaced:
+			err = GlobalDBMgr.TblTxMgr.SetReplaced(record.Txid, replacedBy)
+		case TxStatusFailed:
+			moved, err = GlobalDBMgr.TblTxMgr.SetStatus(record.Txid, status, "dropped from the mempool", record.Status)
+		default:
+			moved, err = GlobalDBMgr.TblTxMgr.SetStatus(record.Txid, status, "", record.Status)
+		}
+		if err != nil {
+			return changed, err
+		}
+		if !moved {
+			// confirmed by the scanner meanwhile
+			continue
+		}
+		if status == TxStatusReplaced || status == TxStatusFailed {
+			_, err = GlobalDBMgr.TblUtxoMgr.ReleaseByPendingTxid(record.Txid)
+			if err != nil {
+				return changed, err
+			}
+		}
+		changed++
+		Info.Printf("transaction %s is %s", record.Txid, status)
+	}
+	return changed, nil
+}
+
+func (t *TxTracker) checkTx(record tx) (string, string, error) {
+	inMempool, err := t.Node.IsInMempool(record.Txid)
+	if err != nil {
+		return "", "", err
+	}
+	if inMempool {
+		return TxStatusInMempool, "", nil
+	}
+
+	trx, err := BTCUnPackRawTransaction(record.Raw)
+	if err != nil {
+		return "", "", err
+	}
+	allUnspent := true
+	for _, vin := range trx.Vin {
+		prevTxId := vin.PrevOut.Hash.GetHex()
+		u, exist, err := GlobalDBMgr.TblUtxoMgr.GetUtxo(prevTxId, int(vin.PrevOut.N))
+		if err != nil {
+			return "", "", err
+		}
+		if exist && u.Used == 1 && u.Spent_txid != record.Txid {
+			return TxStatusReplaced, u.Spent_txid, nil
+		}
+		unspent, err := t.Node.IsOutputUnspent(prevTxId, int(vin.PrevOut.N))
+		if err != nil {
+			return "", "", err
+		}
+		allUnspent = allUnspent && unspent
+	}
+	if allUnspent {
+		return TxStatusFailed, "", nil
+	}
+	return record.Status, "", nil
+}
+
+// Run polls every interval until stop is closed.
+func (t *TxTracker) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		changed, err := t.PollOnce()
+		if err != nil {
+			Error.Println("TxTracker PollOnce fail:", err.Error())
+		} else if changed > 0 {
+			Info.Printf("TxTracker updated %d transactions", changed)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
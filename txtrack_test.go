@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"github.com/ybbus/jsonrpc"
+	"testing"
+)
+
+var errTestRejected = &jsonrpc.RPCError{Code: -26, Message: "bad-txns-inputs-missingorspent"}
+
+func testTxStatus(t *testing.T, txId string) tx {
+	record, exist, err := GlobalDBMgr.TblTxMgr.GetTx(txId)
+	if err != nil || !exist {
+		t.Fatal("transaction not tracked", txId, err)
+	}
+	return record
+}
+
+func TestBroadcastAndTrackTransaction(t *testing.T) {
+	testInitDB(t)
+
+	rawTrx := testUnsignedTrx(2, 1)
+	decoded, err := BTCDecodeRawTransaction(rawTrx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in0, in1 := decoded.Vin[0], decoded.Vin[1]
+	node := newFakeChainNode()
+	node.extend(0, "h0", testChainTx(in0.TxId, nil, "51"), testChainTx(in1.TxId, nil, "51", "51"))
+	for _, in := range decoded.Vin {
+		err = GlobalDBMgr.TblUtxoMgr.AddUtxo(utxo{Txid: in.TxId, Vout: int(in.Vout), Amount: 50000000, Address: "addr"}, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	scanner := NewChainScanner(node, 0)
+	if _, err = scanner.SyncOnce(); err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTxTracker(node)
+
+	txId, err := BroadcastTransaction(node, rawTrx)
+	if err != nil || txId != decoded.TxId {
+		t.Fatal("broadcast fail", txId, err)
+	}
+	if record := testTxStatus(t, txId); record.Status != TxStatusBroadcast || record.Raw != rawTrx {
+		t.Fatal("unexpected record", record)
+	}
+	pending, _ := GlobalDBMgr.TblUtxoMgr.ListPendingUtxos("")
+	if len(pending) != 2 || pending[0].Pending_txid != txId || pending[1].Pending_txid != txId {
+		t.Fatal("inputs not reserved", pending)
+	}
+
+	changed, err := tracker.PollOnce()
+	if err != nil || changed != 1 || testTxStatus(t, txId).Status != TxStatusInMempool {
+		t.Fatal("mempool status not recorded", changed, err)
+	}
+
+	// evicted while its inputs stay unspent
+	node.mempool = nil
+	changed, err = tracker.PollOnce()
+	if err != nil || changed != 1 || testTxStatus(t, txId).Status != TxStatusFailed {
+		t.Fatal("dropped transaction not failed", changed, err)
+	}
+	if pending, _ = GlobalDBMgr.TblUtxoMgr.ListPendingUtxos(""); len(pending) != 0 {
+		t.Fatal("reservations of a failed transaction kept", pending)
+	}
+
+	// rebroadcast and confirmed
+	_, err = BroadcastTransaction(node, rawTrx)
+	if err != nil || testTxStatus(t, txId).Status != TxStatusBroadcast {
+		t.Fatal("rebroadcast fail", err)
+	}
+	node.extend(1, "h1", node.mempool...)
+	node.mempool = nil
+	_, err = scanner.SyncOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := testTxStatus(t, txId); record.Status != TxStatusConfirmed || record.Block_hash != "h1" || record.Block_height != 1 {
+		t.Fatal("confirmation not recorded", record)
+	}
+	if changed, err = tracker.PollOnce(); err != nil || changed != 0 {
+		t.Fatal("confirmed transaction polled", changed, err)
+	}
+
+	// retries of a confirmed transaction do not touch it
+	for _, sendErr := range []error{&jsonrpc.RPCError{Code: rpcErrVerifyAlreadyInChain, Message: "Transaction already in block chain"},
+		errTestRejected} {
+		node.sendErr = sendErr
+		_, _ = BroadcastTransaction(node, rawTrx)
+		if record := testTxStatus(t, txId); record.Status != TxStatusConfirmed {
+			t.Fatal("confirmed transaction downgraded", record)
+		}
+	}
+	node.sendErr = nil
+
+	// orphaned, then conflicted by another spend of its first input
+	node.extend(1, "h1'")
+	_, err = scanner.SyncOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := testTxStatus(t, txId); record.Status != TxStatusBroadcast || record.Block_height != 0 {
+		t.Fatal("confirmation not rolled back", record)
+	}
+	node.extend(2, "h2'", testChainTx("other", []string{in0.TxId + " 0"}, "51"))
+	_, err = scanner.SyncOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err = tracker.PollOnce()
+	record := testTxStatus(t, txId)
+	if err != nil || changed != 1 || record.Status != TxStatusReplaced || record.Replaced_by != "other" {
+		t.Fatal("replacement not recorded", record, err)
+	}
+
+	// the call fails without an answer of the node
+	node.sendErr = errors.New("connection reset by peer")
+	_, err = BroadcastTransaction(node, rawTrx)
+	if err == nil {
+		t.Fatal("failed call reported as broadcast")
+	}
+	if record = testTxStatus(t, txId); record.Status == TxStatusFailed {
+		t.Fatal("transaction failed without rejection", record)
+	}
+	if pending, _ = GlobalDBMgr.TblUtxoMgr.ListPendingUtxos(""); len(pending) != 1 || pending[0].Pending_txid != txId {
+		t.Fatal("inputs of a possibly sent transaction not reserved", pending)
+	}
+
+	// rejected by the node
+	node.sendErr = errTestRejected
+	_, err = BroadcastTransaction(node, rawTrx)
+	if err == nil {
+		t.Fatal("rejected transaction broadcast")
+	}
+	if record = testTxStatus(t, txId); record.Status != TxStatusFailed || record.Error != node.sendErr.Error() {
+		t.Fatal("rejection not recorded", record)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/mutalisk999/bitcoin-lib/src/transaction"
+	"github.com/mutalisk999/bitcoin-lib/src/utility"
+)
+
+const (
+	// the input script executed successfully
+	InputVerifyStatusVerified = "verified"
+	// a multisig input whose present signatures are valid but not yet enough
+	InputVerifyStatusPartial = "partial"
+	// the input was not signed and therefore not verified
+	InputVerifyStatusUnsigned = "unsigned"
+	InputVerifyStatusFailed   = "failed"
+)
+
+type InputVerification struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// VerificationError returns an error describing the first failed input of
+// report, or nil when no input failed.
+func VerificationError(report []InputVerification) error {
+	for _, v := range report {
+		if v.Status == InputVerifyStatusFailed {
+			return fmt.Errorf("input %d script verification fail: %s", v.Index, v.Error)
+		}
+	}
+	return nil
+}
+
+func toWireMsgTx(trx *transaction.Transaction) (*wire.MsgTx, error) {
+	trxStr, err := BTCPackRawTransaction(*trx)
+	if err != nil {
+		return nil, err
+	}
+	trxBytes, err := hex.DecodeString(trxStr)
+	if err != nil {
+		return nil, err
+	}
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	err = msgTx.Deserialize(bytes.NewReader(trxBytes))
+	if err != nil {
+		return nil, err
+	}
+	return msgTx, nil
+}
+
+// BTCVerifyTransactionInputs runs the inputs of trx through the script
+// interpreter with the standard verification flags. prevScripts and amounts
+// hold the spent output of each input; inputs with a nil prevScript are
+// reported as unsigned. Taproot key path spends are checked against BIP341,
+// which the interpreter of this btcd version does not implement, and prevOuts
+// must then describe every input.
+func BTCVerifyTransactionInputs(trx *transaction.Transaction, prevScripts [][]byte, amounts []int64, prevOuts []transaction.TxOut) ([]InputVerification, error) {
+	if len(prevScripts) != len(trx.Vin) || len(amounts) != len(trx.Vin) {
+		return nil, errors.New("prev scripts count does not match inputs count")
+	}
+	msgTx, err := toWireMsgTx(trx)
+	if err != nil {
+		return nil, err
+	}
+	sigHashes := txscript.NewTxSigHashes(msgTx)
+
+	report := make([]InputVerification, 0, len(trx.Vin))
+	for i := range trx.Vin {
+		v := InputVerification{Index: i, Status: InputVerifyStatusVerified}
+		if prevScripts[i] == nil {
+			v.Status = InputVerifyStatusUnsigned
+			report = append(report, v)
+			continue
+		}
+
+		err := verifyInput(trx, msgTx, sigHashes, i, prevScripts[i], amounts[i], prevOuts)
+		if err != nil {
+			partialErr := verifyPartialMultiSigInput(msgTx, sigHashes, i, prevScripts[i], amounts[i])
+			if partialErr == nil {
+				v.Status = InputVerifyStatusPartial
+			} else {
+				v.Status = InputVerifyStatusFailed
+				v.Error = err.Error()
+			}
+		}
+		report = append(report, v)
+	}
+	return report, nil
+}
+
+func verifyInput(trx *transaction.Transaction, msgTx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int, prevScript []byte, amount int64, prevOuts []transaction.TxOut) error {
+	if len(prevScript) == 34 && prevScript[0] == txscript.OP_1 && prevScript[1] == txscript.OP_DATA_32 {
+		return verifyTaprootKeyPathInput(trx, idx, prevScript[2:], prevOuts)
+	}
+	engine, err := txscript.NewEngine(prevScript, msgTx, idx, txscript.StandardVerifyFlags, nil, sigHashes, amount)
+	if err != nil {
+		return err
+	}
+	return engine.Execute()
+}
+
+func verifyTaprootKeyPathInput(trx *transaction.Transaction, idx int, outputKey []byte, prevOuts []transaction.TxOut) error {
+	if prevOuts == nil {
+		return errors.New("taproot input needs the scriptPubKey and amount of every input")
+	}
+	if len(trx.Vin[idx].ScriptSig.GetScriptBytes()) != 0 {
+		return errors.New("taproot input with non-empty scriptSig")
+	}
+	witness := trx.Vin[idx].ScriptWitness.GetScriptWitnessBytes()
+	if len(witness) != 1 {
+		return errors.New("unsupported taproot witness")
+	}
+	signature := witness[0]
+	hashType := SIGHASH_DEFAULT
+	if len(signature) == 65 {
+		hashType = uint32(signature[64])
+		if hashType == SIGHASH_DEFAULT {
+			return errors.New("invalid taproot sighash type")
+		}
+		signature = signature[:64]
+	} else if len(signature) != 64 {
+		return errors.New("invalid taproot signature size")
+	}
+	hashBytes, err := BTCCalcTaprootSigHash(trx, idx, prevOuts, hashType)
+	if err != nil {
+		return err
+	}
+	if !BTCSchnorrVerify(outputKey, hashBytes, signature) {
+		return errors.New("taproot signature verify fail")
+	}
+	return nil
+}
+
+// verifyPartialMultiSigInput accepts a P2SH, P2WSH or P2SH-P2WSH multisig
+// input carrying fewer signatures than required, as long as the scripts
+// are well formed and every signature present is valid for one of the keys.
+func verifyPartialMultiSigInput(msgTx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int, prevScript []byte, amount int64) error {
+	txIn := msgTx.TxIn[idx]
+	scriptSigPushes, err := txscript.PushedData(txIn.SignatureScript)
+	if err != nil {
+		return err
+	}
+
+	var redeemScript []byte
+	var stack [][]byte
+	isWitness := false
+	switch {
+	case len(txIn.Witness) > 0:
+		isWitness = true
+		redeemScript = txIn.Witness[len(txIn.Witness)-1]
+		stack = txIn.Witness[:len(txIn.Witness)-1]
+		p2wshScriptPubKey := BTCGetWitnessScriptPubKey(0, utility.Sha256(redeemScript))
+		if bytes.Equal(prevScript, p2wshScriptPubKey) {
+			if len(scriptSigPushes) != 0 {
+				return errors.New("p2wsh input with non-empty scriptSig")
+			}
+		} else if !bytes.Equal(prevScript, BTCGetP2SHScriptPubKey(p2wshScriptPubKey)) ||
+			len(scriptSigPushes) != 1 || !bytes.Equal(scriptSigPushes[0], p2wshScriptPubKey) {
+			return errors.New("witness script does not match the spent output")
+		}
+	case len(scriptSigPushes) > 0:
+		redeemScript = scriptSigPushes[len(scriptSigPushes)-1]
+		stack = scriptSigPushes[:len(scriptSigPushes)-1]
+		if !bytes.Equal(prevScript, BTCGetP2SHScriptPubKey(redeemScript)) {
+			return errors.New("redeem script does not match the spent output")
+		}
+	default:
+		return errors.New("empty input script")
+	}
+
+	if txscript.GetScriptClass(redeemScript) != txscript.MultiSigTy {
+		return errors.New("not a multisig redeem script")
+	}
+	_, needCount, err := txscript.CalcMultiSigStats(redeemScript)
+	if err != nil {
+		return err
+	}
+	pubKeys, err := txscript.PushedData(redeemScript)
+	if err != nil {
+		return err
+	}
+	if len(stack) == 0 || len(stack[0]) != 0 {
+		return errors.New("missing multisig dummy element")
+	}
+
+	signatures := stack[1:]
+	if len(signatures) == 0 || len(signatures) >= needCount {
+		return errors.New("not a partially signed multisig input")
+	}
+	for _, signature := range signatures {
+		if len(signature) == 0 {
+			return errors.New("empty multisig signature")
+		}
+		hashType := txscript.SigHashType(signature[len(signature)-1])
+		parsedSig, err := btcec.ParseDERSignature(signature[:len(signature)-1], btcec.S256())
+		if err != nil {
+			return err
+		}
+		var hashBytes []byte
+		if isWitness {
+			hashBytes, err = txscript.CalcWitnessSigHash(redeemScript, sigHashes, hashType, msgTx, idx, amount)
+		} else {
+			hashBytes, err = txscript.CalcSignatureHash(redeemScript, hashType, msgTx, idx)
+		}
+		if err != nil {
+			return err
+		}
+		matched := false
+		for _, pubKeyBytes := range pubKeys {
+			pubKey, err := btcec.ParsePubKey(pubKeyBytes, btcec.S256())
+			if err == nil && parsedSig.Verify(hashBytes, pubKey) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return errors.New("multisig signature does not match any key")
+		}
+	}
+	return nil
+}
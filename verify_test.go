@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/mutalisk999/bitcoin-lib/src/utility"
+	"testing"
+)
+
+func testMultiSigRedeemScript(t *testing.T, needCount int, keyCount int) (string, []string) {
+	privKeyHexList := make([]string, 0)
+	pubKeyStrList := make([]string, 0)
+	for i := 0; i < keyCount; i++ {
+		privKeyHex := testPrivKeyHex(byte(20 + i))
+		privKeyBytes, _ := hex.DecodeString(privKeyHex)
+		_, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), privKeyBytes)
+		privKeyHexList = append(privKeyHexList, privKeyHex)
+		pubKeyStrList = append(pubKeyStrList, hex.EncodeToString(pubKey.SerializeUncompressed()[1:]))
+	}
+	redeemScript, err := BTCGetRedeemScriptByPubKeys(needCount, pubKeyStrList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return redeemScript, privKeyHexList
+}
+
+func TestBTCMultiSignRawTransactionVerification(t *testing.T) {
+	// 2-of-8 redeem script is longer than 255 bytes and needs OP_PUSHDATA2
+	redeemScript, privKeyHexList := testMultiSigRedeemScript(t, 2, 8)
+	redeemScriptBytes, _ := hex.DecodeString(redeemScript)
+	if len(redeemScriptBytes) <= 0xff {
+		t.Fatal("redeem script too short:", len(redeemScriptBytes))
+	}
+
+	rawTrx := testUnsignedTrx(1, 1)
+	signedHex1, report, err := BTCMultiSignRawTransaction(rawTrx, redeemScript, privKeyHexList[0], nil)
+	if err != nil {
+		t.Fatal(err, report)
+	}
+	if len(report) != 1 || report[0].Status != InputVerifyStatusPartial {
+		t.Fatal("unexpected verification report:", report)
+	}
+	signedHex2, _, err := BTCMultiSignRawTransaction(rawTrx, redeemScript, privKeyHexList[3], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// combine both signatures into the final scriptSig
+	signatures := make([][]byte, 0)
+	for _, signedHex := range []string{signedHex1, signedHex2} {
+		trx, _ := BTCUnPackRawTransaction(signedHex)
+		pushes, err := txscript.PushedData(trx.Vin[0].ScriptSig.GetScriptBytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		signatures = append(signatures, pushes[1])
+	}
+	scriptSig := []byte{txscript.OP_0}
+	scriptSig = append(scriptSig, BTCPushData(signatures[0])...)
+	scriptSig = append(scriptSig, BTCPushData(signatures[1])...)
+	scriptSig = append(scriptSig, BTCPushData(redeemScriptBytes)...)
+	trx, _ := BTCUnPackRawTransaction(rawTrx)
+	trx.Vin[0].ScriptSig.SetScriptBytes(scriptSig)
+	combinedHex, _ := BTCPackRawTransaction(*trx)
+
+	report, err = BTCVerifyMultiSignTransaction(combinedHex, redeemScript, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report[0].Status != InputVerifyStatusVerified {
+		t.Fatal("unexpected verification report:", report)
+	}
+
+	// signatures no longer match once an output changes
+	trx.Vout[0].Value++
+	tamperedHex, _ := BTCPackRawTransaction(*trx)
+	report, _ = BTCVerifyMultiSignTransaction(tamperedHex, redeemScript, nil)
+	if report[0].Status != InputVerifyStatusFailed || VerificationError(report) == nil {
+		t.Fatal("tampered transaction verified:", report)
+	}
+}
+
+func TestBTCMultiSignRawTransactionP2WSHVerification(t *testing.T) {
+	redeemScript, privKeyHexList := testMultiSigRedeemScript(t, 2, 3)
+	redeemScriptBytes, _ := hex.DecodeString(redeemScript)
+	p2wsh := BTCGetWitnessScriptPubKey(0, utility.Sha256(redeemScriptBytes))
+
+	rawTrx := testUnsignedTrx(2, 2)
+	trx, _ := BTCUnPackRawTransaction(rawTrx)
+	utxos := make([]UTXODetail, 0)
+	for _, vin := range trx.Vin {
+		utxos = append(utxos, UTXODetail{TxId: vin.PrevOut.Hash.GetHex(), Vout: int(vin.PrevOut.N),
+			ScriptPubKey: hex.EncodeToString(p2wsh), Amount: 40000})
+	}
+	_, report, err := BTCMultiSignRawTransaction(rawTrx, redeemScript, privKeyHexList[1], utxos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range report {
+		if v.Status != InputVerifyStatusPartial {
+			t.Fatal("unexpected verification report:", report)
+		}
+	}
+}
+
+func TestBTCVerifyTransactionInputsUnsigned(t *testing.T) {
+	trx, _ := BTCUnPackRawTransaction(testUnsignedTrx(1, 1))
+	privKeyBytes, _ := hex.DecodeString(testPrivKeyHex(5))
+	scriptPubKeys, _ := BTCKeyScriptPubKeys(privKeyBytes)
+
+	report, err := BTCVerifyTransactionInputs(trx, [][]byte{scriptPubKeys[0]}, []int64{0}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report[0].Status != InputVerifyStatusFailed || report[0].Error == "" {
+		t.Fatal("empty scriptSig verified:", report)
+	}
+	report, _ = BTCVerifyTransactionInputs(trx, [][]byte{nil}, []int64{0}, nil)
+	if report[0].Status != InputVerifyStatusUnsigned {
+		t.Fatal("unexpected verification report:", report)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/mutalisk999/bitcoin-lib/src/base58"
+	"github.com/mutalisk999/bitcoin-lib/src/utility"
+)
+
+var (
+	// SLIP-0132 version bytes of account level extended public keys
+	xpubVersionMain = []byte{0x04, 0x88, 0xb2, 0x1e}
+	ypubVersionMain = []byte{0x04, 0x9d, 0x7c, 0xb2}
+	zpubVersionMain = []byte{0x04, 0xb2, 0x47, 0x46}
+	tpubVersionTest = []byte{0x04, 0x35, 0x87, 0xcf}
+	upubVersionTest = []byte{0x04, 0x4a, 0x52, 0x62}
+	vpubVersionTest = []byte{0x04, 0x5f, 0x1c, 0xf6}
+)
+
+const (
+	XpubScriptTypeP2PKH      = "pkh"
+	XpubScriptTypeP2SHP2WPKH = "sh-wpkh"
+	XpubScriptTypeP2WPKH     = "wpkh"
+	XpubScriptTypeP2TR       = "tr"
+)
+
+// BTCNormalizeExtendedPubKey converts an xpub/ypub/zpub (or the testnet
+// tpub/upub/vpub) into the plain xpub/tpub form and returns the script type
+// implied by its version bytes.
+func BTCNormalizeExtendedPubKey(extKeyStr string) (string, string, error) {
+	decoded, err := base58.Decode(extKeyStr)
+	if err != nil {
+		return "", "", err
+	}
+	if len(decoded) != 82 {
+		return "", "", errors.New("invalid extended key length")
+	}
+	checksum := utility.Sha256(utility.Sha256(decoded[0:78]))
+	if !bytes.Equal(checksum[0:4], decoded[78:82]) {
+		return "", "", errors.New("invalid extended key checksum")
+	}
+
+	version := decoded[0:4]
+	var plainVersion []byte
+	var scriptType string
+	switch {
+	case bytes.Equal(version, xpubVersionMain):
+		plainVersion, scriptType = xpubVersionMain, XpubScriptTypeP2PKH
+	case bytes.Equal(version, ypubVersionMain):
+		plainVersion, scriptType = xpubVersionMain, XpubScriptTypeP2SHP2WPKH
+	case bytes.Equal(version, zpubVersionMain):
+		plainVersion, scriptType = xpubVersionMain, XpubScriptTypeP2WPKH
+	case bytes.Equal(version, tpubVersionTest):
+		plainVersion, scriptType = tpubVersionTest, XpubScriptTypeP2PKH
+	case bytes.Equal(version, upubVersionTest):
+		plainVersion, scriptType = tpubVersionTest, XpubScriptTypeP2SHP2WPKH
+	case bytes.Equal(version, vpubVersionTest):
+		plainVersion, scriptType = tpubVersionTest, XpubScriptTypeP2WPKH
+	default:
+		return "", "", errors.New("unsupported extended public key version")
+	}
+
+	normalized := make([]byte, 0, 82)
+	normalized = append(normalized, plainVersion...)
+	normalized = append(normalized, decoded[4:78]...)
+	checksum = utility.Sha256(utility.Sha256(normalized))
+	normalized = append(normalized, checksum[0:4]...)
+	return base58.Encode(normalized), scriptType, nil
+}
+
+// BTCXpubChainDescriptor returns the ranged descriptor of the receive
+// (change=false) or change chain of an account level xpub.
+func BTCXpubChainDescriptor(xpub string, scriptType string, change bool) (*Descriptor, error) {
+	chain := 0
+	if change {
+		chain = 1
+	}
+	keyExpr := fmt.Sprintf("%s/%d/*", xpub, chain)
+
+	descStr := ""
+	switch scriptType {
+	case XpubScriptTypeP2PKH:
+		descStr = "pkh(" + keyExpr + ")"
+	case XpubScriptTypeP2SHP2WPKH:
+		descStr = "sh(wpkh(" + keyExpr + "))"
+	case XpubScriptTypeP2WPKH:
+		descStr = "wpkh(" + keyExpr + ")"
+	case XpubScriptTypeP2TR:
+		descStr = "tr(" + keyExpr + ")"
+	default:
+		return nil, errors.New("unsupported script type: " + scriptType)
+	}
+	return BTCParseDescriptor(descStr, false)
+}
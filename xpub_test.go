@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBTCNormalizeExtendedPubKey(t *testing.T) {
+	// BIP84 test vectors
+	zpub := "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+	xpub, scriptType, err := BTCNormalizeExtendedPubKey(zpub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scriptType != XpubScriptTypeP2WPKH || xpub[0:4] != "xpub" {
+		t.Error("unexpected normalized key:", xpub, scriptType)
+	}
+
+	receiveDesc, err := BTCXpubChainDescriptor(xpub, scriptType, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err := receiveDesc.DeriveAddresses(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived[0].Address != "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu" ||
+		derived[1].Address != "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g" {
+		t.Error("unexpected receive addresses:", derived)
+	}
+
+	changeDesc, err := BTCXpubChainDescriptor(xpub, scriptType, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err = changeDesc.DeriveAddresses(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived[0].Address != "bc1q8c6fshw2dlwun7ekn9qwf37cu2rn755upcp6el" {
+		t.Error("unexpected change address:", derived)
+	}
+
+	_, _, err = BTCNormalizeExtendedPubKey(zpub[:len(zpub)-1] + "t")
+	if err == nil {
+		t.Error("invalid checksum accepted")
+	}
+}